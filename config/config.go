@@ -27,6 +27,8 @@ type (
 		Password       string `json:"password"`
 		ReplicaSetName string `json:"replicasetname"`
 		Source         string `json:"source"`
+		// Options 附加的连接参数，目前用于 mongo，键名与 MongoDB 连接字符串的参数相同，例如 maxPoolSize、readPreference
+		Options map[string]string `json:"options"`
 	}
 	// Storage env struct for config
 	Storage struct {
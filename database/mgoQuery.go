@@ -1,65 +1,225 @@
 package database
 
 import (
+	"context"
+	"strings"
 	"time"
 
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	rxmongo "rxcsoft.cn/utils/mongo"
+)
+
+type (
+	// CollectionInfo 创建集合的配置，对应原 mgo.CollectionInfo
+	// 新版 mongodb 不再支持 DisableIdIndex、ForceIdIndex，已去掉
+	CollectionInfo struct {
+		// Capped 固定大小的集合，需要同时设置 MaxBytes
+		Capped   bool
+		MaxBytes int
+		MaxDocs  int
+
+		// Validator 文档的校验规则
+		Validator        interface{}
+		ValidationLevel  string
+		ValidationAction string
+
+		// Collation 集合默认的排序规则
+		Collation *options.Collation
+	}
+
+	// Index 索引的定义，对应原 mgo.Index
+	Index struct {
+		// Key 索引键，与 mgo 相同，"-" 开头为降序，
+		// "$text:"、"$2d:"、"$2dsphere:"、"$hashed:" 开头为对应类型的索引
+		Key        []string
+		Unique     bool
+		Background bool // mongodb 4.2 以后无效，保留以兼容
+		Sparse     bool
+
+		// PartialFilter 部分索引的条件
+		PartialFilter bson.M
+
+		// ExpireAfter TTL 索引的过期时间，精度为秒
+		ExpireAfter time.Duration
+
+		// Name 索引名，为空时按键生成
+		Name string
+
+		// DefaultLanguage、Weights 文本索引的配置
+		DefaultLanguage string
+		Weights         map[string]int
+
+		Collation *options.Collation
+	}
+)
+
+var (
+	// ErrNotFound MongoUpdate、MongoRemove 没有匹配的数据时返回的错误，对应原 mgo.ErrNotFound
+	ErrNotFound = mongo.ErrNoDocuments
+
+	// auditSink 审计记录的保存位置，为 nil 时不记录
+	auditSink rxmongo.AuditSink
 )
 
+// SetAuditSink 设置审计记录的保存位置，设置后 MongoInsert、MongoUpdate、MongoRemove 同时保存审计记录
+// 为 nil 时不记录，操作者通过 *Ctx 函数的 context 传入，见 mongo.WithActor
+func SetAuditSink(sink rxmongo.AuditSink) {
+	auditSink = sink
+}
+
 // MongoInsert 插入数据
 func MongoInsert(collection string, data ...interface{}) error {
+	return MongoInsertCtx(context.Background(), collection, data...)
+}
+
+// MongoInsertCtx 插入数据，ctx 中可以设置审计的操作者
+func MongoInsertCtx(ctx context.Context, collection string, data ...interface{}) error {
 	s, sc := BeginMongo()
 	c := sc.DB(Db).C(collection)
 	defer sc.Close()
 
-	if err := c.Insert(data...); err != nil {
+	ctx, cancel := mongoContext(ctx)
+	defer cancel()
+
+	res, err := c.InsertMany(ctx, data)
+	if err != nil {
 		log.Errorf("error MongoInsert %v collection: %v", err, collection)
 		return err
 	}
 
+	if auditSink != nil {
+		for i, id := range res.InsertedIDs {
+			if err := rxmongo.RecordAudit(ctx, auditSink, Db, collection, rxmongo.AuditInsert, id, nil, data[i]); err != nil {
+				return err
+			}
+		}
+	}
+
 	log.Infof("MongoInsert took: %v collection: %v len(%v)", time.Since(s), collection, len(data))
 	return nil
 }
 
-// MongoUpdate 更新数据
+// MongoUpdate 更新一条数据，update 不是 $set 等操作符时与 mgo 相同替换整个文档
+// 没有匹配的数据时返回 ErrNotFound
 func MongoUpdate(collection string, selector interface{}, update interface{}) error {
+	return MongoUpdateCtx(context.Background(), collection, selector, update)
+}
+
+// MongoUpdateCtx 更新一条数据，ctx 中可以设置审计的操作者
+func MongoUpdateCtx(ctx context.Context, collection string, selector interface{}, update interface{}) error {
 	s, sc := BeginMongo()
 	c := sc.DB(Db).C(collection)
 	defer sc.Close()
 
-	if err := c.Update(selector, update); err != nil {
+	ctx, cancel := mongoContext(ctx)
+	defer cancel()
+
+	var before bson.M
+	if auditSink != nil {
+		var err error
+		if before, err = findOne(ctx, c, selector); err != nil {
+			log.Errorf("error MongoUpdate %v collection: %v", err, collection)
+			return err
+		}
+		if before == nil {
+			log.Errorf("error MongoUpdate %v collection: %v", ErrNotFound, collection)
+			return ErrNotFound
+		}
+		selector = rxmongo.ByID(selector, before["_id"])
+	}
+
+	var (
+		res *mongo.UpdateResult
+		err error
+	)
+	if isReplacement(update) {
+		res, err = c.ReplaceOne(ctx, selector, update)
+	} else {
+		res, err = c.UpdateOne(ctx, selector, update)
+	}
+	if err == nil && res.MatchedCount == 0 {
+		err = ErrNotFound
+	}
+	if err != nil {
 		log.Errorf("error MongoUpdate %v collection: %v", err, collection)
 		return err
 	}
 
+	if before != nil {
+		id := before["_id"]
+		after, err := findOne(ctx, c, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if err := rxmongo.RecordAudit(ctx, auditSink, Db, collection, rxmongo.AuditUpdate, id, before, after); err != nil {
+			return err
+		}
+	}
+
 	log.Infof("MongoUpdate took: %v collection: %v", time.Since(s), collection)
 	return nil
 }
 
-// MongoRemove 删除集合
+// MongoRemove 删除一条数据，没有匹配的数据时返回 ErrNotFound
 func MongoRemove(collection string, selector interface{}) error {
+	return MongoRemoveCtx(context.Background(), collection, selector)
+}
+
+// MongoRemoveCtx 删除一条数据，ctx 中可以设置审计的操作者
+func MongoRemoveCtx(ctx context.Context, collection string, selector interface{}) error {
 	s, sc := BeginMongo()
 	c := sc.DB(Db).C(collection)
 	defer sc.Close()
 
-	if err := c.Remove(selector); err != nil {
+	ctx, cancel := mongoContext(ctx)
+	defer cancel()
+
+	var before bson.M
+	if auditSink != nil {
+		var err error
+		if before, err = findOne(ctx, c, selector); err != nil {
+			log.Errorf("error MongoRemove on selector %v", err)
+			return err
+		}
+		if before == nil {
+			log.Errorf("error MongoRemove on selector %v", ErrNotFound)
+			return ErrNotFound
+		}
+		selector = rxmongo.ByID(selector, before["_id"])
+	}
+
+	res, err := c.DeleteOne(ctx, selector)
+	if err == nil && res.DeletedCount == 0 {
+		err = ErrNotFound
+	}
+	if err != nil {
 		log.Errorf("error MongoRemove on selector %v", err)
 		return err
 	}
 
+	if before != nil {
+		if err := rxmongo.RecordAudit(ctx, auditSink, Db, collection, rxmongo.AuditDelete, before["_id"], before, nil); err != nil {
+			return err
+		}
+	}
+
 	log.Infof("MongoRemove took: %v", time.Since(s))
 	return nil
 }
 
-// MongoCreateCollection 创建集合
-func MongoCreateCollection(collection string, info *mgo.CollectionInfo) error {
+// MongoCreateCollection 创建集合，info 为 nil 时使用默认配置
+func MongoCreateCollection(collection string, info *CollectionInfo) error {
 	s, sc := BeginMongo()
-	c := sc.DB(Db).C(collection)
 	defer sc.Close()
 
-	// info := mgo.CollectionInfo{ForceIdIndex: false, DisableIdIndex: true}
-	if err := c.Create(info); err != nil {
+	ctx, cancel := mongoContext(context.Background())
+	defer cancel()
+
+	if err := sc.DB(Db).RunCommand(ctx, createCommand(collection, info)).Err(); err != nil {
 		log.Errorf("error MongoCreateCollection %v", err)
 		return err
 	}
@@ -70,28 +230,37 @@ func MongoCreateCollection(collection string, info *mgo.CollectionInfo) error {
 }
 
 // CountCollection 从集合中获取合计数
-func CountCollection(collection string, query bson.M) int {
+func CountCollection(collection string, query interface{}) int {
 	s, sc := BeginMongo()
 	c := sc.DB(Db).C(collection)
 	defer sc.Close()
 
-	count, err := c.Find(query).Count()
+	ctx, cancel := mongoContext(context.Background())
+	defer cancel()
+
+	if query == nil {
+		query = bson.M{}
+	}
+	count, err := c.CountDocuments(ctx, query)
 	if err != nil {
 		log.Errorf("error CountCollection %v", err)
 		return 0
 	}
 
 	log.Infof("CountCollection took: %v items(%v)", time.Since(s), count)
-	return count
+	return int(count)
 }
 
-// MongoCreateIndex 创建索引
-func MongoCreateIndex(collection string, index mgo.Index) error {
+// MongoCreateIndex 创建索引，已存在相同的索引时不做任何处理
+func MongoCreateIndex(collection string, index Index) error {
 	s, sc := BeginMongo()
 	c := sc.DB(Db).C(collection)
 	defer sc.Close()
 
-	if err := c.EnsureIndex(index); err != nil {
+	ctx, cancel := mongoContext(context.Background())
+	defer cancel()
+
+	if _, err := c.Indexes().CreateOne(ctx, index.Spec().Model()); err != nil {
 		log.Errorf("error MongoEnsureIndex %v", err)
 		return err
 	}
@@ -99,3 +268,107 @@ func MongoCreateIndex(collection string, index mgo.Index) error {
 	log.Infof("MongoEnsureIndex took: %v", time.Since(s))
 	return nil
 }
+
+// Spec 转换为 mongo 包的索引声明
+func (i Index) Spec() rxmongo.IndexSpec {
+	spec := rxmongo.IndexSpec{
+		Name:            i.Name,
+		Unique:          i.Unique,
+		Sparse:          i.Sparse,
+		TTL:             i.ExpireAfter,
+		Partial:         i.PartialFilter,
+		Collation:       i.Collation,
+		DefaultLanguage: i.DefaultLanguage,
+	}
+	for _, key := range i.Key {
+		field, value := parseIndexKey(key)
+		spec.Keys = append(spec.Keys, bson.E{Key: field, Value: value})
+	}
+	if len(i.Weights) > 0 {
+		spec.Weights = bson.M{}
+		for field, w := range i.Weights {
+			spec.Weights[field] = w
+		}
+	}
+	return spec
+}
+
+// parseIndexKey 解析 mgo 格式的索引键，返回字段名和索引的类型
+func parseIndexKey(key string) (string, interface{}) {
+	if strings.HasPrefix(key, "$") {
+		if i := strings.Index(key, ":"); i > 0 {
+			return key[i+1:], key[1:i]
+		}
+	}
+	switch {
+	case strings.HasPrefix(key, "-"):
+		return key[1:], -1
+	case strings.HasPrefix(key, "+"):
+		return key[1:], 1
+	case strings.HasPrefix(key, "@"):
+		return key[1:], "2d"
+	}
+	return key, 1
+}
+
+// createCommand 创建集合的命令
+func createCommand(collection string, info *CollectionInfo) bson.D {
+	cmd := bson.D{{Key: "create", Value: collection}}
+	if info == nil {
+		return cmd
+	}
+
+	if info.Capped {
+		cmd = append(cmd, bson.E{Key: "capped", Value: true})
+		if info.MaxBytes > 0 {
+			cmd = append(cmd, bson.E{Key: "size", Value: info.MaxBytes})
+		}
+		if info.MaxDocs > 0 {
+			cmd = append(cmd, bson.E{Key: "max", Value: info.MaxDocs})
+		}
+	}
+	if info.Validator != nil {
+		cmd = append(cmd, bson.E{Key: "validator", Value: info.Validator})
+	}
+	if info.ValidationLevel != "" {
+		cmd = append(cmd, bson.E{Key: "validationLevel", Value: info.ValidationLevel})
+	}
+	if info.ValidationAction != "" {
+		cmd = append(cmd, bson.E{Key: "validationAction", Value: info.ValidationAction})
+	}
+	if info.Collation != nil {
+		cmd = append(cmd, bson.E{Key: "collation", Value: info.Collation.ToDocument()})
+	}
+	return cmd
+}
+
+// findOne 从 primary 读取一条文档，不存在时返回 nil，用于审计时读取写入前后的文档
+func findOne(ctx context.Context, c *mongo.Collection, filter interface{}) (bson.M, error) {
+	c, err := c.Clone(options.Collection().SetReadPreference(readpref.Primary()))
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	if err := c.FindOne(ctx, filter).Decode(&doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return doc, nil
+}
+
+// isReplacement 判断是否为替换整个文档，即第一个键不是 $ 开头的操作符
+func isReplacement(update interface{}) bool {
+	doc, err := bson.Marshal(update)
+	if err != nil {
+		// 聚合管道等不能转换为文档的更新交给驱动处理
+		return false
+	}
+	elems, err := bson.Raw(doc).Elements()
+	if err != nil || len(elems) == 0 {
+		return false
+	}
+	return !strings.HasPrefix(elems[0].Key(), "$")
+}
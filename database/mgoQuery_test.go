@@ -0,0 +1,88 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestIndexSpec(t *testing.T) {
+	tests := []struct {
+		index    Index
+		wantName string
+		wantKeys string
+	}{
+		{Index{Key: []string{"customer_id", "-created_at"}}, "customer_id_1_created_at_-1", "[{customer_id 1} {created_at -1}]"},
+		{Index{Key: []string{"+no"}, Unique: true}, "no_1", "[{no 1}]"},
+		{Index{Key: []string{"$text:title", "$text:body"}}, "title_text_body_text", "[{title text} {body text}]"},
+		{Index{Key: []string{"$2dsphere:location"}, Name: "geo"}, "geo", "[{location 2dsphere}]"},
+		{Index{Key: []string{"@point", "$hashed:shard"}}, "point_2d_shard_hashed", "[{point 2d} {shard hashed}]"},
+	}
+	for _, tt := range tests {
+		spec := tt.index.Spec()
+		if got := spec.IndexName(); got != tt.wantName {
+			t.Errorf("Spec(%v).IndexName() = %v, want %v", tt.index.Key, got, tt.wantName)
+		}
+		if got := fmt.Sprint(spec.Keys); got != tt.wantKeys {
+			t.Errorf("Spec(%v).Keys = %v, want %v", tt.index.Key, got, tt.wantKeys)
+		}
+	}
+
+	spec := Index{Key: []string{"expire_at"}, ExpireAfter: time.Hour, Weights: map[string]int{"title": 2}}.Spec()
+	if spec.TTL != time.Hour || spec.Weights["title"] != 2 {
+		t.Errorf("Spec() = %+v", spec)
+	}
+}
+
+func TestCreateCommand(t *testing.T) {
+	if got := fmt.Sprint(createCommand("logs", nil)); got != "[{create logs}]" {
+		t.Errorf("createCommand(nil) = %v", got)
+	}
+
+	info := &CollectionInfo{Capped: true, MaxBytes: 1024, MaxDocs: 10, ValidationLevel: "moderate"}
+	want := "[{create logs} {capped true} {size 1024} {max 10} {validationLevel moderate}]"
+	if got := fmt.Sprint(createCommand("logs", info)); got != want {
+		t.Errorf("createCommand() = %v, want %v", got, want)
+	}
+}
+
+func TestIsReplacement(t *testing.T) {
+	tests := []struct {
+		update interface{}
+		want   bool
+	}{
+		{bson.M{"$set": bson.M{"name": "a"}}, false},
+		{bson.D{{Key: "$inc", Value: bson.M{"n": 1}}, {Key: "$set", Value: bson.M{"name": "a"}}}, false},
+		{bson.M{"name": "a"}, true},
+		{struct{ Name string }{"a"}, true},
+		{[]bson.M{{"$set": bson.M{"name": "a"}}}, false},
+	}
+	for _, tt := range tests {
+		if got := isReplacement(tt.update); got != tt.want {
+			t.Errorf("isReplacement(%v) = %v, want %v", tt.update, got, tt.want)
+		}
+	}
+}
+
+func TestSessionReadPreference(t *testing.T) {
+	// mongo 包默认的 secondaryPreferred 连接
+	cli, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:1").SetReadPreference(readpref.SecondaryPreferred()))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	s := &Session{Client: cli, ReadPreference: readpref.Primary()}
+	if mode := s.DB("Db").ReadPreference().Mode(); mode != readpref.PrimaryMode {
+		t.Errorf("DB() read preference = %v, want primary", mode)
+	}
+
+	s.ReadPreference = nil
+	if mode := s.DB("Db").ReadPreference().Mode(); mode != readpref.SecondaryPreferredMode {
+		t.Errorf("DB() without ReadPreference = %v, want the client setting", mode)
+	}
+}
@@ -1,97 +1,70 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/globalsign/mgo"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
 	"rxcsoft.cn/utils/config"
+	rxmongo "rxcsoft.cn/utils/mongo"
+)
+
+type (
+	// Session 兼容原 mgo.Session 用法的连接，内部为官方驱动的连接池
+	// 驱动自己管理连接池，不需要复制会话，Close 不会断开连接
+	Session struct {
+		*mongo.Client
+		// ReadPreference DB 返回的数据库读的偏好，StartMongodb 设为 primary，
+		// 与原 mgo 默认的 Strong 模式相同，保证能读到自己刚写入的数据；为 nil 时使用连接的设置
+		ReadPreference *readpref.ReadPref
+	}
+
+	// Database 兼容原 mgo.Database 用法的数据库
+	Database struct {
+		*mongo.Database
+	}
 )
 
 var (
 	// MongodbSession 会话
-	MongodbSession *mgo.Session
+	MongodbSession *Session
 
 	// Db 当前的db名称
 	Db string
-
-	// ensureMaxWrite 默认最大写入条数
-	ensureMaxWrite = 1
-
-	// maxSyncTimeout 默认最大同步超时次数
-	maxSyncTimeout time.Duration = 1
 )
 
-const ()
-
-// StartMongodb 启动mongodb的连接
+// StartMongodb 启动mongodb的连接，与 mongo 包共用同一个连接
+// mongo 包已经连接时直接使用其连接，不会重新连接，也不会修改 mongo 包的 Db
+// 不论连接的读偏好如何（mongo 包默认 secondaryPreferred），本包的读写都在 primary 上执行，见 Session.ReadPreference
 func StartMongodb(env config.DB) {
-	cfe, host := getMongodbHost(env)
-
-	mongoDBDialInfo := buildMongodBconn(cfe, host)
-	session, err := mgo.DialWithInfo(&mongoDBDialInfo)
-	if err != nil {
-		panic(fmt.Sprintf("failed to connect mongodb:%v", err))
+	if cli := rxmongo.New(); cli != nil {
+		MongodbSession = &Session{Client: cli, ReadPreference: readpref.Primary()}
+		setDatabaseName(env)
+		log.Infof("reuse mongodb connection of mongo package (db:%s)", Db)
+		return
 	}
-	MongodbSession = session
 
+	// 与原 mgo 的 Strong 模式相同，从 primary 读取
+	opts := []rxmongo.Option{rxmongo.WithReadPreference(readpref.Primary())}
 	// 开启调试
 	if os.Getenv("MONGODB_DEBUG") == "1" {
-		mgo.SetDebug(true)
-	}
-
-	setDatabaseName(cfe)
-	log.Infof(fmt.Sprintf("connected to mongodb... %v(db:%s)", host, Db))
-
-	printLiveServers(session)
-}
-
-// 构建db连接信息
-func buildMongodBconn(cfe config.DB, hosts string) mgo.DialInfo {
-	mongodbHosts := splitMongodbInstances(hosts)
-	ensureMaxWrite = len(mongodbHosts)
-	for i, host := range mongodbHosts {
-		if !strings.Contains(host, ":") {
-			mongodbHosts[i] = fmt.Sprintf("%s:%s", host, cfe.Port)
-		}
-	}
-	log.Infof("connecting to mongodb.. %s", mongodbHosts)
-	conn := mgo.DialInfo{
-		Addrs:     mongodbHosts,
-		Timeout:   10 * time.Second,
-		Source:    "admin",
-		Username:  cfe.Username,
-		PoolLimit: 1000,
-		Password:  cfe.Password,
-
-		Direct: false,
+		opts = append(opts, rxmongo.WithMonitor(rxmongo.DebugMonitor()))
 	}
 
-	if cfe.ReplicaSetName != "" {
-		conn.ReplicaSetName = cfe.ReplicaSetName
-	}
-
-	if cfe.Source != "" {
-		conn.Source = cfe.Source
-	}
-
-	return conn
-}
-
-// splitMongodbInstances 将mongodb实例字符串拆分为切片
-func splitMongodbInstances(instances string) []string {
-	var hosts []string
-	for _, host := range strings.Split(instances, ",") {
-		hosts = append(hosts, host)
+	log.Infof("connecting to mongodb.. %s", env.Host)
+	if err := rxmongo.Start(env, opts...); err != nil {
+		panic(fmt.Sprintf("failed to connect mongodb:%v", err))
 	}
+	MongodbSession = &Session{Client: rxmongo.New(), ReadPreference: readpref.Primary()}
 
-	return hosts
-}
-
-func getMongodbHost(env config.DB) (config.DB, string) {
-	return env, fmt.Sprintf("%v", env.Host)
+	setDatabaseName(env)
+	log.Infof(fmt.Sprintf("connected to mongodb... %v(db:%s)", env.Host, Db))
 }
 
 // IsConnected 判断是否连接
@@ -105,15 +78,26 @@ func IsConnected() bool {
 	return connected
 }
 
-// SessionCopy 制作一个mongodb会话的副本
-func SessionCopy() *mgo.Session {
-	MongodbSession.Refresh()
+// SessionCopy 返回mongodb的会话，驱动自己管理连接池，所有调用共用同一个连接
+func SessionCopy() *Session {
+	return MongodbSession
+}
+
+// Close 兼容 mgo 的用法，连接由连接池回收，不做任何处理
+func (s *Session) Close() {}
 
-	sc := MongodbSession.Copy()
+// DB 获取数据库，读的偏好使用 s.ReadPreference
+func (s *Session) DB(name string) *Database {
+	opts := options.Database()
+	if s.ReadPreference != nil {
+		opts.SetReadPreference(s.ReadPreference)
+	}
+	return &Database{s.Database(name, opts)}
+}
 
-	sc.SetSyncTimeout(maxSyncTimeout * time.Second)
-	sc.SetSocketTimeout(maxSyncTimeout * time.Hour)
-	return sc
+// C 获取集合
+func (d *Database) C(name string) *mongo.Collection {
+	return d.Collection(name)
 }
 
 // GetDBName 获取带后缀的DB名称
@@ -125,21 +109,16 @@ func GetDBName(suffix string) string {
 	return name.String()
 }
 
-// 打印live情报
-func printLiveServers(session *mgo.Session) {
-	log.Infof("mongodb liveServers=%v", session.LiveServers())
-}
-
 // BeginMongo 开启mongodb会话
-func BeginMongo() (time.Time, *mgo.Session) {
+func BeginMongo() (time.Time, *Session) {
 	return time.Now(), SessionCopy()
 }
 
 // BeginMongoConn 开启mongoBD连接信息
 type BeginMongoConn struct {
 	Time time.Time
-	Conn *mgo.Session
-	Col  *mgo.Collection
+	Conn *Session
+	Col  *mongo.Collection
 }
 
 // BeginMongoWCol 开启一个关联到集合的连接
@@ -150,6 +129,11 @@ func BeginMongoWCol() func(string) BeginMongoConn {
 	}
 }
 
+// mongoContext 每个操作的超时时间，与原 mgo 会话的 socket 超时相同
+func mongoContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, time.Hour)
+}
+
 // setDatabaseName 设置DB名称
 func setDatabaseName(env config.DB) error {
 	if env.Database != "" {
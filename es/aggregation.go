@@ -0,0 +1,274 @@
+package es
+
+import (
+	"context"
+	"fmt"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+type (
+	// AggType 聚合类型
+	AggType string
+
+	// AggSpec 聚合定义，Aggs 为子聚合，只有桶聚合可以包含子聚合
+	//
+	//	specs := []es.AggSpec{{
+	//	    Name: "by_month", Type: es.AggDateHistogram, Field: "start_date", Interval: "month",
+	//	    Aggs: []es.AggSpec{
+	//	        {Name: "by_status", Type: es.AggTerms, Field: "status", Size: 10,
+	//	            Aggs: []es.AggSpec{{Name: "total", Type: es.AggSum, Field: "amount"}}},
+	//	    },
+	//	}}
+	AggSpec struct {
+		Name     string      // 聚合名
+		Type     AggType     // 聚合类型
+		Field    string      // 字段名
+		Size     int         // terms 的桶数量，0 时使用 ES 默认值
+		Interval string      // date_histogram 的日历间隔，例如 day、month、year
+		Format   string      // date_histogram 的 key 格式，例如 yyyy-MM
+		TimeZone string      // date_histogram 的时区，例如 Asia/Tokyo
+		Missing  interface{} // 字段不存在时使用的值
+		Aggs     []AggSpec   // 子聚合
+	}
+
+	// AggResult 聚合结果，指标聚合使用 Value，桶聚合使用 Buckets
+	AggResult struct {
+		Value   *float64 // 指标值，没有文档时为 nil
+		Buckets []Bucket // 桶
+	}
+
+	// Bucket 聚合的单个桶
+	Bucket struct {
+		Key         interface{}           // 桶的 key，date_histogram 时为毫秒时间戳
+		KeyAsString string                // 格式化后的 key
+		DocCount    int64                 // 文档数
+		Aggs        map[string]*AggResult // 子聚合结果
+	}
+)
+
+const (
+	// AggTerms 按字段值分组
+	AggTerms AggType = "terms"
+	// AggDateHistogram 按日期间隔分组
+	AggDateHistogram AggType = "date_histogram"
+	// AggSum 合计
+	AggSum AggType = "sum"
+	// AggAvg 平均值
+	AggAvg AggType = "avg"
+	// AggMin 最小值
+	AggMin AggType = "min"
+	// AggMax 最大值
+	AggMax AggType = "max"
+	// AggValueCount 值的个数
+	AggValueCount AggType = "value_count"
+	// AggCardinality 去重后的个数
+	AggCardinality AggType = "cardinality"
+)
+
+// isBucket 是否为桶聚合
+func (t AggType) isBucket() bool {
+	return t == AggTerms || t == AggDateHistogram
+}
+
+// BuildAggregation 根据定义生成聚合，包括所有子聚合
+func BuildAggregation(spec AggSpec) (elastic.Aggregation, error) {
+	if len(spec.Aggs) > 0 && !spec.Type.isBucket() {
+		return nil, fmt.Errorf("es aggregation %s: %s can not have sub aggregations", spec.Name, spec.Type)
+	}
+
+	subs := make(map[string]elastic.Aggregation)
+	for _, sub := range spec.Aggs {
+		agg, err := BuildAggregation(sub)
+		if err != nil {
+			return nil, err
+		}
+		subs[sub.Name] = agg
+	}
+
+	switch spec.Type {
+	case AggTerms:
+		agg := elastic.NewTermsAggregation().Field(spec.Field)
+		if spec.Size > 0 {
+			agg = agg.Size(spec.Size)
+		}
+		if spec.Missing != nil {
+			agg = agg.Missing(spec.Missing)
+		}
+		for name, sub := range subs {
+			agg = agg.SubAggregation(name, sub)
+		}
+		return agg, nil
+	case AggDateHistogram:
+		agg := elastic.NewDateHistogramAggregation().Field(spec.Field).CalendarInterval(spec.Interval)
+		if spec.Format != "" {
+			agg = agg.Format(spec.Format)
+		}
+		if spec.TimeZone != "" {
+			agg = agg.TimeZone(spec.TimeZone)
+		}
+		if spec.Missing != nil {
+			agg = agg.Missing(spec.Missing)
+		}
+		for name, sub := range subs {
+			agg = agg.SubAggregation(name, sub)
+		}
+		return agg, nil
+	case AggSum:
+		agg := elastic.NewSumAggregation().Field(spec.Field)
+		if spec.Missing != nil {
+			agg = agg.Missing(spec.Missing)
+		}
+		return agg, nil
+	case AggAvg:
+		agg := elastic.NewAvgAggregation().Field(spec.Field)
+		if spec.Missing != nil {
+			agg = agg.Missing(spec.Missing)
+		}
+		return agg, nil
+	case AggMin:
+		agg := elastic.NewMinAggregation().Field(spec.Field)
+		if spec.Missing != nil {
+			agg = agg.Missing(spec.Missing)
+		}
+		return agg, nil
+	case AggMax:
+		agg := elastic.NewMaxAggregation().Field(spec.Field)
+		if spec.Missing != nil {
+			agg = agg.Missing(spec.Missing)
+		}
+		return agg, nil
+	case AggValueCount:
+		return elastic.NewValueCountAggregation().Field(spec.Field), nil
+	case AggCardinality:
+		agg := elastic.NewCardinalityAggregation().Field(spec.Field)
+		if spec.Missing != nil {
+			agg = agg.Missing(spec.Missing)
+		}
+		return agg, nil
+	}
+
+	return nil, fmt.Errorf("es aggregation %s: unknown type %q", spec.Name, spec.Type)
+}
+
+// DecodeAggregations 按定义解码聚合结果
+func DecodeAggregations(aggs elastic.Aggregations, specs []AggSpec) (map[string]*AggResult, error) {
+	result := make(map[string]*AggResult, len(specs))
+
+	for _, spec := range specs {
+		r, err := decodeAggregation(aggs, spec)
+		if err != nil {
+			return nil, err
+		}
+		result[spec.Name] = r
+	}
+
+	return result, nil
+}
+
+// decodeAggregation 解码单个聚合
+func decodeAggregation(aggs elastic.Aggregations, spec AggSpec) (*AggResult, error) {
+	var (
+		metric *elastic.AggregationValueMetric
+		found  bool
+	)
+
+	switch spec.Type {
+	case AggTerms:
+		items, ok := aggs.Terms(spec.Name)
+		if !ok {
+			return nil, fmt.Errorf("es aggregation %s: not found in result", spec.Name)
+		}
+		r := &AggResult{}
+		for _, b := range items.Buckets {
+			bucket := Bucket{Key: b.Key, DocCount: b.DocCount}
+			if b.KeyAsString != nil {
+				bucket.KeyAsString = *b.KeyAsString
+			} else {
+				bucket.KeyAsString = fmt.Sprint(b.Key)
+			}
+			sub, err := DecodeAggregations(b.Aggregations, spec.Aggs)
+			if err != nil {
+				return nil, err
+			}
+			bucket.Aggs = sub
+			r.Buckets = append(r.Buckets, bucket)
+		}
+		return r, nil
+	case AggDateHistogram:
+		items, ok := aggs.DateHistogram(spec.Name)
+		if !ok {
+			return nil, fmt.Errorf("es aggregation %s: not found in result", spec.Name)
+		}
+		r := &AggResult{}
+		for _, b := range items.Buckets {
+			bucket := Bucket{Key: int64(b.Key), DocCount: b.DocCount}
+			if b.KeyAsString != nil {
+				bucket.KeyAsString = *b.KeyAsString
+			}
+			sub, err := DecodeAggregations(b.Aggregations, spec.Aggs)
+			if err != nil {
+				return nil, err
+			}
+			bucket.Aggs = sub
+			r.Buckets = append(r.Buckets, bucket)
+		}
+		return r, nil
+	case AggSum:
+		metric, found = aggs.Sum(spec.Name)
+	case AggAvg:
+		metric, found = aggs.Avg(spec.Name)
+	case AggMin:
+		metric, found = aggs.Min(spec.Name)
+	case AggMax:
+		metric, found = aggs.Max(spec.Name)
+	case AggValueCount:
+		metric, found = aggs.ValueCount(spec.Name)
+	case AggCardinality:
+		metric, found = aggs.Cardinality(spec.Name)
+	default:
+		return nil, fmt.Errorf("es aggregation %s: unknown type %q", spec.Name, spec.Type)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("es aggregation %s: not found in result", spec.Name)
+	}
+
+	return &AggResult{Value: metric.Value}, nil
+}
+
+// ESAggregate 执行聚合检索，只返回聚合结果，不返回文档
+func (c *Client) ESAggregate(indexName string, query elastic.Query, specs []AggSpec) (map[string]*AggResult, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	search := client.Search().Index(indexName).Size(0)
+	if query != nil {
+		search = search.Query(query)
+	}
+	for _, spec := range specs {
+		agg, err := BuildAggregation(spec)
+		if err != nil {
+			return nil, err
+		}
+		search = search.Aggregation(spec.Name, agg)
+	}
+
+	ctx := context.Background()
+	searchResult, err := search.Do(ctx)
+	if err != nil {
+		log.Errorf("ES aggregate error: %v", err)
+		return nil, err
+	}
+
+	result, err := DecodeAggregations(searchResult.Aggregations, specs)
+	if err != nil {
+		log.Errorf("ES decode aggregation error: %v", err)
+		return nil, err
+	}
+
+	log.Infof("ES aggregate took %d milliseconds\n", searchResult.TookInMillis)
+	return result, nil
+}
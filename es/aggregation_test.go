@@ -0,0 +1,79 @@
+package es
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+)
+
+func TestDecodeAggregations(t *testing.T) {
+	const body = `{
+		"by_month": {
+			"buckets": [
+				{
+					"key_as_string": "2022-01",
+					"key": 1640995200000,
+					"doc_count": 3,
+					"by_status": {
+						"buckets": [
+							{"key": "active", "doc_count": 2, "total": {"value": 300}},
+							{"key": "closed", "doc_count": 1, "total": {"value": 50.5}}
+						]
+					}
+				}
+			]
+		},
+		"avg_amount": {"value": null}
+	}`
+
+	var aggs elastic.Aggregations
+	if err := json.Unmarshal([]byte(body), &aggs); err != nil {
+		t.Fatalf("unmarshal aggregations: %v", err)
+	}
+
+	specs := []AggSpec{
+		{
+			Name: "by_month", Type: AggDateHistogram, Field: "start_date", Interval: "month",
+			Aggs: []AggSpec{
+				{
+					Name: "by_status", Type: AggTerms, Field: "status",
+					Aggs: []AggSpec{{Name: "total", Type: AggSum, Field: "amount"}},
+				},
+			},
+		},
+		{Name: "avg_amount", Type: AggAvg, Field: "amount"},
+	}
+
+	result, err := DecodeAggregations(aggs, specs)
+	if err != nil {
+		t.Fatalf("DecodeAggregations() error = %v", err)
+	}
+
+	months := result["by_month"].Buckets
+	if len(months) != 1 || months[0].KeyAsString != "2022-01" || months[0].Key != int64(1640995200000) || months[0].DocCount != 3 {
+		t.Fatalf("by_month buckets = %+v", months)
+	}
+
+	status := months[0].Aggs["by_status"].Buckets
+	if len(status) != 2 || status[0].KeyAsString != "active" || status[1].DocCount != 1 {
+		t.Fatalf("by_status buckets = %+v", status)
+	}
+	if v := status[1].Aggs["total"].Value; v == nil || *v != 50.5 {
+		t.Errorf("closed total = %v, want 50.5", v)
+	}
+
+	if v := result["avg_amount"].Value; v != nil {
+		t.Errorf("avg_amount = %v, want nil", *v)
+	}
+}
+
+func TestBuildAggregationRejectsMetricSubAggs(t *testing.T) {
+	_, err := BuildAggregation(AggSpec{
+		Name: "total", Type: AggSum, Field: "amount",
+		Aggs: []AggSpec{{Name: "avg", Type: AggAvg, Field: "amount"}},
+	})
+	if err == nil {
+		t.Errorf("BuildAggregation() expected error for sub aggregation under sum")
+	}
+}
@@ -0,0 +1,144 @@
+package es
+
+import (
+	"fmt"
+)
+
+// 日文检索用的分析器预设，需要在 ES 中安装 analysis-kuromoji 插件
+//
+//	mapping, _ := es.BuildMapping(Lease{})
+//	es.CreateESIndexByJson("lease", es.WithJapaneseAnalysis(mapping), true)
+//
+// 结构体字段使用 es:"ja_text" 时生成 text + keyword + ngram 的多字段 mapping。
+
+const (
+	// AnalyzerKuromoji 基于 kuromoji 形态素分析的全文检索分析器
+	AnalyzerKuromoji = "ja_kuromoji"
+	// AnalyzerNgram 2-3 gram 的部分一致检索分析器
+	AnalyzerNgram = "ja_ngram"
+	// NormalizerJapanese keyword 字段用的规范化：全角半角统一、平假名转片假名、小写
+	NormalizerJapanese = "ja_normalizer"
+
+	// CharFilterKanaFold 平假名转片假名
+	CharFilterKanaFold = "ja_kana_fold"
+
+	// TypeJapaneseText 结构体 tag 中使用的日文多字段类型
+	TypeJapaneseText = "ja_text"
+)
+
+// JapaneseAnalysis 生成 settings.analysis 的内容
+func JapaneseAnalysis() map[string]interface{} {
+	return map[string]interface{}{
+		"char_filter": map[string]interface{}{
+			CharFilterKanaFold: map[string]interface{}{
+				"type":     "mapping",
+				"mappings": kanaFoldMappings(),
+			},
+		},
+		"tokenizer": map[string]interface{}{
+			"ja_kuromoji_tokenizer": map[string]interface{}{
+				"type": "kuromoji_tokenizer",
+				"mode": "search",
+			},
+			"ja_ngram_tokenizer": map[string]interface{}{
+				"type":        "ngram",
+				"min_gram":    2,
+				"max_gram":    3,
+				"token_chars": []string{"letter", "digit"},
+			},
+		},
+		"analyzer": map[string]interface{}{
+			AnalyzerKuromoji: map[string]interface{}{
+				"type":      "custom",
+				"tokenizer": "ja_kuromoji_tokenizer",
+				"filter": []string{
+					"kuromoji_baseform",
+					"kuromoji_part_of_speech",
+					"cjk_width",
+					"ja_stop",
+					"kuromoji_stemmer",
+					"lowercase",
+				},
+			},
+			AnalyzerNgram: map[string]interface{}{
+				"type":        "custom",
+				"char_filter": []string{CharFilterKanaFold},
+				"tokenizer":   "ja_ngram_tokenizer",
+				"filter":      []string{"cjk_width", "lowercase"},
+			},
+		},
+		"normalizer": map[string]interface{}{
+			NormalizerJapanese: map[string]interface{}{
+				"type":        "custom",
+				"char_filter": []string{CharFilterKanaFold},
+				"filter":      []string{"cjk_width", "lowercase"},
+			},
+		},
+	}
+}
+
+// WithJapaneseAnalysis 把日文分析器合并到创建索引的 body 中，已有的 analysis 设置会被保留
+func WithJapaneseAnalysis(body map[string]interface{}) map[string]interface{} {
+	if body == nil {
+		body = make(map[string]interface{})
+	}
+
+	settings, ok := body["settings"].(map[string]interface{})
+	if !ok {
+		settings = make(map[string]interface{})
+		body["settings"] = settings
+	}
+
+	analysis, ok := settings["analysis"].(map[string]interface{})
+	if !ok {
+		analysis = make(map[string]interface{})
+		settings["analysis"] = analysis
+	}
+
+	for kind, defs := range JapaneseAnalysis() {
+		current, ok := analysis[kind].(map[string]interface{})
+		if !ok {
+			current = make(map[string]interface{})
+			analysis[kind] = current
+		}
+		for name, def := range defs.(map[string]interface{}) {
+			if _, exists := current[name]; !exists {
+				current[name] = def
+			}
+		}
+	}
+
+	return body
+}
+
+// JapaneseTextField 生成日文字段的多字段 mapping
+// 字段本身使用 kuromoji 全文检索，.keyword 用于排序、聚合和完全一致，.ngram 用于部分一致
+func JapaneseTextField() map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "text",
+		"analyzer": AnalyzerKuromoji,
+		"fields": map[string]interface{}{
+			"keyword": map[string]interface{}{
+				"type":         "keyword",
+				"normalizer":   NormalizerJapanese,
+				"ignore_above": 256,
+			},
+			"ngram": map[string]interface{}{
+				"type":     "text",
+				"analyzer": AnalyzerNgram,
+			},
+		},
+	}
+}
+
+// kanaFoldMappings 生成平假名到片假名的对应表
+func kanaFoldMappings() []string {
+	var mappings []string
+	// ぁ(U+3041) ～ ゖ(U+3096) 与片假名相差 0x60
+	for r := rune(0x3041); r <= 0x3096; r++ {
+		mappings = append(mappings, fmt.Sprintf("%c=>%c", r, r+0x60))
+	}
+	// ゝゞ 迭代符号
+	mappings = append(mappings, "ゝ=>ヽ", "ゞ=>ヾ")
+	return mappings
+}
@@ -0,0 +1,306 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+type (
+	// BulkAction 批量操作的类型
+	BulkAction string
+
+	// BulkItem 批量操作中的单个文档
+	BulkItem struct {
+		Action  BulkAction  // 操作类型
+		Index   string      // 索引名
+		ID      string      // 文档ID
+		Routing string      // 路由，可为空
+		Doc     interface{} // 文档内容，delete 时忽略
+	}
+
+	// BulkFailure 批量操作中失败的单个文档
+	BulkFailure struct {
+		Action string                // 操作类型
+		Index  string                // 索引名
+		ID     string                // 文档ID
+		Status int                   // 返回的状态码，整批失败时为 0
+		Detail *elastic.ErrorDetails // 单个文档的错误详情
+		Err    error                 // 整批请求失败时的错误
+	}
+
+	// BulkConfig 批量写入配置
+	BulkConfig struct {
+		Name          string        // 处理器名称，用于日志
+		Workers       int           // 并发 worker 数，0 时使用默认值
+		BulkActions   int           // 每批的文档数，达到后自动提交，0 时使用默认值，-1 表示不限制
+		BulkSize      int           // 每批的字节数，达到后自动提交，0 时使用默认值，-1 表示不限制
+		FlushInterval time.Duration // 定时提交的间隔，0 表示不定时提交
+		// OnFailure 单个文档写入失败时的回调，可能被多个 worker 并发调用
+		OnFailure func(f BulkFailure)
+	}
+
+	// BulkIndexer 基于 BulkProcessor 的批量写入器
+	BulkIndexer struct {
+		failed    int64 // 放在首位以保证 atomic 操作的 64 位对齐
+		processor *elastic.BulkProcessor
+	}
+)
+
+const (
+	// BulkIndex 写入文档，已存在时覆盖
+	BulkIndex BulkAction = "index"
+	// BulkUpdate 部分更新已存在的文档
+	BulkUpdate BulkAction = "update"
+	// BulkUpsert 部分更新文档，不存在时以文档内容创建
+	BulkUpsert BulkAction = "upsert"
+	// BulkDelete 删除文档
+	BulkDelete BulkAction = "delete"
+)
+
+var (
+	// ErrUnknownBulkAction 不支持的批量操作类型
+	ErrUnknownBulkAction = errors.New("unknown bulk action")
+	// ErrBulkFailed Flush 提交的操作中有失败的文档
+	ErrBulkFailed = errors.New("bulk has failed items")
+)
+
+// DefaultBulkConfig 默认的批量写入配置
+func DefaultBulkConfig() BulkConfig {
+	return BulkConfig{
+		Name:          "es-bulk",
+		Workers:       2,
+		BulkActions:   1000,
+		BulkSize:      5 << 20,
+		FlushInterval: 5 * time.Second,
+	}
+}
+
+// NewBulkIndexer 创建并启动一个批量写入器，使用完后需要调用 Close
+func (c *Client) NewBulkIndexer(ctx context.Context, cfg BulkConfig) (*BulkIndexer, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	return newBulkIndexer(ctx, client, cfg)
+}
+
+func newBulkIndexer(ctx context.Context, client *elastic.Client, cfg BulkConfig) (*BulkIndexer, error) {
+	b := &BulkIndexer{}
+
+	// olivere 把 0 当作阈值，每个文档都会单独提交，这里使用默认值
+	def := DefaultBulkConfig()
+	if cfg.Workers <= 0 {
+		cfg.Workers = def.Workers
+	}
+	if cfg.BulkActions == 0 {
+		cfg.BulkActions = def.BulkActions
+	}
+	if cfg.BulkSize == 0 {
+		cfg.BulkSize = def.BulkSize
+	}
+
+	svc := client.BulkProcessor().
+		Name(cfg.Name).
+		Workers(cfg.Workers).
+		BulkActions(cfg.BulkActions).
+		BulkSize(cfg.BulkSize).
+		Stats(true).
+		After(b.after(cfg.OnFailure))
+	if cfg.FlushInterval > 0 {
+		svc = svc.FlushInterval(cfg.FlushInterval)
+	}
+
+	p, err := svc.Do(ctx)
+	if err != nil {
+		log.Errorf("ES start bulk processor error: %v", err)
+		return nil, err
+	}
+
+	b.processor = p
+	return b, nil
+}
+
+// after 提交后的回调，把失败的文档逐个通知给调用方
+func (b *BulkIndexer) after(onFailure func(f BulkFailure)) elastic.BulkAfterFunc {
+	return func(executionID int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+		var failures []BulkFailure
+
+		if err != nil && (response == nil || len(response.Items) == 0) {
+			// 整批请求失败
+			for _, r := range requests {
+				f := parseBulkRequest(r)
+				f.Err = err
+				failures = append(failures, f)
+			}
+		} else if response != nil {
+			for _, item := range response.Items {
+				for action, result := range item {
+					if result.Status >= 200 && result.Status <= 299 {
+						continue
+					}
+					// 删除不存在的文档不作为失败
+					if action == string(BulkDelete) && result.Status == http.StatusNotFound {
+						continue
+					}
+					failures = append(failures, BulkFailure{
+						Action: action,
+						Index:  result.Index,
+						ID:     result.Id,
+						Status: result.Status,
+						Detail: result.Error,
+					})
+				}
+			}
+		}
+
+		if len(failures) == 0 {
+			return
+		}
+
+		atomic.AddInt64(&b.failed, int64(len(failures)))
+		log.Errorf("ES bulk execution %d has %d failed items", executionID, len(failures))
+
+		if onFailure == nil {
+			return
+		}
+		for _, f := range failures {
+			onFailure(f)
+		}
+	}
+}
+
+// Retryable 是否为暂时性的失败，重试可能成功
+// 整批请求失败、429、5xx 以及索引暂时禁止写入（例如 MigrateIndex 复制期间）为暂时性的失败，
+// 其余 4xx（mapping 冲突、文档不存在等）重试也不会成功
+func (f BulkFailure) Retryable() bool {
+	if f.Status == 0 || f.Status == http.StatusTooManyRequests || f.Status >= http.StatusInternalServerError {
+		return true
+	}
+	return f.Detail != nil && f.Detail.Type == "cluster_block_exception"
+}
+
+// parseBulkRequest 从请求的 action 行中取出操作类型、索引和ID
+func parseBulkRequest(r elastic.BulkableRequest) BulkFailure {
+	var f BulkFailure
+
+	lines, err := r.Source()
+	if err != nil || len(lines) == 0 {
+		return f
+	}
+
+	var meta map[string]struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &meta); err != nil {
+		return f
+	}
+
+	for action, m := range meta {
+		f.Action = action
+		f.Index = m.Index
+		f.ID = m.ID
+	}
+
+	return f
+}
+
+// Add 添加一个批量操作
+func (b *BulkIndexer) Add(item BulkItem) error {
+	var r elastic.BulkableRequest
+
+	switch item.Action {
+	case BulkIndex:
+		req := elastic.NewBulkIndexRequest().Index(item.Index).Id(item.ID).Doc(item.Doc)
+		if item.Routing != "" {
+			req = req.Routing(item.Routing)
+		}
+		r = req
+	case BulkUpdate, BulkUpsert:
+		req := elastic.NewBulkUpdateRequest().Index(item.Index).Id(item.ID).Doc(item.Doc)
+		if item.Action == BulkUpsert {
+			req = req.DocAsUpsert(true)
+		}
+		if item.Routing != "" {
+			req = req.Routing(item.Routing)
+		}
+		r = req
+	case BulkDelete:
+		req := elastic.NewBulkDeleteRequest().Index(item.Index).Id(item.ID)
+		if item.Routing != "" {
+			req = req.Routing(item.Routing)
+		}
+		r = req
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownBulkAction, item.Action)
+	}
+
+	b.processor.Add(r)
+	return nil
+}
+
+// Index 写入文档，已存在时覆盖
+func (b *BulkIndexer) Index(indexName, id string, doc interface{}) {
+	b.Add(BulkItem{Action: BulkIndex, Index: indexName, ID: id, Doc: doc})
+}
+
+// Update 部分更新已存在的文档
+func (b *BulkIndexer) Update(indexName, id string, doc interface{}) {
+	b.Add(BulkItem{Action: BulkUpdate, Index: indexName, ID: id, Doc: doc})
+}
+
+// Upsert 部分更新文档，不存在时以文档内容创建
+func (b *BulkIndexer) Upsert(indexName, id string, doc interface{}) {
+	b.Add(BulkItem{Action: BulkUpsert, Index: indexName, ID: id, Doc: doc})
+}
+
+// Delete 删除文档
+func (b *BulkIndexer) Delete(indexName, id string) {
+	b.Add(BulkItem{Action: BulkDelete, Index: indexName, ID: id})
+}
+
+// Flush 立即提交所有待处理的操作，期间有失败的文档时返回 ErrBulkFailed
+// 失败的详情通过 OnFailure 通知，同时进行的定时提交中的失败也会计入
+func (b *BulkIndexer) Flush() error {
+	failed := b.Failed()
+	if err := b.processor.Flush(); err != nil {
+		return err
+	}
+	if n := b.Failed() - failed; n > 0 {
+		return fmt.Errorf("%w: %d items", ErrBulkFailed, n)
+	}
+	return nil
+}
+
+// Stats 获取统计信息
+func (b *BulkIndexer) Stats() elastic.BulkProcessorStats {
+	return b.processor.Stats()
+}
+
+// Failed 获取失败的文档数
+func (b *BulkIndexer) Failed() int64 {
+	return atomic.LoadInt64(&b.failed)
+}
+
+// Close 提交剩余操作并停止写入器
+func (b *BulkIndexer) Close() error {
+	s := time.Now()
+
+	if err := b.processor.Close(); err != nil {
+		log.Errorf("ES close bulk processor error: %v", err)
+		return err
+	}
+
+	stats := b.processor.Stats()
+	log.Infof("ES bulk processor closed took: %v indexed(%d) updated(%d) deleted(%d) failed(%d)",
+		time.Since(s), stats.Indexed, stats.Updated, stats.Deleted, b.Failed())
+	return nil
+}
@@ -0,0 +1,91 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+)
+
+func TestBulkIndexer(t *testing.T) {
+	c, srv, done := newTestClient()
+	defer done()
+
+	var (
+		mu     sync.Mutex
+		failed []BulkFailure
+	)
+	b, err := c.NewBulkIndexer(context.Background(), BulkConfig{
+		Name: "test",
+		OnFailure: func(f BulkFailure) {
+			mu.Lock()
+			failed = append(failed, f)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	b.Index("lease", "1", map[string]interface{}{"name": "a"})
+	b.Index("lease", "2", map[string]interface{}{"name": "b"})
+	b.Upsert("lease", "3", map[string]interface{}{"name": "c"})
+	b.Delete("lease", "2")
+	if err := b.Add(BulkItem{Action: "merge"}); !errors.Is(err, ErrUnknownBulkAction) {
+		t.Errorf("Add() error = %v, want %v", err, ErrUnknownBulkAction)
+	}
+
+	// 零值的配置使用默认的阈值，Flush 之前不会提交
+	if srv.Source("lease", "1") != nil {
+		t.Error("zero BulkConfig should not commit each document")
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if srv.Source("lease", "1") == nil || srv.Source("lease", "2") != nil || srv.Source("lease", "3") == nil {
+		t.Errorf("docs after Flush() = %v %v %v", srv.Source("lease", "1"), srv.Source("lease", "2"), srv.Source("lease", "3"))
+	}
+
+	// 更新不存在的文档失败，删除不存在的文档不算失败
+	b.Update("lease", "9", map[string]interface{}{"name": "x"})
+	b.Delete("lease", "8")
+	b.Index("lease", "4", map[string]interface{}{"name": "d"})
+	if err := b.Flush(); !errors.Is(err, ErrBulkFailed) {
+		t.Fatalf("Flush() error = %v, want %v", err, ErrBulkFailed)
+	}
+	if b.Failed() != 1 || len(failed) != 1 || failed[0].ID != "9" || failed[0].Status != 404 {
+		t.Errorf("Failed() = %d, failures = %+v", b.Failed(), failed)
+	}
+	if srv.Source("lease", "4") == nil {
+		t.Error("doc 4 should be indexed")
+	}
+
+	// 之前的失败不影响之后的 Flush
+	b.Index("lease", "5", map[string]interface{}{"name": "e"})
+	if err := b.Flush(); err != nil {
+		t.Errorf("Flush() error = %v", err)
+	}
+}
+
+func TestBulkFailureRetryable(t *testing.T) {
+	tests := []struct {
+		f    BulkFailure
+		want bool
+	}{
+		{BulkFailure{Err: errors.New("connection refused")}, true},
+		{BulkFailure{Status: 429}, true},
+		{BulkFailure{Status: 503}, true},
+		{BulkFailure{Status: 403, Detail: &elastic.ErrorDetails{Type: "cluster_block_exception"}}, true},
+		{BulkFailure{Status: 400, Detail: &elastic.ErrorDetails{Type: "mapper_parsing_exception"}}, false},
+		{BulkFailure{Status: 404, Detail: &elastic.ErrorDetails{Type: "document_missing_exception"}}, false},
+		{BulkFailure{Status: 409}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.f.Retryable(); got != tt.want {
+			t.Errorf("Retryable(%d %v) = %v, want %v", tt.f.Status, tt.f.Detail, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+	"rxcsoft.cn/utils/config"
+)
+
+type (
+	// Option 客户端配置项
+	Option func(*Options)
+
+	// Options 客户端配置
+	Options struct {
+		// HealthcheckInterval 健康检查间隔，0 表示关闭健康检查
+		HealthcheckInterval time.Duration
+		// HealthcheckTimeout 单次健康检查的超时时间
+		HealthcheckTimeout time.Duration
+		// Sniff 是否开启节点嗅探
+		Sniff bool
+		// SnifferInterval 节点嗅探间隔
+		SnifferInterval time.Duration
+		// MaxRetries 请求失败时的最大重试次数
+		MaxRetries int
+		// RetryInitialBackoff 第一次重试前的等待时间
+		RetryInitialBackoff time.Duration
+		// RetryMaxBackoff 重试等待时间的上限
+		RetryMaxBackoff time.Duration
+	}
+
+	// Client 一个 Elasticsearch 集群的客户端，底层的 elastic.Client 在首次使用时创建，并发安全
+	// 需要连接多个集群时分别用 New 创建，包级函数使用 StartElastic 配置的默认客户端
+	Client struct {
+		cfg  config.DB
+		opts Options
+
+		mu     sync.RWMutex
+		client *elastic.Client
+	}
+
+	// retrier 带最大次数限制的指数退避重试
+	retrier struct {
+		maxRetries int
+		initial    time.Duration
+		max        time.Duration
+	}
+)
+
+var (
+	// ErrNotStarted 未调用 StartElastic 时返回的错误
+	ErrNotStarted = errors.New("elasticsearch is not started, call StartElastic first")
+
+	mu            sync.RWMutex
+	defaultClient *Client
+)
+
+// defaultOptions 默认配置
+func defaultOptions() Options {
+	return Options{
+		HealthcheckInterval: 60 * time.Second,
+		HealthcheckTimeout:  5 * time.Second,
+		Sniff:               false,
+		SnifferInterval:     15 * time.Minute,
+		MaxRetries:          3,
+		RetryInitialBackoff: 100 * time.Millisecond,
+		RetryMaxBackoff:     5 * time.Second,
+	}
+}
+
+// WithHealthcheckInterval 设置健康检查间隔，传入 0 关闭健康检查
+func WithHealthcheckInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.HealthcheckInterval = interval
+	}
+}
+
+// WithHealthcheckTimeout 设置健康检查的超时时间
+func WithHealthcheckTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.HealthcheckTimeout = timeout
+	}
+}
+
+// WithSniff 设置是否开启节点嗅探以及嗅探间隔
+func WithSniff(enabled bool, interval time.Duration) Option {
+	return func(o *Options) {
+		o.Sniff = enabled
+		if interval > 0 {
+			o.SnifferInterval = interval
+		}
+	}
+}
+
+// WithRetry 设置最大重试次数和退避时间
+func WithRetry(maxRetries int, initial, max time.Duration) Option {
+	return func(o *Options) {
+		o.MaxRetries = maxRetries
+		o.RetryInitialBackoff = initial
+		o.RetryMaxBackoff = max
+	}
+}
+
+// New 创建一个集群的客户端，c.Host 可用逗号分隔多个节点
+func New(c config.DB, opts ...Option) *Client {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Client{
+		cfg:  c,
+		opts: o,
+	}
+}
+
+// Elastic 获取底层的 elastic.Client，首次调用时创建
+func (c *Client) Elastic() (*elastic.Client, error) {
+	c.mu.RLock()
+	client := c.client
+	c.mu.RUnlock()
+	if client != nil {
+		return client, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	client, err := c.newElastic()
+	if err != nil {
+		log.Errorf("ES create client error: %v", err)
+		return nil, err
+	}
+
+	c.client = client
+	return c.client, nil
+}
+
+// NewElastic 创建一个独立的 elastic.Client，使用完后需要调用 Stop
+func (c *Client) NewElastic() (*elastic.Client, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.newElastic()
+}
+
+// Stop 关闭底层的 elastic.Client，下次使用时会重新创建
+func (c *Client) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client != nil {
+		c.client.Stop()
+		c.client = nil
+	}
+}
+
+// newElastic 根据配置创建 elastic.Client，调用方需持有锁
+func (c *Client) newElastic() (*elastic.Client, error) {
+	o := c.opts
+	settings := []elastic.ClientOptionFunc{
+		elastic.SetURL(c.urls()...),
+		elastic.SetSniff(o.Sniff),
+		elastic.SetRetrier(&retrier{
+			maxRetries: o.MaxRetries,
+			initial:    o.RetryInitialBackoff,
+			max:        o.RetryMaxBackoff,
+		}),
+	}
+
+	if len(c.cfg.Username) > 0 {
+		settings = append(settings, elastic.SetBasicAuth(c.cfg.Username, c.cfg.Password))
+	}
+
+	if o.Sniff {
+		settings = append(settings, elastic.SetSnifferInterval(o.SnifferInterval))
+	}
+
+	if o.HealthcheckInterval > 0 {
+		settings = append(settings,
+			elastic.SetHealthcheck(true),
+			elastic.SetHealthcheckInterval(o.HealthcheckInterval),
+			elastic.SetHealthcheckTimeout(o.HealthcheckTimeout),
+		)
+	} else {
+		settings = append(settings, elastic.SetHealthcheck(false))
+	}
+
+	return elastic.NewClient(settings...)
+}
+
+func (c *Client) urls() []string {
+	var urls []string
+	for _, url := range strings.Split(c.cfg.Host, ",") {
+		urls = append(urls, strings.TrimSpace(url))
+	}
+
+	return urls
+}
+
+// StartElastic 初始化默认客户端
+// 连接信息与已有的相同时配置项追加到已有的配置；
+// 不同时替换连接信息和配置项，关闭已有的连接，之后的请求发送到新的集群
+func StartElastic(c config.DB, opts ...Option) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if defaultClient == nil {
+		defaultClient = New(c, opts...)
+		return
+	}
+
+	dc := defaultClient
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if reflect.DeepEqual(dc.cfg, c) {
+		for _, opt := range opts {
+			opt(&dc.opts)
+		}
+		return
+	}
+
+	log.Warnf("ES default client reconfigured: %s -> %s", dc.cfg.Host, c.Host)
+	dc.cfg = c
+	dc.opts = defaultOptions()
+	for _, opt := range opts {
+		opt(&dc.opts)
+	}
+	if dc.client != nil {
+		dc.client.Stop()
+		dc.client = nil
+	}
+}
+
+// StopElastic 关闭默认客户端的连接，下次使用时会重新创建
+func StopElastic() {
+	if c, err := Default(); err == nil {
+		c.Stop()
+	}
+}
+
+// Default 获取 StartElastic 配置的默认客户端
+func Default() (*Client, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if defaultClient == nil {
+		return nil, ErrNotStarted
+	}
+	return defaultClient, nil
+}
+
+// GetClient 获取默认客户端共享的 elastic.Client，首次调用时创建，并发安全
+func GetClient() (*elastic.Client, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Elastic()
+}
+
+// NewClient 使用默认客户端的配置创建一个独立的 elastic.Client，使用完后需要调用 Stop
+func NewClient() (*elastic.Client, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.NewElastic()
+}
+
+// NewESClient 创建一个客户端，出错时记录日志并返回 nil
+//
+// Deprecated: 请使用 GetClient 或 NewClient，以便处理错误
+func NewESClient() *elastic.Client {
+	client, err := NewClient()
+	if err != nil {
+		log.Errorf("ES create client error: %v", err)
+		return nil
+	}
+
+	return client
+}
+
+// Retry 实现 elastic.Retrier，超过最大次数后停止，等待时间按指数增加并不超过上限
+// 429 和 5xx 以外的响应不重试，ctx 结束时返回 ctx 的错误
+func (r *retrier) Retry(ctx context.Context, retry int, req *http.Request, resp *http.Response, err error) (time.Duration, bool, error) {
+	if ctx != nil && ctx.Err() != nil {
+		return 0, false, ctx.Err()
+	}
+	if retry > r.maxRetries {
+		return 0, false, nil
+	}
+	if resp != nil && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return 0, false, nil
+	}
+
+	wait := float64(r.initial) * math.Pow(2, float64(retry-1)) * (1 + rand.Float64()/2)
+	if wait > float64(r.max) {
+		wait = float64(r.max)
+	}
+
+	return time.Duration(wait), true, nil
+}
@@ -0,0 +1,172 @@
+package es
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"rxcsoft.cn/utils/config"
+	"rxcsoft.cn/utils/es/estest"
+)
+
+func TestClientMultipleClusters(t *testing.T) {
+	primary := estest.NewServer()
+	defer primary.Close()
+	analytics := estest.NewServer()
+	defer analytics.Close()
+
+	pc := New(config.DB{Host: primary.URL})
+	defer pc.Stop()
+	ac := New(config.DB{Host: analytics.URL}, WithHealthcheckInterval(0))
+	defer ac.Stop()
+
+	if err := pc.ESInsert("lease", "1", map[string]interface{}{"name": "primary"}); err != nil {
+		t.Fatalf("primary insert has error: %v", err)
+	}
+	if err := ac.ESInsert("lease", "1", map[string]interface{}{"name": "analytics"}); err != nil {
+		t.Fatalf("analytics insert has error: %v", err)
+	}
+
+	if got := primary.Source("lease", "1"); got["name"] != "primary" {
+		t.Errorf("primary doc = %v", got)
+	}
+	if got := analytics.Source("lease", "1"); got["name"] != "analytics" {
+		t.Errorf("analytics doc = %v", got)
+	}
+
+	if err := ac.DeleteESIndex("lease"); err != nil {
+		t.Fatalf("analytics delete index has error: %v", err)
+	}
+	if !pc.ExistsESIndex("lease") || ac.ExistsESIndex("lease") {
+		t.Errorf("delete index on analytics should not affect primary")
+	}
+}
+
+func TestClientLazy(t *testing.T) {
+	c, _, done := newTestClient()
+	defer done()
+
+	if c.client != nil {
+		t.Fatal("New() should not connect")
+	}
+	first, err := c.Elastic()
+	if err != nil {
+		t.Fatalf("Elastic() error = %v", err)
+	}
+	if again, _ := c.Elastic(); again != first {
+		t.Error("Elastic() should return the shared client")
+	}
+
+	c.Stop()
+	if again, _ := c.Elastic(); again == nil || again == first {
+		t.Error("Elastic() after Stop should create a new client")
+	}
+
+	standalone, err := c.NewElastic()
+	if err != nil {
+		t.Fatalf("NewElastic() error = %v", err)
+	}
+	defer standalone.Stop()
+	if shared, _ := c.Elastic(); standalone == shared {
+		t.Error("NewElastic() should not return the shared client")
+	}
+}
+
+func TestRetrier(t *testing.T) {
+	r := &retrier{maxRetries: 3, initial: 100 * time.Millisecond, max: 250 * time.Millisecond}
+	ctx := context.Background()
+
+	// 第 n 次重试等待 initial*2^(n-1) 到其 1.5 倍，不超过上限
+	for retry, min := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 250 * time.Millisecond} {
+		wait, ok, err := r.Retry(ctx, retry, nil, nil, nil)
+		if !ok || err != nil || wait < min || wait > r.max || wait > min*3/2 {
+			t.Errorf("Retry(%d) = %v, %v, %v", retry, wait, ok, err)
+		}
+	}
+	if _, ok, _ := r.Retry(ctx, 4, nil, nil, nil); ok {
+		t.Error("Retry() should stop after maxRetries")
+	}
+
+	for status, want := range map[int]bool{http.StatusTooManyRequests: true, http.StatusServiceUnavailable: true, http.StatusBadRequest: false, http.StatusNotFound: false} {
+		if _, ok, _ := r.Retry(ctx, 1, nil, &http.Response{StatusCode: status}, nil); ok != want {
+			t.Errorf("Retry(status %d) ok = %v, want %v", status, ok, want)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, ok, err := r.Retry(canceled, 1, nil, nil, nil); ok || err != context.Canceled {
+		t.Errorf("Retry(canceled) = %v, %v", ok, err)
+	}
+}
+
+func TestClientRetryGivesUp(t *testing.T) {
+	down := estest.NewServer()
+	down.Close()
+
+	c := New(config.DB{Host: down.URL}, WithHealthcheckInterval(0), WithRetry(2, time.Millisecond, 2*time.Millisecond))
+	defer c.Stop()
+
+	s := time.Now()
+	if err := c.ESInsert("lease", "1", map[string]interface{}{"name": "a"}); err == nil {
+		t.Fatal("ESInsert() to a stopped server should fail")
+	}
+	if d := time.Since(s); d > 5*time.Second {
+		t.Errorf("ESInsert() took %v, retries should be bounded", d)
+	}
+}
+
+func TestStartElastic(t *testing.T) {
+	other := estest.NewServer()
+	defer other.Close()
+	// 恢复其他测试使用的默认客户端
+	defer StartElastic(cf)
+
+	StartElastic(cf)
+	c, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 相同的连接信息只追加配置项
+	StartElastic(cf, WithRetry(1, time.Millisecond, time.Millisecond))
+	if again, _ := Default(); again != c || c.opts.MaxRetries != 1 {
+		t.Errorf("StartElastic() same config: client = %p want %p, MaxRetries = %d", again, c, c.opts.MaxRetries)
+	}
+	shared, err := c.Elastic()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 不同的连接信息替换配置，之后的请求发送到新的集群
+	StartElastic(config.DB{Host: other.URL}, WithHealthcheckInterval(0))
+	if c.opts.MaxRetries != defaultOptions().MaxRetries {
+		t.Errorf("StartElastic() new config should reset options, MaxRetries = %d", c.opts.MaxRetries)
+	}
+	if again, _ := c.Elastic(); again == shared {
+		t.Error("StartElastic() new config should recreate the client")
+	}
+	if err := ESInsert("lease", "1", map[string]interface{}{"name": "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if other.Source("lease", "1") == nil {
+		t.Error("document should be written to the new cluster")
+	}
+}
+
+func TestNewESClientNotStarted(t *testing.T) {
+	mu.Lock()
+	saved := defaultClient
+	defaultClient = nil
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		defaultClient = saved
+		mu.Unlock()
+	}()
+
+	if client := NewESClient(); client != nil {
+		t.Errorf("NewESClient() = %v, want nil", client)
+	}
+}
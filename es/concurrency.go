@@ -0,0 +1,239 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// 乐观并发控制
+//
+//	var lease Lease
+//	v, err := es.ESUpdateWithRetry("lease", id, &lease, 3, func(found bool) error {
+//	    lease.Status = "closed"
+//	    return nil
+//	})
+//
+// 也可以先用 ESGetVersioned 取得版本，再把版本传给 ESIndexVersioned / ESUpdateVersioned / ESDeleteVersioned，
+// 期间文档被其他人修改时返回 *ConflictError。
+
+type (
+	// DocVersion 文档的版本信息，也用作写入时的版本条件
+	DocVersion struct {
+		SeqNo       int64 // 序列号
+		PrimaryTerm int64 // 主分片任期
+		Version     int64 // 版本号
+		External    bool  // 为 true 时以 Version 作为外部版本号（version_type=external）校验，代替 SeqNo/PrimaryTerm
+	}
+
+	// ConflictError 版本冲突，文档已被其他请求修改
+	ConflictError struct {
+		Index  string
+		ID     string
+		Reason string
+	}
+)
+
+var (
+	// ErrExternalVersionUpdate update API 不支持外部版本号
+	ErrExternalVersionUpdate = errors.New("external version is not supported by update, use ESIndexVersioned")
+
+	// conflictBackoff 冲突重试的初始等待时间
+	conflictBackoff = 20 * time.Millisecond
+)
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("es version conflict on %s/%s: %s", e.Index, e.ID, e.Reason)
+}
+
+// IsConflict 判断是否为版本冲突
+func IsConflict(err error) bool {
+	var ce *ConflictError
+	return errors.As(err, &ce)
+}
+
+// asConflict 把 409 错误转换为 *ConflictError，其他错误原样返回
+func asConflict(indexName, id string, err error) error {
+	if err == nil || !elastic.IsConflict(err) {
+		return err
+	}
+
+	ce := &ConflictError{Index: indexName, ID: id, Reason: err.Error()}
+	var e *elastic.Error
+	if errors.As(err, &e) && e.Details != nil {
+		ce.Reason = e.Details.Reason
+	}
+	return ce
+}
+
+// ESGetVersioned 获取文档及其版本，文档不存在时返回 nil
+func (c *Client) ESGetVersioned(indexName string, id string, result interface{}) (*DocVersion, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	doc, err := client.Get().
+		Index(indexName).
+		Id(id).
+		Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, nil
+		}
+		log.Errorf("ES got document error: %v", err)
+		return nil, err
+	}
+
+	if !doc.Found {
+		return nil, nil
+	}
+
+	if err := json.Unmarshal(doc.Source, result); err != nil {
+		log.Errorf("ES decode document error: %v", err)
+		return nil, err
+	}
+
+	v := &DocVersion{}
+	if doc.SeqNo != nil {
+		v.SeqNo = *doc.SeqNo
+	}
+	if doc.PrimaryTerm != nil {
+		v.PrimaryTerm = *doc.PrimaryTerm
+	}
+	if doc.Version != nil {
+		v.Version = *doc.Version
+	}
+	return v, nil
+}
+
+// ESIndexVersioned 在版本一致时写入整个文档，cond 为 nil 时仅在文档不存在时创建
+func (c *Client) ESIndexVersioned(indexName string, id string, body interface{}, cond *DocVersion) (*DocVersion, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	svc := client.Index().
+		Index(indexName).
+		Id(id).
+		BodyJson(body)
+	switch {
+	case cond == nil:
+		svc = svc.OpType("create")
+	case cond.External:
+		svc = svc.Version(cond.Version).VersionType("external")
+	default:
+		svc = svc.IfSeqNo(cond.SeqNo).IfPrimaryTerm(cond.PrimaryTerm)
+	}
+
+	result, err := svc.Do(ctx)
+	if err != nil {
+		err = asConflict(indexName, id, err)
+		log.Errorf("ES index versioned document error: %v", err)
+		return nil, err
+	}
+
+	log.Infof("ES index versioned document %s in version %d from index %s\n", result.Id, result.Version, result.Index)
+	return &DocVersion{SeqNo: result.SeqNo, PrimaryTerm: result.PrimaryTerm, Version: result.Version}, nil
+}
+
+// ESUpdateVersioned 在版本一致时部分更新文档，只支持 SeqNo/PrimaryTerm 条件
+func (c *Client) ESUpdateVersioned(indexName string, id string, doc map[string]interface{}, cond DocVersion) (*DocVersion, error) {
+	if cond.External {
+		return nil, ErrExternalVersionUpdate
+	}
+
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	result, err := client.Update().
+		Index(indexName).
+		Id(id).
+		Doc(doc).
+		IfSeqNo(cond.SeqNo).
+		IfPrimaryTerm(cond.PrimaryTerm).
+		Do(ctx)
+	if err != nil {
+		err = asConflict(indexName, id, err)
+		log.Errorf("ES update versioned document error: %v", err)
+		return nil, err
+	}
+
+	log.Infof("ES update versioned document %s in version %d from index %s\n", result.Id, result.Version, result.Index)
+	return &DocVersion{SeqNo: result.SeqNo, PrimaryTerm: result.PrimaryTerm, Version: result.Version}, nil
+}
+
+// ESDeleteVersioned 在版本一致时删除文档
+func (c *Client) ESDeleteVersioned(indexName string, id string, cond DocVersion) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	svc := client.Delete().
+		Index(indexName).
+		Id(id)
+	if cond.External {
+		svc = svc.Version(cond.Version).VersionType("external")
+	} else {
+		svc = svc.IfSeqNo(cond.SeqNo).IfPrimaryTerm(cond.PrimaryTerm)
+	}
+
+	result, err := svc.Do(ctx)
+	if err != nil {
+		err = asConflict(indexName, id, err)
+		log.Errorf("ES delete versioned document error: %v", err)
+		return err
+	}
+
+	log.Infof("ES delete versioned document %s from index %s\n", result.Id, result.Index)
+	return nil
+}
+
+// ESUpdateWithRetry 读取-合并-写入，版本冲突时重新读取并重试，最多重试 maxRetries 次
+// doc 为结构体或 map 的指针，每次读取前会被清空，merge 在 doc 上修改，found 表示文档是否已存在
+// merge 返回错误时中止并原样返回该错误
+func (c *Client) ESUpdateWithRetry(indexName string, id string, doc interface{}, maxRetries int, merge func(found bool) error) (*DocVersion, error) {
+	rv := reflect.ValueOf(doc)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, fmt.Errorf("es: doc must be a non-nil pointer, got %T", doc)
+	}
+
+	wait := conflictBackoff
+	for attempt := 0; ; attempt++ {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+
+		cur, err := c.ESGetVersioned(indexName, id, doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := merge(cur != nil); err != nil {
+			return nil, err
+		}
+
+		v, err := c.ESIndexVersioned(indexName, id, doc, cur)
+		if err == nil {
+			return v, nil
+		}
+		if !IsConflict(err) || attempt >= maxRetries {
+			return nil, err
+		}
+
+		log.Warnf("ES update %s/%s conflict, retry %d/%d", indexName, id, attempt+1, maxRetries)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
@@ -0,0 +1,122 @@
+package es
+
+import (
+	"testing"
+
+	"rxcsoft.cn/utils/config"
+	"rxcsoft.cn/utils/es/estest"
+)
+
+type concurrencyLease struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// newTestClient 创建连接到新替身服务的客户端，使用完后调用返回的函数关闭
+func newTestClient() (*Client, *estest.Server, func()) {
+	s := estest.NewServer()
+	c := New(config.DB{Host: s.URL}, WithHealthcheckInterval(0))
+	return c, s, func() {
+		c.Stop()
+		s.Close()
+	}
+}
+
+func TestVersionedConflict(t *testing.T) {
+	c, _, done := newTestClient()
+	defer done()
+
+	v1, err := c.ESIndexVersioned("lease", "1", concurrencyLease{Name: "a"}, nil)
+	if err != nil {
+		t.Fatalf("ESIndexVersioned() create error = %v", err)
+	}
+	if _, err := c.ESIndexVersioned("lease", "1", concurrencyLease{Name: "b"}, nil); !IsConflict(err) {
+		t.Errorf("ESIndexVersioned() create existing: want conflict, got %v", err)
+	}
+
+	if _, err := c.ESUpdateVersioned("lease", "1", map[string]interface{}{"name": "c"}, *v1); err != nil {
+		t.Fatalf("ESUpdateVersioned() error = %v", err)
+	}
+
+	// v1 已过期
+	_, err = c.ESUpdateVersioned("lease", "1", map[string]interface{}{"name": "d"}, *v1)
+	if !IsConflict(err) {
+		t.Fatalf("ESUpdateVersioned() stale: want conflict, got %v", err)
+	}
+	if ce := err.(*ConflictError); ce.Index != "lease" || ce.ID != "1" || ce.Reason == "" {
+		t.Errorf("ConflictError = %+v", ce)
+	}
+	if err := c.ESDeleteVersioned("lease", "1", *v1); !IsConflict(err) {
+		t.Errorf("ESDeleteVersioned() stale: want conflict, got %v", err)
+	}
+
+	var got concurrencyLease
+	v, err := c.ESGetVersioned("lease", "1", &got)
+	if err != nil || v == nil || got.Name != "c" {
+		t.Fatalf("ESGetVersioned() = %v, %+v, %v", v, got, err)
+	}
+	if err := c.ESDeleteVersioned("lease", "1", *v); err != nil {
+		t.Errorf("ESDeleteVersioned() error = %v", err)
+	}
+}
+
+func TestVersionedExternal(t *testing.T) {
+	c, _, done := newTestClient()
+	defer done()
+
+	if _, err := c.ESIndexVersioned("lease", "1", concurrencyLease{Name: "a"}, &DocVersion{Version: 5, External: true}); err != nil {
+		t.Fatalf("ESIndexVersioned() error = %v", err)
+	}
+	_, err := c.ESIndexVersioned("lease", "1", concurrencyLease{Name: "b"}, &DocVersion{Version: 5, External: true})
+	if !IsConflict(err) {
+		t.Errorf("ESIndexVersioned() same external version: want conflict, got %v", err)
+	}
+	if _, err := c.ESUpdateVersioned("lease", "1", nil, DocVersion{Version: 6, External: true}); err != ErrExternalVersionUpdate {
+		t.Errorf("ESUpdateVersioned() external: got %v", err)
+	}
+}
+
+func TestESUpdateWithRetry(t *testing.T) {
+	c, s, done := newTestClient()
+	defer done()
+
+	if err := c.ESInsert("lease", "1", concurrencyLease{Name: "a", Count: 1}); err != nil {
+		t.Fatalf("ESInsert() error = %v", err)
+	}
+
+	var doc concurrencyLease
+	attempts := 0
+	_, err := c.ESUpdateWithRetry("lease", "1", &doc, 3, func(found bool) error {
+		attempts++
+		if attempts == 1 {
+			// 读取后被其他请求修改
+			if err := c.ESInsert("lease", "1", concurrencyLease{Name: "b", Count: 10}); err != nil {
+				return err
+			}
+		}
+		doc.Count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ESUpdateWithRetry() error = %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("ESUpdateWithRetry() attempts = %d, want 2", attempts)
+	}
+	if got := s.Source("lease", "1"); got["name"] != "b" || got["count"] != 11.0 {
+		t.Errorf("ESUpdateWithRetry() doc = %v", got)
+	}
+
+	// 不存在时以空文档调用 merge 并创建
+	var created concurrencyLease
+	_, err = c.ESUpdateWithRetry("lease", "2", &created, 0, func(found bool) error {
+		if found {
+			t.Errorf("ESUpdateWithRetry() found = true for missing doc")
+		}
+		created.Name = "new"
+		return nil
+	})
+	if err != nil || s.Source("lease", "2")["name"] != "new" {
+		t.Errorf("ESUpdateWithRetry() create: %v, %v", s.Source("lease", "2"), err)
+	}
+}
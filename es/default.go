@@ -0,0 +1,620 @@
+package es
+
+import (
+	"context"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// 以下包级函数使用 StartElastic 配置的默认客户端，需要连接其他集群时使用 New 创建 Client 并调用同名方法
+
+// ExistsESIndex 见 Client.ExistsESIndex
+func ExistsESIndex(indexName string) bool {
+	c, err := Default()
+	if err != nil {
+		return false
+	}
+
+	return c.ExistsESIndex(indexName)
+}
+
+// CreateESIndex 见 Client.CreateESIndex
+func CreateESIndex(indexName, mapping string, alias bool) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.CreateESIndex(indexName, mapping, alias)
+}
+
+// CreateESIndexByJson 见 Client.CreateESIndexByJson
+func CreateESIndexByJson(indexName string, mapping interface{}, alias bool) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.CreateESIndexByJson(indexName, mapping, alias)
+}
+
+// GetESIndexName 见 Client.GetESIndexName
+func GetESIndexName(alias string) ([]string, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetESIndexName(alias)
+}
+
+// GetESIndexAlias 见 Client.GetESIndexAlias
+func GetESIndexAlias(indexName string) ([]string, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetESIndexAlias(indexName)
+}
+
+// UpdateESIndex 见 Client.UpdateESIndex
+func UpdateESIndex(oldIndexName, newIndexName, alias string, mapping interface{}) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.UpdateESIndex(oldIndexName, newIndexName, alias, mapping)
+}
+
+// RecreateIndex 见 Client.RecreateIndex
+func RecreateIndex(indexName string, script *elastic.Script, query elastic.Query) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.RecreateIndex(indexName, script, query)
+}
+
+// DeleteESIndex 见 Client.DeleteESIndex
+func DeleteESIndex(indexName string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteESIndex(indexName)
+}
+
+// ESFlush 见 Client.ESFlush
+func ESFlush(indexName string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ESFlush(indexName)
+}
+
+// ESInsert 见 Client.ESInsert
+func ESInsert(indexName string, id string, body interface{}) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ESInsert(indexName, id, body)
+}
+
+// ESInsertByString 见 Client.ESInsertByString
+func ESInsertByString(indexName string, id string, body string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ESInsertByString(indexName, id, body)
+}
+
+// ESGet 见 Client.ESGet
+func ESGet(indexName string, id string) (map[string]interface{}, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ESGet(indexName, id)
+}
+
+// ESDelete 见 Client.ESDelete
+func ESDelete(indexName string, id string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ESDelete(indexName, id)
+}
+
+// ESDeleteAll 见 Client.ESDeleteAll
+func ESDeleteAll(indexName string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ESDeleteAll(indexName)
+}
+
+// ESUpdate 见 Client.ESUpdate
+func ESUpdate(indexName string, id string, doc map[string]interface{}) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ESUpdate(indexName, id, doc)
+}
+
+// ESUpsert 见 Client.ESUpsert
+func ESUpsert(indexName string, id string, script *elastic.Script, doc map[string]interface{}) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ESUpsert(indexName, id, script, doc)
+}
+
+// ESSearch 见 Client.ESSearch
+func ESSearch(indexName string, termQuery elastic.Query, sort string, start, size int) (*elastic.SearchResult, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ESSearch(indexName, termQuery, sort, start, size)
+}
+
+// NewBulkIndexer 见 Client.NewBulkIndexer
+func NewBulkIndexer(ctx context.Context, cfg BulkConfig) (*BulkIndexer, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.NewBulkIndexer(ctx, cfg)
+}
+
+// ESGetInto 见 Client.ESGetInto
+func ESGetInto(indexName string, id string, result interface{}) (bool, error) {
+	c, err := Default()
+	if err != nil {
+		return false, err
+	}
+
+	return c.ESGetInto(indexName, id, result)
+}
+
+// ESSearchInto 见 Client.ESSearchInto
+func ESSearchInto(indexName string, req SearchRequest, result interface{}) (*SearchMeta, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ESSearchInto(indexName, req, result)
+}
+
+// ESGetVersioned 见 Client.ESGetVersioned
+func ESGetVersioned(indexName string, id string, result interface{}) (*DocVersion, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ESGetVersioned(indexName, id, result)
+}
+
+// ESIndexVersioned 见 Client.ESIndexVersioned
+func ESIndexVersioned(indexName string, id string, body interface{}, cond *DocVersion) (*DocVersion, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ESIndexVersioned(indexName, id, body, cond)
+}
+
+// ESUpdateVersioned 见 Client.ESUpdateVersioned
+func ESUpdateVersioned(indexName string, id string, doc map[string]interface{}, cond DocVersion) (*DocVersion, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ESUpdateVersioned(indexName, id, doc, cond)
+}
+
+// ESDeleteVersioned 见 Client.ESDeleteVersioned
+func ESDeleteVersioned(indexName string, id string, cond DocVersion) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ESDeleteVersioned(indexName, id, cond)
+}
+
+// ESUpdateWithRetry 见 Client.ESUpdateWithRetry
+func ESUpdateWithRetry(indexName string, id string, doc interface{}, maxRetries int, merge func(found bool) error) (*DocVersion, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ESUpdateWithRetry(indexName, id, doc, maxRetries, merge)
+}
+
+// OpenPointInTime 见 Client.OpenPointInTime
+func OpenPointInTime(indexName, keepAlive string) (string, error) {
+	c, err := Default()
+	if err != nil {
+		return "", err
+	}
+
+	return c.OpenPointInTime(indexName, keepAlive)
+}
+
+// ClosePointInTime 见 Client.ClosePointInTime
+func ClosePointInTime(id string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ClosePointInTime(id)
+}
+
+// ESSearchAfter 见 Client.ESSearchAfter
+func ESSearchAfter(indexName string, req SearchRequest, after []interface{}, result interface{}) (*SearchMeta, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ESSearchAfter(indexName, req, after, result)
+}
+
+// ESSearchEach 见 Client.ESSearchEach
+func ESSearchEach(indexName string, req SearchRequest, keepAlive string, result interface{}, fn func(meta *SearchMeta) error) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.ESSearchEach(indexName, req, keepAlive, result, fn)
+}
+
+// NewScrollIterator 见 Client.NewScrollIterator
+func NewScrollIterator(indexName string, query elastic.Query, sorts []SortField, size int, keepAlive string) (*ScrollIterator, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.NewScrollIterator(indexName, query, sorts, size, keepAlive)
+}
+
+// ESAggregate 见 Client.ESAggregate
+func ESAggregate(indexName string, query elastic.Query, specs []AggSpec) (map[string]*AggResult, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ESAggregate(indexName, query, specs)
+}
+
+// GetTask 见 Client.GetTask
+func GetTask(taskID string) (*TaskStatus, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetTask(taskID)
+}
+
+// WaitForTask 见 Client.WaitForTask
+func WaitForTask(ctx context.Context, taskID string, interval time.Duration, onProgress func(*TaskStatus)) (*TaskStatus, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.WaitForTask(ctx, taskID, interval, onProgress)
+}
+
+// MigrateIndex 见 Client.MigrateIndex
+func MigrateIndex(oldIndexName, newIndexName, alias string, mapping interface{}, opts MigrateOptions) (*Migration, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.MigrateIndex(oldIndexName, newIndexName, alias, mapping, opts)
+}
+
+// RollbackMigration 见 Client.RollbackMigration
+func RollbackMigration(m *Migration, force bool) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.RollbackMigration(m, force)
+}
+
+// PurgeExpiredIndices 见 Client.PurgeExpiredIndices
+func PurgeExpiredIndices() ([]string, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.PurgeExpiredIndices()
+}
+
+// PutIndexTemplate 见 Client.PutIndexTemplate
+func PutIndexTemplate(name string, tpl IndexTemplate) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.PutIndexTemplate(name, tpl)
+}
+
+// GetIndexTemplate 见 Client.GetIndexTemplate
+func GetIndexTemplate(name string) (*IndexTemplate, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetIndexTemplate(name)
+}
+
+// ExistsIndexTemplate 见 Client.ExistsIndexTemplate
+func ExistsIndexTemplate(name string) (bool, error) {
+	c, err := Default()
+	if err != nil {
+		return false, err
+	}
+
+	return c.ExistsIndexTemplate(name)
+}
+
+// DeleteIndexTemplate 见 Client.DeleteIndexTemplate
+func DeleteIndexTemplate(name string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteIndexTemplate(name)
+}
+
+// PutComponentTemplate 见 Client.PutComponentTemplate
+func PutComponentTemplate(name string, tpl ComponentTemplate) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.PutComponentTemplate(name, tpl)
+}
+
+// GetComponentTemplate 见 Client.GetComponentTemplate
+func GetComponentTemplate(name string) (*ComponentTemplate, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetComponentTemplate(name)
+}
+
+// DeleteComponentTemplate 见 Client.DeleteComponentTemplate
+func DeleteComponentTemplate(name string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteComponentTemplate(name)
+}
+
+// PutLifecyclePolicy 见 Client.PutLifecyclePolicy
+func PutLifecyclePolicy(name string, policy map[string]interface{}) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.PutLifecyclePolicy(name, policy)
+}
+
+// GetLifecyclePolicy 见 Client.GetLifecyclePolicy
+func GetLifecyclePolicy(name string) (map[string]interface{}, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetLifecyclePolicy(name)
+}
+
+// DeleteLifecyclePolicy 见 Client.DeleteLifecyclePolicy
+func DeleteLifecyclePolicy(name string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteLifecyclePolicy(name)
+}
+
+// BootstrapRolloverIndex 见 Client.BootstrapRolloverIndex
+func BootstrapRolloverIndex(alias string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.BootstrapRolloverIndex(alias)
+}
+
+// RolloverIndex 见 Client.RolloverIndex
+func RolloverIndex(alias string, conditions map[string]interface{}) (bool, string, error) {
+	c, err := Default()
+	if err != nil {
+		return false, "", err
+	}
+
+	return c.RolloverIndex(alias, conditions)
+}
+
+// RegisterFSRepository 见 Client.RegisterFSRepository
+func RegisterFSRepository(name, location string, settings map[string]interface{}) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.RegisterFSRepository(name, location, settings)
+}
+
+// DeleteRepository 见 Client.DeleteRepository
+func DeleteRepository(name string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteRepository(name)
+}
+
+// CreateSnapshot 见 Client.CreateSnapshot
+func CreateSnapshot(repository, snapshot string, indices []string, wait bool) (*elastic.Snapshot, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.CreateSnapshot(repository, snapshot, indices, wait)
+}
+
+// GetSnapshot 见 Client.GetSnapshot
+func GetSnapshot(repository, snapshot string) (*elastic.Snapshot, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetSnapshot(repository, snapshot)
+}
+
+// ListSnapshots 见 Client.ListSnapshots
+func ListSnapshots(repository string) ([]*elastic.Snapshot, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ListSnapshots(repository)
+}
+
+// DeleteSnapshot 见 Client.DeleteSnapshot
+func DeleteSnapshot(repository, snapshot string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteSnapshot(repository, snapshot)
+}
+
+// RestoreSnapshot 见 Client.RestoreSnapshot
+func RestoreSnapshot(repository, snapshot string, opts RestoreOptions) (*elastic.RestoreInfo, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.RestoreSnapshot(repository, snapshot, opts)
+}
+
+// BackupIndices 见 Client.BackupIndices
+func BackupIndices(repository, prefix string, indices ...string) (string, error) {
+	c, err := Default()
+	if err != nil {
+		return "", err
+	}
+
+	return c.BackupIndices(repository, prefix, indices...)
+}
+
+// RecreateIndexAsync 见 Client.RecreateIndexAsync
+func RecreateIndexAsync(indexName string, script *elastic.Script, query elastic.Query, opts ByQueryOptions) (string, error) {
+	c, err := Default()
+	if err != nil {
+		return "", err
+	}
+
+	return c.RecreateIndexAsync(indexName, script, query, opts)
+}
+
+// DeleteByQueryAsync 见 Client.DeleteByQueryAsync
+func DeleteByQueryAsync(indexName string, query elastic.Query, opts ByQueryOptions) (string, error) {
+	c, err := Default()
+	if err != nil {
+		return "", err
+	}
+
+	return c.DeleteByQueryAsync(indexName, query, opts)
+}
+
+// ESDeleteAllAsync 见 Client.ESDeleteAllAsync
+func ESDeleteAllAsync(indexName string, opts ByQueryOptions) (string, error) {
+	c, err := Default()
+	if err != nil {
+		return "", err
+	}
+
+	return c.ESDeleteAllAsync(indexName, opts)
+}
+
+// CancelTask 见 Client.CancelTask
+func CancelTask(taskID string) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.CancelTask(taskID)
+}
+
+// RethrottleTask 见 Client.RethrottleTask
+func RethrottleTask(taskID string, requestsPerSecond int) error {
+	c, err := Default()
+	if err != nil {
+		return err
+	}
+
+	return c.RethrottleTask(taskID, requestsPerSecond)
+}
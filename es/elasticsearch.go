@@ -3,51 +3,16 @@ package es
 import (
 	"context"
 	"encoding/json"
-	"strings"
 
 	"github.com/google/uuid"
 	elastic "github.com/olivere/elastic/v7"
-	"rxcsoft.cn/utils/config"
 	"rxcsoft.cn/utils/logger"
 )
 
 var (
 	log = logger.New()
-	env *config.DB
 )
 
-// StartElastic 初始化Elastic
-func StartElastic(c config.DB) {
-	if env == nil {
-		env = &c
-	}
-}
-
-func buildClientOption() (u []string) {
-	host := strings.Split(env.Host, ",")
-
-	var urls []string
-
-	for _, url := range host {
-		urls = append(urls, url)
-	}
-
-	return urls
-}
-
-// NewESClient 创建一个客户端
-func NewESClient() *elastic.Client {
-	client, err := elastic.NewClient(
-		elastic.SetURL(buildClientOption()...),
-		elastic.SetBasicAuth(env.Username, env.Password),
-		elastic.SetSniff(false))
-	if err != nil {
-		panic(err)
-	}
-
-	return client
-}
-
 // CreateESIndexByJson 创建索引
 // @param indexName string
 // @param mapping string
@@ -67,9 +32,11 @@ func NewESClient() *elastic.Client {
 //         }
 //     }
 // }`
-func ExistsESIndex(indexName string) bool {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) ExistsESIndex(indexName string) bool {
+	client, err := c.Elastic()
+	if err != nil {
+		return false
+	}
 
 	ctx := context.Background()
 
@@ -103,9 +70,11 @@ func ExistsESIndex(indexName string) bool {
 //         }
 //     }
 // }`
-func CreateESIndex(indexName, mapping string, alias bool) error {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) CreateESIndex(indexName, mapping string, alias bool) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 
@@ -174,9 +143,11 @@ func CreateESIndex(indexName, mapping string, alias bool) error {
 //         }
 //     }
 // }`
-func CreateESIndexByJson(indexName string, mapping interface{}, alias bool) error {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) CreateESIndexByJson(indexName string, mapping interface{}, alias bool) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 
@@ -226,9 +197,11 @@ func CreateESIndexByJson(indexName string, mapping interface{}, alias bool) erro
 }
 
 // GetESIndexName 通过别名获取 index 名
-func GetESIndexName(alias string) ([]string, error) {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) GetESIndexName(alias string) ([]string, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return []string{}, err
+	}
 
 	ctx := context.Background()
 	index, err := client.Aliases().Alias(alias).Do(ctx)
@@ -240,9 +213,11 @@ func GetESIndexName(alias string) ([]string, error) {
 }
 
 // GetESIndexAlias 通过index名获取别名
-func GetESIndexAlias(indexName string) ([]string, error) {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) GetESIndexAlias(indexName string) ([]string, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return []string{}, err
+	}
 
 	ctx := context.Background()
 	alias, err := client.Aliases().Index(indexName).Do(ctx)
@@ -264,67 +239,19 @@ func GetESIndexAlias(indexName string) ([]string, error) {
 }
 
 // UpdateESIndex 重建索引
-func UpdateESIndex(oldIndexName, newIndexName, alias string, mapping interface{}) error {
-	client := NewESClient()
-	defer client.Stop()
-
-	ctx := context.Background()
-
-	// 1. 创建别名
-	_, e1 := client.Alias().
-		Action(elastic.NewAliasAddAction(alias).Index(oldIndexName)).
-		Do(ctx)
-	if e1 != nil {
-		return e1
-	}
-
-	// 2. 创建新索引
-	exists, e2 := client.IndexExists(newIndexName).Do(ctx)
-	if e2 != nil {
-		return e2
-	}
-
-	if !exists {
-		result, err := client.CreateIndex(newIndexName).BodyJson(mapping).Do(ctx)
-		if err != nil {
-			log.Errorf("ES create index error: %v", err)
-			return err
-		}
-
-		log.Infof("ES create index ok: %v", result.Index)
-	}
-
-	// 3. 复制数据
-	_, e4 := client.Reindex().
-		Source(elastic.NewReindexSource().Index(oldIndexName)).Size(5000).
-		Destination(elastic.NewReindexDestination().Index(newIndexName).Routing("=cat")).
-		Do(ctx)
-	if e4 != nil {
-		return e4
-	}
-
-	// 4. 修改别名
-	_, e3 := client.Alias().
-		Action(elastic.NewAliasAddAction(alias).Index(newIndexName), elastic.NewAliasRemoveAction(alias).Index(oldIndexName)).
-		Do(ctx)
-	if e3 != nil {
-		return e3
-	}
-
-	// 删除旧索引
-	_, e5 := client.DeleteIndex(oldIndexName).
-		Do(ctx)
-	if e5 != nil {
-		return e5
-	}
-
-	return nil
+// 使用默认配置执行 MigrateIndex，旧索引保留一天，需要定制时请直接使用 MigrateIndex
+func (c *Client) UpdateESIndex(oldIndexName, newIndexName, alias string, mapping interface{}) error {
+	_, err := c.MigrateIndex(oldIndexName, newIndexName, alias, mapping, DefaultMigrateOptions())
+	return err
 }
 
 // RecreateIndex 更新索引
-func RecreateIndex(indexName string, script *elastic.Script, query elastic.Query) error {
-	client := NewESClient()
-	defer client.Stop()
+// 同步执行直到处理完所有文档，大索引请使用 RecreateIndexAsync
+func (c *Client) RecreateIndex(indexName string, script *elastic.Script, query elastic.Query) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 
@@ -344,9 +271,11 @@ func RecreateIndex(indexName string, script *elastic.Script, query elastic.Query
 }
 
 // DeleteESIndex 删除索引
-func DeleteESIndex(indexName string) error {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) DeleteESIndex(indexName string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 	result, err := client.DeleteIndex(indexName).Do(ctx)
@@ -360,9 +289,11 @@ func DeleteESIndex(indexName string) error {
 }
 
 // ESFlush 刷新索引，保证写入成功
-func ESFlush(indexName string) error {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) ESFlush(indexName string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 	result, err := client.Flush(indexName).Do(ctx)
@@ -376,9 +307,11 @@ func ESFlush(indexName string) error {
 }
 
 // ESInsert 插入数据（json serialization）
-func ESInsert(indexName string, id string, body interface{}) error {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) ESInsert(indexName string, id string, body interface{}) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 	result, err := client.Index().
@@ -396,9 +329,11 @@ func ESInsert(indexName string, id string, body interface{}) error {
 }
 
 // ESInsert 插入数据(json string)
-func ESInsertByString(indexName string, id string, body string) error {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) ESInsertByString(indexName string, id string, body string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 	result, err := client.Index().
@@ -416,9 +351,11 @@ func ESInsertByString(indexName string, id string, body string) error {
 }
 
 // ESGet 获取单个文档
-func ESGet(indexName string, id string) (map[string]interface{}, error) {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) ESGet(indexName string, id string) (map[string]interface{}, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
 
 	ctx := context.Background()
 	result, err := client.Get().
@@ -438,6 +375,7 @@ func ESGet(indexName string, id string) (map[string]interface{}, error) {
 		e := json.Unmarshal(result.Source, &s)
 		if e != nil {
 			log.Errorf("ES got document error: %v", e)
+			return nil, e
 		}
 
 		return s, nil
@@ -446,9 +384,11 @@ func ESGet(indexName string, id string) (map[string]interface{}, error) {
 }
 
 // ESDelete 删除单个文档
-func ESDelete(indexName string, id string) (e error) {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) ESDelete(indexName string, id string) (e error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 	result, err := client.Delete().
@@ -465,9 +405,12 @@ func ESDelete(indexName string, id string) (e error) {
 }
 
 // ESDelete 删除index 下所有文档
-func ESDeleteAll(indexName string) (e error) {
-	client := NewESClient()
-	defer client.Stop()
+// 同步执行直到删除结束，大索引请使用 ESDeleteAllAsync
+func (c *Client) ESDeleteAll(indexName string) (e error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 	result, err := client.DeleteByQuery().
@@ -484,9 +427,11 @@ func ESDeleteAll(indexName string) (e error) {
 }
 
 // ESUpdate 更新单个文档
-func ESUpdate(indexName string, id string, doc map[string]interface{}) (e error) {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) ESUpdate(indexName string, id string, doc map[string]interface{}) (e error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 	result, err := client.Update().
@@ -505,9 +450,11 @@ func ESUpdate(indexName string, id string, doc map[string]interface{}) (e error)
 }
 
 // ESUpsert 更新值，没有的情况下使用默认传入的值
-func ESUpsert(indexName string, id string, script *elastic.Script, doc map[string]interface{}) (e error) {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) ESUpsert(indexName string, id string, script *elastic.Script, doc map[string]interface{}) (e error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 	result, err := client.Update().
@@ -564,9 +511,11 @@ func ESUpsert(indexName string, id string, script *elastic.Script, doc map[strin
 //     // No hits
 //     fmt.Print("Found no tweets\n")
 // }
-func ESSearch(indexName string, termQuery elastic.Query, sort string, start, size int) (result *elastic.SearchResult, err error) {
-	client := NewESClient()
-	defer client.Stop()
+func (c *Client) ESSearch(indexName string, termQuery elastic.Query, sort string, start, size int) (result *elastic.SearchResult, err error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
 
 	ctx := context.Background()
 
@@ -579,7 +528,7 @@ func ESSearch(indexName string, termQuery elastic.Query, sort string, start, siz
 		Do(ctx) // execute
 	if e != nil {
 		log.Errorf("ES search error: %v", e)
-		return nil, err
+		return nil, e
 	}
 
 	// searchResult is of type SearchResult and returns hits, suggestions,
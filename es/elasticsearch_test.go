@@ -3,20 +3,53 @@ package es
 import (
 	"context"
 	"encoding/json"
+	"os"
+	"reflect"
 	"testing"
 
 	"github.com/olivere/elastic/v7"
 	"rxcsoft.cn/utils/config"
+	"rxcsoft.cn/utils/es/estest"
 )
 
-var cf config.DB = config.DB{
-	Host: "http://192.168.1.26:9200/",
-	//Username:       "",
-	//Password:       "",
+var (
+	srv *estest.Server
+	cf  config.DB
+)
+
+func TestMain(m *testing.M) {
+	srv = estest.NewServer()
+	cf = config.DB{
+		Host: srv.URL,
+		//Username:       "",
+		//Password:       "",
+	}
+
+	code := m.Run()
+
+	StopElastic()
+	srv.Close()
+	os.Exit(code)
 }
 
-func TestCreateESIndex(t *testing.T) {
+// seed 重置替身服务并写入测试数据
+func seed(t *testing.T, indexName string, docs map[string]map[string]interface{}) {
+	t.Helper()
 	StartElastic(cf)
+	srv.Reset()
+
+	if err := CreateESIndex(indexName, "", false); err != nil {
+		t.Fatalf("es create index has error: %v", err)
+	}
+	for id, doc := range docs {
+		if err := ESInsert(indexName, id, doc); err != nil {
+			t.Fatalf("es create doc has error: %v", err)
+		}
+	}
+}
+
+func TestCreateESIndex(t *testing.T) {
+	seed(t, "test6", nil)
 	const mapping = `
 	{
 	    "mappings": {
@@ -37,10 +70,22 @@ func TestCreateESIndex(t *testing.T) {
 	if err != nil {
 		t.Errorf("es create index has error: %v", err)
 	}
+	if !ExistsESIndex("test7") {
+		t.Errorf("es create index: test7 not exists")
+	}
+
+	err = CreateESIndex("test8", mapping, true)
+	if err != nil {
+		t.Errorf("es create index with alias has error: %v", err)
+	}
+	names, err := GetESIndexName("test8")
+	if err != nil || len(names) != 1 {
+		t.Errorf("es create index with alias: got %v, %v", names, err)
+	}
 }
 
 func TestNewESClient(t *testing.T) {
-	StartElastic(cf)
+	seed(t, "test6", nil)
 
 	client := NewESClient()
 	defer client.Stop()
@@ -50,13 +95,16 @@ func TestNewESClient(t *testing.T) {
 	if er != nil {
 		t.Errorf("es has error: %v", er)
 	}
-	if ex {
-		t.Logf("es ok: %v", ex)
+	if !ex {
+		t.Errorf("es index test6 not exists")
 	}
 }
 
 func TestUpdateESIndex(t *testing.T) {
-	StartElastic(cf)
+	seed(t, "test1", map[string]map[string]interface{}{
+		"1": {"id": "0001", "title": "a"},
+		"2": {"id": "0002", "title": "b"},
+	})
 	const mapping = `
 	{
 	    "mappings": {
@@ -70,14 +118,28 @@ func TestUpdateESIndex(t *testing.T) {
 	        }
 	    }
 	}`
-	err := UpdateESIndex("test1", "test2", "aaaa", mapping)
+	var body map[string]interface{}
+	json.Unmarshal([]byte(mapping), &body)
+
+	err := UpdateESIndex("test1", "test2", "aaaa", body)
 	if err != nil {
-		t.Errorf("es update index has error: %v", err)
+		t.Fatalf("es update index has error: %v", err)
+	}
+
+	names, err := GetESIndexName("aaaa")
+	if err != nil {
+		t.Fatalf("es get index name has error: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"test2"}) {
+		t.Errorf("es alias aaaa = %v, want [test2]", names)
+	}
+	if srv.Source("test2", "2") == nil {
+		t.Errorf("es update index: doc 2 not copied")
 	}
 }
 
 func TestESInsert(t *testing.T) {
-	StartElastic(cf)
+	seed(t, "test6", nil)
 
 	err := ESInsert("test6", "2", map[string]interface{}{
 		"id":     "0002",
@@ -89,89 +151,121 @@ func TestESInsert(t *testing.T) {
 	if err != nil {
 		t.Errorf("es create doc has error: %v", err)
 	}
+	if got := srv.Source("test6", "2"); got == nil || got["title"] != "北京人民大会d" {
+		t.Errorf("es create doc: got %v", got)
+	}
 }
 
 func TestESGet(t *testing.T) {
-	StartElastic(cf)
-
-	re, err := ESGet("item_5e82b1bbe389ee7c5f29cbd3_97e4d0cf-9b65-4cc0-861f-4776310de84e", "5e82b4a9e389ee7fdaca3ff9")
+	seed(t, "test6", map[string]map[string]interface{}{
+		"2": {"id": "0002", "title": "北京人民大会d"},
+	})
 
+	re, err := ESGet("test6", "2")
 	if err != nil {
-		if elastic.IsNotFound(err) {
-			t.Logf("es create doc has error: not found")
-
-			return
-		}
-		t.Errorf("es create doc has error: %v", err)
+		t.Errorf("es get doc has error: %v", err)
 		return
 	}
 	if len(re) == 0 {
-		t.Errorf("es create doc has error: not found")
+		t.Errorf("es get doc has error: not found")
 		return
 	}
 
-	t.Logf("%v", re)
+	_, err = ESGet("test6", "3")
+	if !elastic.IsNotFound(err) {
+		t.Errorf("es get doc: want not found, got %v", err)
+	}
 }
 
 func TestESDelete(t *testing.T) {
-	StartElastic(cf)
+	seed(t, "test6", map[string]map[string]interface{}{
+		"2": {"id": "0002"},
+	})
 
 	err := ESDelete("test6", "2")
 
 	if err != nil {
 		t.Errorf("es delete doc has error: %v", err)
 	}
+	if srv.Source("test6", "2") != nil {
+		t.Errorf("es delete doc: doc 2 still exists")
+	}
 }
 
 func TestESDeleteAll(t *testing.T) {
-	StartElastic(cf)
+	seed(t, "test6", map[string]map[string]interface{}{
+		"1": {"id": "0001"},
+		"2": {"id": "0002"},
+	})
 
 	err := ESDeleteAll("test6")
 
 	if err != nil {
 		t.Errorf("es delete doc has error: %v", err)
 	}
+	if srv.Source("test6", "1") != nil || srv.Source("test6", "2") != nil {
+		t.Errorf("es delete all: docs still exist")
+	}
 }
 
 func TestDeleteESIndex(t *testing.T) {
-	StartElastic(cf)
+	seed(t, "test", nil)
 
 	err := DeleteESIndex("test")
 
 	if err != nil {
 		t.Errorf("es delete index has error: %v", err)
 	}
+	if ExistsESIndex("test") {
+		t.Errorf("es delete index: test still exists")
+	}
 }
 
 func TestESUpdate(t *testing.T) {
-	StartElastic(cf)
+	seed(t, "test", map[string]map[string]interface{}{
+		"5": {"id": 1, "name": "wu"},
+	})
 	err := ESUpdate("test", "5", map[string]interface{}{
 		"name": "wujianhua",
 	})
 	if err != nil {
 		t.Errorf("es  update doc has error: %v", err)
 	}
+	if got := srv.Source("test", "5"); got["name"] != "wujianhua" || got["id"] != 1.0 {
+		t.Errorf("es update doc: got %v", got)
+	}
 }
 
 func TestESUpdateWithScript(t *testing.T) {
-	StartElastic(cf)
+	seed(t, "test", nil)
 
 	script := elastic.NewScript("ctx._source.id += params.num").Param("num", 3)
-	err := ESUpsert("test", "5", script, map[string]interface{}{
-		"id":    "99999",
+	doc := map[string]interface{}{
+		"id":    99999,
 		"title": "北京人民大会堂",
-	})
-	if err != nil {
-		t.Errorf("es  update doc with script has error: %v", err)
+	}
+	// 不存在时写入 upsert 的内容，存在时执行脚本
+	for i := 0; i < 2; i++ {
+		err := ESUpsert("test", "5", script, doc)
+		if err != nil {
+			t.Errorf("es  update doc with script has error: %v", err)
+		}
+	}
+	if got := srv.Source("test", "5"); got["id"] != 100002.0 {
+		t.Errorf("es update doc with script: got %v", got)
 	}
 }
 
 func TestESSearch(t *testing.T) {
-	StartElastic(cf)
+	seed(t, "test6", map[string]map[string]interface{}{
+		"1": {"id": "0001", "title": "a", "genres": []string{"ddd"}},
+		"2": {"id": "0002", "title": "b", "genres": []string{"ddd", "ccc"}},
+		"3": {"id": "0003", "title": "c", "genres": []string{"ccc"}},
+	})
 	termQuery := elastic.NewTermQuery("genres", "ddd")
-	result, err := ESSearch("test6", termQuery, "genres", 0, 20)
+	result, err := ESSearch("test6", termQuery, "id", 0, 20)
 	if err != nil {
-		t.Errorf("es  update doc with script has error: %v", err)
+		t.Fatalf("es search has error: %v", err)
 	}
 
 	type Tweet struct {
@@ -180,23 +274,19 @@ func TestESSearch(t *testing.T) {
 		Genres []string `json:"genres"`
 	}
 
-	// Here's how you iterate through results with full control over each step.
-	if result.TotalHits() > 0 {
-
-		// Iterate through results
-		for _, hit := range result.Hits.Hits {
-			// hit.Index contains the name of the index
+	if result.TotalHits() != 2 {
+		t.Fatalf("es search: total hits = %d, want 2", result.TotalHits())
+	}
 
-			// Deserialize hit.Source into a Tweet (could also be just a map[string]interface{}).
-			var it Tweet
-			err := json.Unmarshal(hit.Source, &it)
-			if err != nil {
-				// Deserialization failed
-			}
-			t.Logf("%v", it)
+	var ids []string
+	for _, hit := range result.Hits.Hits {
+		var it Tweet
+		if err := json.Unmarshal(hit.Source, &it); err != nil {
+			t.Fatalf("es search: unmarshal has error: %v", err)
 		}
-	} else {
-		// No hits
-		t.Logf("Found no tweets\n")
+		ids = append(ids, it.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"0001", "0002"}) {
+		t.Errorf("es search: ids = %v", ids)
 	}
 }
@@ -0,0 +1,63 @@
+package estest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// openPointInTime 为索引创建时间点，保存当时的文档副本，之后的写入不影响时间点上的检索
+func (s *Server) openPointInTime(expr string) (int, interface{}) {
+	targets := s.resolve(expr)
+	if len(targets) == 0 {
+		return 0, indexNotFound(expr)
+	}
+
+	var view []*index
+	for _, idx := range targets {
+		view = append(view, cloneIndex(idx, idx.name))
+	}
+	s.pitSeq++
+	id := fmt.Sprintf("estest-pit-%d", s.pitSeq)
+	s.pits[id] = view
+	return http.StatusOK, map[string]interface{}{"id": id}
+}
+
+// closePointInTime 关闭时间点
+func (s *Server) closePointInTime(body []byte) (int, interface{}) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+	}
+
+	if _, ok := s.pits[req.ID]; !ok {
+		return http.StatusNotFound, map[string]interface{}{"succeeded": true, "num_freed": 0}
+	}
+	delete(s.pits, req.ID)
+	return http.StatusOK, map[string]interface{}{"succeeded": true, "num_freed": 1}
+}
+
+// pitTargets 取得时间点上的索引
+func (s *Server) pitTargets(id string) ([]*index, error) {
+	view, ok := s.pits[id]
+	if !ok {
+		return nil, newError(http.StatusNotFound, "search_context_missing_exception", "No search context found for id [%s]", id)
+	}
+	return view, nil
+}
+
+// PointsInTime 获取未关闭的时间点ID，用于断言时间点已释放
+func (s *Server) PointsInTime() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for id := range s.pits {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
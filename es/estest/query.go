@@ -0,0 +1,559 @@
+package estest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// hit 检索命中的文档
+	hit struct {
+		index *index
+		doc   *document
+	}
+
+	// sortKey 排序条件
+	sortKey struct {
+		field string
+		desc  bool
+	}
+)
+
+// matchAll 取得所有索引中满足条件的文档，按写入顺序排列
+func (s *Server) matchAll(targets []*index, query map[string]interface{}) ([]hit, error) {
+	var hits []hit
+	for _, idx := range targets {
+		var docs []*document
+		for _, doc := range idx.docs {
+			docs = append(docs, doc)
+		}
+		sort.Slice(docs, func(i, j int) bool { return docs[i].created < docs[j].created })
+
+		for _, doc := range docs {
+			ok, err := matches(query, doc)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				hits = append(hits, hit{index: idx, doc: doc})
+			}
+		}
+	}
+	return hits, nil
+}
+
+// matches 判断文档是否满足检索条件
+func matches(query map[string]interface{}, doc *document) (bool, error) {
+	if len(query) == 0 {
+		return true, nil
+	}
+
+	for typ, raw := range query {
+		body, _ := raw.(map[string]interface{})
+
+		var ok bool
+		var err error
+		switch typ {
+		case "match_all":
+			ok = true
+		case "match_none":
+			ok = false
+		case "bool":
+			ok, err = matchBool(body, doc)
+		case "term":
+			ok = anyField(body, doc, func(v, want interface{}) bool { return equal(v, want) }, "value")
+		case "terms":
+			ok = anyField(body, doc, func(v, want interface{}) bool {
+				for _, w := range stringOrList(want) {
+					if equal(v, w) {
+						return true
+					}
+				}
+				return false
+			}, "")
+		case "match", "match_phrase":
+			ok = anyField(body, doc, func(v, want interface{}) bool { return textMatch(v, want, typ == "match_phrase") }, "query")
+		case "prefix":
+			ok = anyField(body, doc, func(v, want interface{}) bool {
+				return strings.HasPrefix(fmt.Sprint(v), fmt.Sprint(want))
+			}, "value")
+		case "wildcard":
+			ok = anyField(body, doc, func(v, want interface{}) bool {
+				return wildcardMatch(fmt.Sprint(want), fmt.Sprint(v))
+			}, "value")
+		case "exists":
+			ok = len(fieldValues(doc, fmt.Sprint(body["field"]))) > 0
+		case "ids":
+			for _, id := range stringOrList(body["values"]) {
+				if fmt.Sprint(id) == doc.id {
+					ok = true
+				}
+			}
+		case "range":
+			ok = matchRange(body, doc)
+		default:
+			return false, newError(http.StatusBadRequest, "parsing_exception", "estest: unsupported query [%s]", typ)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// matchBool 处理 bool 查询，只有 should 时至少满足一个
+func matchBool(body map[string]interface{}, doc *document) (bool, error) {
+	required := 0
+	for _, key := range []string{"must", "filter"} {
+		for _, q := range queryList(body[key]) {
+			required++
+			ok, err := matches(q, doc)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+
+	for _, q := range queryList(body["must_not"]) {
+		ok, err := matches(q, doc)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return false, nil
+		}
+	}
+
+	should := queryList(body["should"])
+	minimum := 0
+	if required == 0 && len(should) > 0 {
+		minimum = 1
+	}
+	if v, ok := body["minimum_should_match"]; ok {
+		minimum, _ = strconv.Atoi(fmt.Sprint(v))
+	}
+
+	matched := 0
+	for _, q := range should {
+		ok, err := matches(q, doc)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+
+	return matched >= minimum, nil
+}
+
+func matchRange(body map[string]interface{}, doc *document) bool {
+	for field, raw := range body {
+		cond, _ := raw.(map[string]interface{})
+		for _, v := range fieldValues(doc, field) {
+			ok := true
+			for op, bound := range cond {
+				if bound == nil {
+					continue
+				}
+				c := compare(v, bound)
+				switch op {
+				case "gt":
+					ok = ok && c > 0
+				case "gte":
+					ok = ok && c >= 0
+				case "lt":
+					ok = ok && c < 0
+				case "lte":
+					ok = ok && c <= 0
+				case "from":
+					if inc, exists := cond["include_lower"].(bool); exists && !inc {
+						ok = ok && c > 0
+					} else {
+						ok = ok && c >= 0
+					}
+				case "to":
+					if inc, exists := cond["include_upper"].(bool); exists && !inc {
+						ok = ok && c < 0
+					} else {
+						ok = ok && c <= 0
+					}
+				}
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyField 对 {field: value} 或 {field: {key: value}} 形式的查询判断字段中是否有值满足条件
+func anyField(body map[string]interface{}, doc *document, fn func(v, want interface{}) bool, key string) bool {
+	for field, want := range body {
+		if field == "boost" {
+			continue
+		}
+		if m, ok := want.(map[string]interface{}); ok && key != "" {
+			want = m[key]
+		}
+		for _, v := range fieldValues(doc, field) {
+			if fn(v, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fieldValues 取得字段的值，数组会被展开，.keyword 等子字段取原字段的值
+func fieldValues(doc *document, field string) []interface{} {
+	if field == "_id" {
+		return []interface{}{doc.id}
+	}
+
+	values := lookup(doc.source, strings.Split(field, "."))
+	if len(values) == 0 {
+		if i := strings.LastIndex(field, "."); i > 0 {
+			return lookup(doc.source, strings.Split(field[:i], "."))
+		}
+	}
+	return values
+}
+
+func lookup(v interface{}, keys []string) []interface{} {
+	if len(keys) == 0 {
+		switch t := v.(type) {
+		case nil:
+			return nil
+		case []interface{}:
+			var result []interface{}
+			for _, item := range t {
+				result = append(result, lookup(item, nil)...)
+			}
+			return result
+		}
+		return []interface{}{v}
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		// 字段名本身含有点号时优先完全一致
+		for i := len(keys); i > 0; i-- {
+			if child, ok := t[strings.Join(keys[:i], ".")]; ok {
+				return lookup(child, keys[i:])
+			}
+		}
+	case []interface{}:
+		var result []interface{}
+		for _, item := range t {
+			result = append(result, lookup(item, keys)...)
+		}
+		return result
+	}
+	return nil
+}
+
+// textMatch 简易的全文检索：不区分大小写，查询按空白分词，任一词包含在字段值中即命中
+func textMatch(v, want interface{}, phrase bool) bool {
+	text := strings.ToLower(fmt.Sprint(v))
+	query := strings.ToLower(fmt.Sprint(want))
+	if phrase {
+		return strings.Contains(text, query)
+	}
+	for _, token := range strings.Fields(query) {
+		if strings.Contains(text, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	fa, oka := toFloat(a)
+	fb, okb := toFloat(b)
+	if oka && okb {
+		return fa == fb
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// compare 比较两个值，数值按数值比较，其余按字符串比较
+func compare(a, b interface{}) int {
+	fa, oka := toFloat(a)
+	fb, okb := toFloat(b)
+	if oka && okb {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// parseSort 解析 sort 参数，支持 "field"、{"field": "desc"}、{"field": {"order": "desc"}}
+func parseSort(raw interface{}) []sortKey {
+	var keys []sortKey
+	for _, item := range stringOrList(raw) {
+		switch t := item.(type) {
+		case string:
+			keys = append(keys, sortKey{field: t, desc: t == "_score"})
+		case map[string]interface{}:
+			for field, opt := range t {
+				order := fmt.Sprint(opt)
+				if m, ok := opt.(map[string]interface{}); ok {
+					order = fmt.Sprint(m["order"])
+				}
+				keys = append(keys, sortKey{field: field, desc: order == "desc"})
+			}
+		}
+	}
+	return keys
+}
+
+func sortValues(h hit, sorts []sortKey) []interface{} {
+	var values []interface{}
+	for _, key := range sorts {
+		switch key.field {
+		case "_score":
+			values = append(values, 1.0)
+		case "_doc", "_shard_doc":
+			values = append(values, h.doc.created)
+		default:
+			var v interface{}
+			if found := fieldValues(h.doc, key.field); len(found) > 0 {
+				v = found[0]
+			}
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// compareValues 按排序条件比较两组排序值，缺失的值总是排在最后
+func compareValues(a, b []interface{}, sorts []sortKey) int {
+	for i, key := range sorts {
+		if i >= len(a) || i >= len(b) {
+			break
+		}
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			return 1
+		case b[i] == nil:
+			return -1
+		}
+		c := compare(a[i], b[i])
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func sortHits(hits []hit, sorts []sortKey) {
+	if len(sorts) == 0 {
+		return
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return compareValues(sortValues(hits[i], sorts), sortValues(hits[j], sorts), sorts) < 0
+	})
+}
+
+var scriptStatement = regexp.MustCompile(`^ctx\._source\.([\w.]+)\s*(\+=|=)\s*(.+)$`)
+
+// runScript 执行简单的 painless 赋值语句
+func runScript(raw interface{}, source map[string]interface{}) error {
+	code := ""
+	var params map[string]interface{}
+	switch t := raw.(type) {
+	case string:
+		code = t
+	case map[string]interface{}:
+		code = fmt.Sprint(t["source"])
+		params, _ = t["params"].(map[string]interface{})
+	}
+
+	for _, stmt := range strings.Split(code, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
+		}
+
+		m := scriptStatement.FindStringSubmatch(stmt)
+		if m == nil {
+			return newError(http.StatusBadRequest, "script_exception", "estest: unsupported script [%s]", stmt)
+		}
+
+		value, err := scriptValue(m[3], params)
+		if err != nil {
+			return err
+		}
+
+		keys := strings.Split(m[1], ".")
+		parent := source
+		for _, k := range keys[:len(keys)-1] {
+			child, ok := parent[k].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				parent[k] = child
+			}
+			parent = child
+		}
+		last := keys[len(keys)-1]
+
+		if m[2] == "+=" {
+			current := parent[last]
+			fc, okc := toFloat(current)
+			fv, okv := toFloat(value)
+			if okc && okv {
+				value = fc + fv
+			} else {
+				value = fmt.Sprint(current) + fmt.Sprint(value)
+			}
+		}
+		parent[last] = value
+	}
+
+	return nil
+}
+
+func scriptValue(expr string, params map[string]interface{}) (interface{}, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "params.") {
+		return params[strings.TrimPrefix(expr, "params.")], nil
+	}
+	if len(expr) >= 2 && (expr[0] == '\'' || expr[0] == '"') && expr[len(expr)-1] == expr[0] {
+		return expr[1 : len(expr)-1], nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(expr), &v); err != nil {
+		return nil, newError(http.StatusBadRequest, "script_exception", "estest: unsupported script value [%s]", expr)
+	}
+	return v, nil
+}
+
+func queryList(v interface{}) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, item := range stringOrList(v) {
+		if m, ok := item.(map[string]interface{}); ok {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// stringOrList 把单个值或数组统一为数组
+func stringOrList(v interface{}) []interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return t
+	}
+	return []interface{}{v}
+}
+
+// stringList 把若干个字符串或字符串数组合并为一个数组
+func stringList(values ...interface{}) []string {
+	var result []string
+	for _, v := range values {
+		for _, item := range stringOrList(v) {
+			result = append(result, fmt.Sprint(item))
+		}
+	}
+	return result
+}
+
+func wildcardMatch(pattern, name string) bool {
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == name
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+func splitPath(p string) []string {
+	var parts []string
+	for _, part := range strings.Split(p, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// flatten 把嵌套的设置展开为 a.b.c 形式
+func flatten(prefix string, m map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if child, ok := v.(map[string]interface{}); ok {
+			for ck, cv := range flatten(key, child) {
+				result[ck] = cv
+			}
+			continue
+		}
+		result[key] = v
+	}
+	return result
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	b, _ := json.Marshal(m)
+	var result map[string]interface{}
+	json.Unmarshal(b, &result)
+	return result
+}
+
+// mergeMap 部分更新时合并文档，对象类型的字段递归合并
+func mergeMap(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sv, ok := v.(map[string]interface{}); ok {
+			if dv, ok := dst[k].(map[string]interface{}); ok {
+				mergeMap(dv, sv)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
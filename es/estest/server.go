@@ -0,0 +1,1425 @@
+// Package estest 提供一个进程内的 Elasticsearch 替身，用于在没有集群的环境下测试 es 包
+//
+//	srv := estest.NewServer()
+//	defer srv.Close()
+//	es.StartElastic(config.DB{Host: srv.URL})
+//
+// 支持的接口：索引的存在判断/创建/删除、别名、文档的写入/取得/更新/删除、
+// _bulk、_delete_by_query、_update_by_query、_search、_count、_flush、_refresh、
+// _settings、_mapping、_reindex、_tasks、_rethrottle、scroll、_pit（时间点和 search_after）、fs 类型仓库的 _snapshot，
+// 以及 _index_template、_component_template、_ilm/policy 的保存和取得（模板不会应用到新建的索引）。
+// 异步任务默认立即完成，HoldTasks 可以让任务保持执行中的状态。
+// 检索支持 match_all、term、terms、match、bool、range、exists、prefix、wildcard、ids，
+// 脚本只支持 ctx._source.field = params.x 和 ctx._source.field += params.x 形式的语句。
+package estest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Server 进程内的 Elasticsearch 替身
+	Server struct {
+		// URL 服务地址，传给 config.DB 的 Host
+		URL string
+
+		srv *httptest.Server
+
+		mu        sync.Mutex
+		indices   map[string]*index
+		tasks     map[string]map[string]interface{}
+		scrolls   map[string]*scrollState
+		repos     map[string]*repository
+		pending   map[string]func() map[string]interface{}
+		templates map[string]map[string]map[string]interface{}
+		policies  map[string]map[string]interface{}
+		pits      map[string][]*index
+		holdTasks bool
+		seq       int64
+		taskSeq   int64
+		scrollSeq int64
+		pitSeq    int64
+	}
+
+	// scrollState scroll 中尚未返回的文档
+	scrollState struct {
+		hits  []hit
+		size  int
+		total int
+		sorts []sortKey
+	}
+
+	// index 内存中的索引
+	index struct {
+		name     string
+		docs     map[string]*document
+		aliases  map[string]map[string]interface{}
+		settings map[string]interface{}
+		mappings map[string]interface{}
+	}
+
+	// document 内存中的文档
+	document struct {
+		id      string
+		source  map[string]interface{}
+		version int64
+		seqNo   int64
+		created int64
+	}
+
+	// esError 返回给客户端的错误
+	esError struct {
+		status int
+		typ    string
+		reason string
+	}
+)
+
+// NewServer 启动一个新的替身服务，使用完后需要调用 Close
+func NewServer() *Server {
+	s := &Server{
+		indices:   make(map[string]*index),
+		tasks:     make(map[string]map[string]interface{}),
+		scrolls:   make(map[string]*scrollState),
+		repos:     make(map[string]*repository),
+		pending:   make(map[string]func() map[string]interface{}),
+		templates: make(map[string]map[string]map[string]interface{}),
+		policies:  make(map[string]map[string]interface{}),
+		pits:      make(map[string][]*index),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close 关闭服务
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Reset 清空所有索引、任务、快照仓库、模板、ILM 策略和时间点，并取消 HoldTasks
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.indices = make(map[string]*index)
+	s.tasks = make(map[string]map[string]interface{})
+	s.scrolls = make(map[string]*scrollState)
+	s.repos = make(map[string]*repository)
+	s.pending = make(map[string]func() map[string]interface{})
+	s.templates = make(map[string]map[string]map[string]interface{})
+	s.policies = make(map[string]map[string]interface{})
+	s.pits = make(map[string][]*index)
+	s.holdTasks = false
+}
+
+// HoldTasks 为 true 时 update_by_query、delete_by_query 的异步任务保持执行中的状态，
+// 直到调用 ReleaseTasks 或任务被取消，用于测试进度轮询、取消和限流
+func (s *Server) HoldTasks(hold bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.holdTasks = hold
+}
+
+// ReleaseTasks 执行所有挂起的任务
+func (s *Server) ReleaseTasks() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, run := range s.pending {
+		s.finishTask(id, run())
+	}
+	s.pending = make(map[string]func() map[string]interface{})
+}
+
+// Indices 获取当前所有的索引名
+func (s *Server) Indices() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name := range s.indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Source 获取文档内容，用于断言，不存在时返回 nil
+func (s *Server) Source(indexName, id string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, err := s.writeIndex(indexName, false)
+	if err != nil || idx == nil {
+		return nil
+	}
+	doc, ok := idx.docs[id]
+	if !ok {
+		return nil
+	}
+	return copyMap(doc.source)
+}
+
+func (e *esError) Error() string {
+	return e.reason
+}
+
+func newError(status int, typ, format string, args ...interface{}) *esError {
+	return &esError{status: status, typ: typ, reason: fmt.Sprintf(format, args...)}
+}
+
+func indexNotFound(name string) *esError {
+	return newError(http.StatusNotFound, "index_not_found_exception", "no such index [%s]", name)
+}
+
+// serveHTTP 按路径分发请求
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var body []byte
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+	}
+
+	status, res := s.route(r, body)
+	if e, ok := res.(*esError); ok {
+		status = e.status
+		res = map[string]interface{}{
+			"error": map[string]interface{}{
+				"root_cause": []interface{}{map[string]interface{}{"type": e.typ, "reason": e.reason}},
+				"type":       e.typ,
+				"reason":     e.reason,
+			},
+			"status": e.status,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead || res == nil {
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// route 根据方法和路径找到对应的处理
+func (s *Server) route(r *http.Request, body []byte) (int, interface{}) {
+	parts := splitPath(r.URL.Path)
+	query := r.URL.Query()
+	m := r.Method
+
+	if len(parts) == 0 {
+		return http.StatusOK, map[string]interface{}{
+			"name":         "estest",
+			"cluster_name": "estest",
+			"version":      map[string]interface{}{"number": "7.10.0"},
+			"tagline":      "You Know, for Search",
+		}
+	}
+
+	switch parts[0] {
+	case "_aliases":
+		return s.updateAliases(body)
+	case "_alias":
+		if len(parts) > 1 {
+			return s.getAliases("", parts[1])
+		}
+		return s.getAliases("", "")
+	case "_bulk":
+		return s.bulk("", body, query.Get("refresh"))
+	case "_reindex", "_update_by_query", "_delete_by_query":
+		if len(parts) == 3 && parts[2] == "_rethrottle" {
+			return s.rethrottleTask(parts[1], requestsPerSecond(query))
+		}
+		if parts[0] != "_reindex" {
+			break
+		}
+		return s.reindex(body, query.Get("wait_for_completion") == "false")
+	case "_search":
+		if len(parts) > 1 && parts[1] == "scroll" {
+			return s.scroll(m, body)
+		}
+		return s.search("_all", body, query)
+	case "_snapshot":
+		return s.snapshotAPI(m, parts, body, query)
+	case "_pit":
+		if m == http.MethodDelete {
+			return s.closePointInTime(body)
+		}
+	case "_index_template", "_component_template":
+		return s.templateAPI(m, parts, body)
+	case "_ilm":
+		return s.ilmAPI(m, parts, body)
+	case "_tasks":
+		if len(parts) == 3 && parts[2] == "_cancel" {
+			return s.cancelTask(parts[1])
+		}
+		if len(parts) == 2 {
+			return s.getTask(parts[1])
+		}
+	}
+
+	name := parts[0]
+	if len(parts) == 1 {
+		switch m {
+		case http.MethodHead:
+			if len(s.resolve(name)) == 0 {
+				return http.StatusNotFound, nil
+			}
+			return http.StatusOK, nil
+		case http.MethodPut:
+			return s.createIndex(name, body)
+		case http.MethodDelete:
+			return s.deleteIndex(name)
+		case http.MethodGet:
+			return s.getIndex(name)
+		}
+	}
+
+	switch parts[1] {
+	case "_alias", "_aliases":
+		alias := ""
+		if len(parts) > 2 {
+			alias = parts[2]
+		}
+		return s.getAliases(name, alias)
+	case "_doc", "_create":
+		id := ""
+		if len(parts) > 2 {
+			id = parts[2]
+		}
+		switch m {
+		case http.MethodGet, http.MethodHead:
+			return s.getDoc(name, id)
+		case http.MethodDelete:
+			return s.deleteDoc(name, id, query)
+		default:
+			opType := query.Get("op_type")
+			if parts[1] == "_create" {
+				opType = "create"
+			}
+			return s.indexDoc(name, id, body, opType, query)
+		}
+	case "_update":
+		if len(parts) > 2 {
+			return s.updateDoc(name, parts[2], body, query)
+		}
+	case "_bulk":
+		return s.bulk(name, body, query.Get("refresh"))
+	case "_search":
+		return s.search(name, body, query)
+	case "_count":
+		return s.count(name, body)
+	case "_pit":
+		if m == http.MethodPost {
+			return s.openPointInTime(name)
+		}
+	case "_delete_by_query":
+		return s.byQuery(name, body, "delete", query)
+	case "_update_by_query":
+		return s.byQuery(name, body, "update", query)
+	case "_flush", "_refresh":
+		if len(s.resolve(name)) == 0 {
+			return 0, indexNotFound(name)
+		}
+		return http.StatusOK, map[string]interface{}{
+			"_shards": map[string]interface{}{"total": 1, "successful": 1, "failed": 0},
+		}
+	case "_settings":
+		if m == http.MethodPut {
+			return s.putSettings(name, body)
+		}
+		return s.getSettings(name)
+	case "_mapping":
+		if m == http.MethodPut {
+			return s.putMapping(name, body)
+		}
+		return s.getMapping(name)
+	}
+
+	return 0, newError(http.StatusBadRequest, "illegal_argument_exception", "estest: unsupported request %s %s", m, r.URL.Path)
+}
+
+// resolve 把索引名、别名、通配符或逗号分隔的列表解析为实际的索引
+func (s *Server) resolve(expr string) []*index {
+	seen := make(map[string]bool)
+	var result []*index
+
+	add := func(idx *index) {
+		if !seen[idx.name] {
+			seen[idx.name] = true
+			result = append(result, idx)
+		}
+	}
+
+	for _, name := range strings.Split(expr, ",") {
+		if name == "_all" || name == "*" {
+			for _, idx := range s.sortedIndices() {
+				add(idx)
+			}
+			continue
+		}
+		if idx, ok := s.indices[name]; ok {
+			add(idx)
+			continue
+		}
+		for _, idx := range s.sortedIndices() {
+			if _, ok := idx.aliases[name]; ok || wildcardMatch(name, idx.name) {
+				add(idx)
+			}
+		}
+	}
+
+	return result
+}
+
+// writeIndex 取得写入用的索引，别名指向多个索引时使用 is_write_index 的索引
+func (s *Server) writeIndex(name string, autoCreate bool) (*index, error) {
+	if idx, ok := s.indices[name]; ok {
+		return idx, nil
+	}
+
+	var candidates []*index
+	for _, idx := range s.sortedIndices() {
+		if a, ok := idx.aliases[name]; ok {
+			if w, _ := a["is_write_index"].(bool); w {
+				return idx, nil
+			}
+			candidates = append(candidates, idx)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		if !autoCreate {
+			return nil, indexNotFound(name)
+		}
+		idx := newIndex(name)
+		s.indices[name] = idx
+		return idx, nil
+	case 1:
+		return candidates[0], nil
+	}
+
+	return nil, newError(http.StatusBadRequest, "illegal_argument_exception",
+		"no write index is defined for alias [%s]", name)
+}
+
+// checkWrite 判断索引是否禁止写入
+func checkWrite(idx *index) error {
+	if blocked := fmt.Sprint(idx.settings["index.blocks.write"]); blocked == "true" {
+		return newError(http.StatusForbidden, "cluster_block_exception",
+			"index [%s] blocked by: [FORBIDDEN/8/index write (api)]", idx.name)
+	}
+	return nil
+}
+
+func (s *Server) sortedIndices() []*index {
+	var names []string
+	for name := range s.indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []*index
+	for _, name := range names {
+		result = append(result, s.indices[name])
+	}
+	return result
+}
+
+func newIndex(name string) *index {
+	return &index{
+		name:     name,
+		docs:     make(map[string]*document),
+		aliases:  make(map[string]map[string]interface{}),
+		settings: make(map[string]interface{}),
+		mappings: make(map[string]interface{}),
+	}
+}
+
+func (s *Server) createIndex(name string, body []byte) (int, interface{}) {
+	if _, ok := s.indices[name]; ok {
+		return 0, newError(http.StatusBadRequest, "resource_already_exists_exception", "index [%s] already exists", name)
+	}
+
+	var req struct {
+		Settings map[string]interface{}            `json:"settings"`
+		Mappings map[string]interface{}            `json:"mappings"`
+		Aliases  map[string]map[string]interface{} `json:"aliases"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+	}
+
+	idx := newIndex(name)
+	for k, v := range flatten("", req.Settings) {
+		idx.settings["index."+strings.TrimPrefix(k, "index.")] = v
+	}
+	if req.Mappings != nil {
+		idx.mappings = req.Mappings
+	}
+	for alias, def := range req.Aliases {
+		if def == nil {
+			def = make(map[string]interface{})
+		}
+		idx.aliases[alias] = def
+	}
+	s.indices[name] = idx
+
+	return http.StatusOK, map[string]interface{}{
+		"acknowledged":        true,
+		"shards_acknowledged": true,
+		"index":               name,
+	}
+}
+
+func (s *Server) deleteIndex(expr string) (int, interface{}) {
+	targets := s.resolve(expr)
+	if len(targets) == 0 {
+		return 0, indexNotFound(expr)
+	}
+	for _, idx := range targets {
+		delete(s.indices, idx.name)
+	}
+	return http.StatusOK, map[string]interface{}{"acknowledged": true}
+}
+
+func (s *Server) getIndex(expr string) (int, interface{}) {
+	targets := s.resolve(expr)
+	if len(targets) == 0 {
+		return 0, indexNotFound(expr)
+	}
+	res := make(map[string]interface{})
+	for _, idx := range targets {
+		res[idx.name] = map[string]interface{}{
+			"aliases":  idx.aliases,
+			"mappings": idx.mappings,
+			"settings": map[string]interface{}{"index": idx.settings},
+		}
+	}
+	return http.StatusOK, res
+}
+
+func (s *Server) updateAliases(body []byte) (int, interface{}) {
+	var req struct {
+		Actions []map[string]map[string]interface{} `json:"actions"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+	}
+
+	for _, action := range req.Actions {
+		for typ, def := range action {
+			names := stringList(def["index"], def["indices"])
+			aliases := stringList(def["alias"], def["aliases"])
+			for _, name := range names {
+				targets := s.resolve(name)
+				if len(targets) == 0 {
+					return 0, indexNotFound(name)
+				}
+				for _, idx := range targets {
+					for _, alias := range aliases {
+						switch typ {
+						case "add":
+							opts := make(map[string]interface{})
+							if w, ok := def["is_write_index"]; ok {
+								opts["is_write_index"] = w
+							}
+							idx.aliases[alias] = opts
+						case "remove":
+							if _, ok := idx.aliases[alias]; !ok {
+								return 0, newError(http.StatusNotFound, "aliases_not_found_exception", "aliases [%s] missing", alias)
+							}
+							delete(idx.aliases, alias)
+						}
+					}
+					if typ == "remove_index" {
+						delete(s.indices, idx.name)
+					}
+				}
+			}
+		}
+	}
+
+	return http.StatusOK, map[string]interface{}{"acknowledged": true}
+}
+
+func (s *Server) getAliases(indexExpr, alias string) (int, interface{}) {
+	targets := s.sortedIndices()
+	if indexExpr != "" {
+		targets = s.resolve(indexExpr)
+		if len(targets) == 0 {
+			return 0, indexNotFound(indexExpr)
+		}
+	}
+
+	res := make(map[string]interface{})
+	for _, idx := range targets {
+		aliases := make(map[string]interface{})
+		for name, def := range idx.aliases {
+			if alias == "" || wildcardMatch(alias, name) {
+				aliases[name] = def
+			}
+		}
+		if alias != "" && len(aliases) == 0 {
+			continue
+		}
+		res[idx.name] = map[string]interface{}{"aliases": aliases}
+	}
+
+	if alias != "" && len(res) == 0 {
+		return http.StatusNotFound, map[string]interface{}{
+			"error":  fmt.Sprintf("alias [%s] missing", alias),
+			"status": http.StatusNotFound,
+		}
+	}
+	return http.StatusOK, res
+}
+
+func (s *Server) putSettings(expr string, body []byte) (int, interface{}) {
+	targets := s.resolve(expr)
+	if len(targets) == 0 {
+		return 0, indexNotFound(expr)
+	}
+
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+	}
+	for _, idx := range targets {
+		for k, v := range flatten("", req) {
+			k = "index." + strings.TrimPrefix(k, "index.")
+			idx.settings[k] = v
+		}
+	}
+	return http.StatusOK, map[string]interface{}{"acknowledged": true}
+}
+
+func (s *Server) getSettings(expr string) (int, interface{}) {
+	targets := s.resolve(expr)
+	if len(targets) == 0 {
+		return 0, indexNotFound(expr)
+	}
+	res := make(map[string]interface{})
+	for _, idx := range targets {
+		res[idx.name] = map[string]interface{}{"settings": idx.settings}
+	}
+	return http.StatusOK, res
+}
+
+func (s *Server) putMapping(expr string, body []byte) (int, interface{}) {
+	targets := s.resolve(expr)
+	if len(targets) == 0 {
+		return 0, indexNotFound(expr)
+	}
+
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+	}
+	for _, idx := range targets {
+		for k, v := range req {
+			if k == "properties" {
+				props, _ := idx.mappings["properties"].(map[string]interface{})
+				if props == nil {
+					props = make(map[string]interface{})
+				}
+				for name, def := range v.(map[string]interface{}) {
+					props[name] = def
+				}
+				idx.mappings["properties"] = props
+				continue
+			}
+			// _meta 等其余项整体替换
+			idx.mappings[k] = v
+		}
+	}
+	return http.StatusOK, map[string]interface{}{"acknowledged": true}
+}
+
+func (s *Server) getMapping(expr string) (int, interface{}) {
+	targets := s.resolve(expr)
+	if len(targets) == 0 && expr != "_all" {
+		return 0, indexNotFound(expr)
+	}
+	res := make(map[string]interface{})
+	for _, idx := range targets {
+		res[idx.name] = map[string]interface{}{"mappings": idx.mappings}
+	}
+	return http.StatusOK, res
+}
+
+// docResult 文档写入后的返回
+func docResult(idx *index, doc *document, result string) map[string]interface{} {
+	return map[string]interface{}{
+		"_index":        idx.name,
+		"_type":         "_doc",
+		"_id":           doc.id,
+		"_version":      doc.version,
+		"result":        result,
+		"_seq_no":       doc.seqNo,
+		"_primary_term": 1,
+		"_shards":       map[string]interface{}{"total": 1, "successful": 1, "failed": 0},
+	}
+}
+
+func (s *Server) nextSeq() int64 {
+	s.seq++
+	return s.seq
+}
+
+func (s *Server) indexDoc(name, id string, body []byte, opType string, query map[string][]string) (int, interface{}) {
+	idx, err := s.writeIndex(name, true)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkWrite(idx); err != nil {
+		return 0, err
+	}
+
+	var source map[string]interface{}
+	if err := json.Unmarshal(body, &source); err != nil {
+		return 0, newError(http.StatusBadRequest, "mapper_parsing_exception", "failed to parse: %v", err)
+	}
+
+	if id == "" {
+		id = fmt.Sprintf("estest-%d", s.seq+1)
+	}
+
+	existing, exists := idx.docs[id]
+	if exists && opType == "create" {
+		return 0, newError(http.StatusConflict, "version_conflict_engine_exception",
+			"[%s]: version conflict, document already exists (current version [%d])", id, existing.version)
+	}
+	if err := checkVersion(id, existing, query); err != nil {
+		return 0, err
+	}
+
+	doc := &document{id: id, source: source, version: 1, created: s.nextSeq()}
+	doc.seqNo = doc.created
+	result := "created"
+	status := http.StatusCreated
+	if exists {
+		doc.version = existing.version + 1
+		doc.created = existing.created
+		result = "updated"
+		status = http.StatusOK
+	}
+	if v := first(query["version"]); v != "" && first(query["version_type"]) == "external" {
+		fmt.Sscan(v, &doc.version)
+	}
+	idx.docs[id] = doc
+
+	return status, docResult(idx, doc, result)
+}
+
+// checkVersion 检查 if_seq_no / if_primary_term 以及外部版本号
+func checkVersion(id string, existing *document, query map[string][]string) error {
+	if seq := first(query["if_seq_no"]); seq != "" {
+		var want int64
+		fmt.Sscan(seq, &want)
+		if existing == nil || existing.seqNo != want || (first(query["if_primary_term"]) != "" && first(query["if_primary_term"]) != "1") {
+			current := int64(-2)
+			if existing != nil {
+				current = existing.seqNo
+			}
+			return newError(http.StatusConflict, "version_conflict_engine_exception",
+				"[%s]: version conflict, required seqNo [%d], primary term [%s]. current document has seqNo [%d] and primary term [1]",
+				id, want, first(query["if_primary_term"]), current)
+		}
+	}
+
+	if v := first(query["version"]); v != "" && existing != nil {
+		var want int64
+		fmt.Sscan(v, &want)
+		switch first(query["version_type"]) {
+		case "external", "":
+			if want <= existing.version {
+				return newError(http.StatusConflict, "version_conflict_engine_exception",
+					"[%s]: version conflict, current version [%d] is higher or equal to the one provided [%d]", id, existing.version, want)
+			}
+		case "external_gte":
+			if want < existing.version {
+				return newError(http.StatusConflict, "version_conflict_engine_exception",
+					"[%s]: version conflict, current version [%d] is higher than the one provided [%d]", id, existing.version, want)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) getDoc(name, id string) (int, interface{}) {
+	idx, err := s.writeIndex(name, false)
+	if err != nil {
+		return 0, err
+	}
+
+	doc, ok := idx.docs[id]
+	if !ok {
+		return http.StatusNotFound, map[string]interface{}{
+			"_index": idx.name,
+			"_type":  "_doc",
+			"_id":    id,
+			"found":  false,
+		}
+	}
+
+	return http.StatusOK, map[string]interface{}{
+		"_index":        idx.name,
+		"_type":         "_doc",
+		"_id":           id,
+		"_version":      doc.version,
+		"_seq_no":       doc.seqNo,
+		"_primary_term": 1,
+		"found":         true,
+		"_source":       doc.source,
+	}
+}
+
+func (s *Server) deleteDoc(name, id string, query map[string][]string) (int, interface{}) {
+	idx, err := s.writeIndex(name, false)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkWrite(idx); err != nil {
+		return 0, err
+	}
+
+	doc, ok := idx.docs[id]
+	if err := checkVersion(id, doc, query); err != nil {
+		return 0, err
+	}
+	if !ok {
+		return http.StatusNotFound, map[string]interface{}{
+			"_index": idx.name, "_type": "_doc", "_id": id, "_version": 1, "result": "not_found",
+		}
+	}
+
+	delete(idx.docs, id)
+	doc.version++
+	doc.seqNo = s.nextSeq()
+	return http.StatusOK, docResult(idx, doc, "deleted")
+}
+
+func (s *Server) updateDoc(name, id string, body []byte, query map[string][]string) (int, interface{}) {
+	idx, err := s.writeIndex(name, true)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkWrite(idx); err != nil {
+		return 0, err
+	}
+
+	var req struct {
+		Doc         map[string]interface{} `json:"doc"`
+		DocAsUpsert bool                   `json:"doc_as_upsert"`
+		Upsert      map[string]interface{} `json:"upsert"`
+		Script      interface{}            `json:"script"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+	}
+
+	existing, ok := idx.docs[id]
+	if err := checkVersion(id, existing, query); err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		var source map[string]interface{}
+		switch {
+		case req.Upsert != nil:
+			source = req.Upsert
+		case req.DocAsUpsert && req.Doc != nil:
+			source = req.Doc
+		default:
+			return 0, newError(http.StatusNotFound, "document_missing_exception", "[_doc][%s]: document missing", id)
+		}
+		doc := &document{id: id, source: copyMap(source), version: 1, created: s.nextSeq()}
+		doc.seqNo = doc.created
+		idx.docs[id] = doc
+		return http.StatusCreated, docResult(idx, doc, "created")
+	}
+
+	source := copyMap(existing.source)
+	if req.Script != nil {
+		if err := runScript(req.Script, source); err != nil {
+			return 0, err
+		}
+	} else {
+		mergeMap(source, req.Doc)
+	}
+
+	existing.source = source
+	existing.version++
+	existing.seqNo = s.nextSeq()
+	return http.StatusOK, docResult(idx, existing, "updated")
+}
+
+func (s *Server) search(expr string, body []byte, params map[string][]string) (int, interface{}) {
+	var req struct {
+		Query       map[string]interface{} `json:"query"`
+		From        *int                   `json:"from"`
+		Size        *int                   `json:"size"`
+		Sort        interface{}            `json:"sort"`
+		SearchAfter []interface{}          `json:"search_after"`
+		Pit         *struct {
+			ID string `json:"id"`
+		} `json:"pit"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+	}
+
+	var targets []*index
+	if req.Pit != nil {
+		view, err := s.pitTargets(req.Pit.ID)
+		if err != nil {
+			return 0, err
+		}
+		targets = view
+	} else {
+		targets = s.resolve(expr)
+		if len(targets) == 0 && !strings.ContainsAny(expr, "*") {
+			return 0, indexNotFound(expr)
+		}
+	}
+
+	hits, err := s.matchAll(targets, req.Query)
+	if err != nil {
+		return 0, err
+	}
+
+	sorts := parseSort(req.Sort)
+	sortHits(hits, sorts)
+
+	// 与 ES 相同，命中总数不受 search_after 影响
+	total := len(hits)
+	if len(req.SearchAfter) > 0 {
+		var rest []hit
+		for _, h := range hits {
+			if compareValues(sortValues(h, sorts), req.SearchAfter, sorts) > 0 {
+				rest = append(rest, h)
+			}
+		}
+		hits = rest
+	}
+
+	from, size := 0, 10
+	if req.From != nil {
+		from = *req.From
+	}
+	if req.Size != nil {
+		size = *req.Size
+	}
+	if from > len(hits) {
+		from = len(hits)
+	}
+	end := from + size
+	if end > len(hits) {
+		end = len(hits)
+	}
+
+	res := searchResponse(hits[from:end], total, sorts)
+	if req.Pit != nil {
+		res["pit_id"] = req.Pit.ID
+	}
+	if first(params["scroll"]) != "" {
+		s.scrollSeq++
+		id := fmt.Sprintf("estest-scroll-%d", s.scrollSeq)
+		s.scrolls[id] = &scrollState{hits: hits[end:], size: size, total: total, sorts: sorts}
+		res["_scroll_id"] = id
+	}
+	return http.StatusOK, res
+}
+
+// scroll 取得 scroll 的下一页，DELETE 时清除
+func (s *Server) scroll(method string, body []byte) (int, interface{}) {
+	var req struct {
+		ScrollID interface{} `json:"scroll_id"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+	}
+
+	if method == http.MethodDelete {
+		freed := 0
+		for _, id := range stringList(req.ScrollID) {
+			if _, ok := s.scrolls[id]; ok {
+				delete(s.scrolls, id)
+				freed++
+			}
+		}
+		return http.StatusOK, map[string]interface{}{"succeeded": true, "num_freed": freed}
+	}
+
+	id := fmt.Sprint(req.ScrollID)
+	state, ok := s.scrolls[id]
+	if !ok {
+		return 0, newError(http.StatusNotFound, "search_context_missing_exception", "No search context found for id [%s]", id)
+	}
+
+	end := state.size
+	if end > len(state.hits) {
+		end = len(state.hits)
+	}
+	page := state.hits[:end]
+	state.hits = state.hits[end:]
+
+	res := searchResponse(page, state.total, state.sorts)
+	res["_scroll_id"] = id
+	return http.StatusOK, res
+}
+
+// searchResponse 生成检索结果
+func searchResponse(hits []hit, total int, sorts []sortKey) map[string]interface{} {
+	var out []interface{}
+	for _, h := range hits {
+		item := map[string]interface{}{
+			"_index":   h.index.name,
+			"_type":    "_doc",
+			"_id":      h.doc.id,
+			"_score":   1.0,
+			"_seq_no":  h.doc.seqNo,
+			"_version": h.doc.version,
+			"_source":  h.doc.source,
+		}
+		if len(sorts) > 0 {
+			item["sort"] = sortValues(h, sorts)
+		}
+		out = append(out, item)
+	}
+	if out == nil {
+		out = []interface{}{}
+	}
+
+	return map[string]interface{}{
+		"took":      1,
+		"timed_out": false,
+		"_shards":   map[string]interface{}{"total": 1, "successful": 1, "failed": 0},
+		"hits": map[string]interface{}{
+			"total":     map[string]interface{}{"value": total, "relation": "eq"},
+			"max_score": 1.0,
+			"hits":      out,
+		},
+	}
+}
+
+func (s *Server) count(expr string, body []byte) (int, interface{}) {
+	targets := s.resolve(expr)
+	if len(targets) == 0 {
+		return 0, indexNotFound(expr)
+	}
+
+	var req struct {
+		Query map[string]interface{} `json:"query"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+	}
+
+	hits, err := s.matchAll(targets, req.Query)
+	if err != nil {
+		return 0, err
+	}
+	return http.StatusOK, map[string]interface{}{"count": len(hits)}
+}
+
+// byQuery 执行 delete_by_query / update_by_query
+func (s *Server) byQuery(expr string, body []byte, action string, params map[string][]string) (int, interface{}) {
+	targets := s.resolve(expr)
+	if len(targets) == 0 {
+		return 0, indexNotFound(expr)
+	}
+
+	var req struct {
+		Query  map[string]interface{} `json:"query"`
+		Script interface{}            `json:"script"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+	}
+
+	hits, err := s.matchAll(targets, req.Query)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, h := range hits {
+		if err := checkWrite(h.index); err != nil {
+			return 0, err
+		}
+		if action == "update" && req.Script != nil {
+			if err := runScript(req.Script, copyMap(h.doc.source)); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	slices := 1
+	if v := first(params["slices"]); v == "auto" {
+		slices = len(targets)
+	} else if n, err := strconv.Atoi(v); err == nil && n > 1 {
+		slices = n
+	}
+
+	run := func() map[string]interface{} {
+		done := make([]int, slices)
+		for i, h := range hits {
+			switch action {
+			case "delete":
+				delete(h.index.docs, h.doc.id)
+			case "update":
+				if req.Script != nil {
+					runScript(req.Script, h.doc.source)
+				}
+				h.doc.version++
+				h.doc.seqNo = s.nextSeq()
+			}
+			done[i%slices]++
+		}
+		return byQueryResult(action, len(hits), done)
+	}
+
+	if first(params["wait_for_completion"]) == "false" {
+		return http.StatusOK, map[string]interface{}{
+			"task": s.startTask(action+"_by_query", len(hits), requestsPerSecond(params), run),
+		}
+	}
+	return http.StatusOK, run()
+}
+
+// byQueryResult 生成 by_query 的结果，done 为各切片处理的文档数
+func byQueryResult(action string, total int, done []int) map[string]interface{} {
+	counts := func(n, t int) map[string]interface{} {
+		res := map[string]interface{}{
+			"total":               t,
+			"updated":             0,
+			"deleted":             0,
+			"batches":             1,
+			"version_conflicts":   0,
+			"noops":               0,
+			"requests_per_second": -1,
+		}
+		if action == "delete" {
+			res["deleted"] = n
+		} else {
+			res["updated"] = n
+		}
+		return res
+	}
+
+	sum := 0
+	var slices []interface{}
+	for i, n := range done {
+		sum += n
+		if len(done) > 1 {
+			slice := counts(n, n)
+			slice["slice_id"] = i
+			slices = append(slices, slice)
+		}
+	}
+
+	res := counts(sum, total)
+	res["took"] = 1
+	res["timed_out"] = false
+	res["failures"] = []interface{}{}
+	if slices != nil {
+		res["slices"] = slices
+	}
+	return res
+}
+
+// requestsPerSecond 取得限流参数，未指定或不限流时为 -1
+func requestsPerSecond(params map[string][]string) float64 {
+	if v, err := strconv.ParseFloat(first(params["requests_per_second"]), 64); err == nil && v > 0 {
+		return v
+	}
+	return -1
+}
+
+func (s *Server) reindex(body []byte, async bool) (int, interface{}) {
+	var req struct {
+		Source struct {
+			Index interface{}            `json:"index"`
+			Query map[string]interface{} `json:"query"`
+		} `json:"source"`
+		Dest struct {
+			Index   string `json:"index"`
+			OpType  string `json:"op_type"`
+			Routing string `json:"routing"`
+		} `json:"dest"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+	}
+
+	var targets []*index
+	for _, name := range stringList(req.Source.Index) {
+		found := s.resolve(name)
+		if len(found) == 0 {
+			return 0, indexNotFound(name)
+		}
+		targets = append(targets, found...)
+	}
+
+	hits, err := s.matchAll(targets, req.Source.Query)
+	if err != nil {
+		return 0, err
+	}
+
+	dst, e := s.writeIndex(req.Dest.Index, true)
+	if e != nil {
+		return 0, e
+	}
+	if err := checkWrite(dst); err != nil {
+		return 0, err
+	}
+
+	created, updated := 0, 0
+	for _, h := range hits {
+		existing, ok := dst.docs[h.doc.id]
+		doc := &document{id: h.doc.id, source: copyMap(h.doc.source), version: 1, created: s.nextSeq()}
+		doc.seqNo = doc.created
+		if ok {
+			if req.Dest.OpType == "create" {
+				continue
+			}
+			doc.version = existing.version + 1
+			updated++
+		} else {
+			created++
+		}
+		dst.docs[doc.id] = doc
+	}
+
+	res := map[string]interface{}{
+		"took":              1,
+		"timed_out":         false,
+		"total":             len(hits),
+		"created":           created,
+		"updated":           updated,
+		"deleted":           0,
+		"batches":           1,
+		"version_conflicts": 0,
+		"noops":             0,
+		"failures":          []interface{}{},
+	}
+
+	if async {
+		return http.StatusOK, map[string]interface{}{"task": s.completeTask("reindex", res)}
+	}
+	return http.StatusOK, res
+}
+
+// completeTask 登记一个已完成的任务
+func (s *Server) completeTask(action string, res map[string]interface{}) string {
+	id := s.newTask(action, nil)
+	s.finishTask(id, res)
+	return id
+}
+
+// startTask 登记异步任务，HoldTasks 时挂起，否则立即执行
+func (s *Server) startTask(action string, total int, rps float64, run func() map[string]interface{}) string {
+	id := s.newTask(action, map[string]interface{}{
+		"total":               total,
+		"updated":             0,
+		"created":             0,
+		"deleted":             0,
+		"batches":             0,
+		"version_conflicts":   0,
+		"noops":               0,
+		"requests_per_second": rps,
+	})
+	if !s.holdTasks {
+		res := run()
+		res["requests_per_second"] = rps
+		s.finishTask(id, res)
+		return id
+	}
+
+	s.pending[id] = func() map[string]interface{} {
+		res := run()
+		res["requests_per_second"] = s.taskStatus(id)["requests_per_second"]
+		return res
+	}
+	return id
+}
+
+// newTask 登记执行中的任务
+func (s *Server) newTask(action string, status map[string]interface{}) string {
+	s.taskSeq++
+	id := fmt.Sprintf("estest:%d", s.taskSeq)
+
+	s.tasks[id] = map[string]interface{}{
+		"completed": false,
+		"task": map[string]interface{}{
+			"node":        "estest",
+			"id":          s.taskSeq,
+			"type":        "transport",
+			"action":      "indices:data/write/" + action,
+			"status":      status,
+			"cancellable": true,
+		},
+	}
+	return id
+}
+
+// finishTask 记录任务的结果
+func (s *Server) finishTask(id string, res map[string]interface{}) {
+	status := make(map[string]interface{})
+	for k, v := range res {
+		if k != "took" && k != "timed_out" && k != "failures" {
+			status[k] = v
+		}
+	}
+
+	task := s.tasks[id]
+	task["completed"] = true
+	task["task"].(map[string]interface{})["status"] = status
+	task["response"] = res
+}
+
+func (s *Server) taskStatus(id string) map[string]interface{} {
+	status, _ := s.tasks[id]["task"].(map[string]interface{})["status"].(map[string]interface{})
+	return status
+}
+
+func (s *Server) getTask(id string) (int, interface{}) {
+	task, ok := s.tasks[id]
+	if !ok {
+		return 0, newError(http.StatusNotFound, "resource_not_found_exception", "task [%s] isn't running and hasn't stored its results", id)
+	}
+	return http.StatusOK, task
+}
+
+// cancelTask 取消挂起的任务，任务以未处理任何文档的状态结束
+func (s *Server) cancelTask(id string) (int, interface{}) {
+	if _, ok := s.tasks[id]; !ok {
+		return 0, newError(http.StatusNotFound, "resource_not_found_exception", "task [%s] is missing", id)
+	}
+	if _, ok := s.pending[id]; ok {
+		delete(s.pending, id)
+		res := copyMap(s.taskStatus(id))
+		res["canceled"] = "by user request"
+		res["failures"] = []interface{}{}
+		s.finishTask(id, res)
+	}
+	return http.StatusOK, map[string]interface{}{"nodes": map[string]interface{}{}}
+}
+
+// rethrottleTask 修改执行中的任务的限流
+func (s *Server) rethrottleTask(id string, rps float64) (int, interface{}) {
+	if _, ok := s.pending[id]; !ok {
+		return 0, newError(http.StatusNotFound, "resource_not_found_exception", "task [%s] is missing", id)
+	}
+	s.taskStatus(id)["requests_per_second"] = rps
+	return http.StatusOK, map[string]interface{}{"nodes": map[string]interface{}{}}
+}
+
+// bulk 执行 _bulk 请求
+func (s *Server) bulk(defaultIndex string, body []byte, refresh string) (int, interface{}) {
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+
+	var items []interface{}
+	hasErrors := false
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+
+		var meta map[string]map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &meta); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+
+		for action, m := range meta {
+			name, _ := m["_index"].(string)
+			if name == "" {
+				name = defaultIndex
+			}
+			id, _ := m["_id"].(string)
+			query := map[string][]string{}
+			if v, ok := m["if_seq_no"]; ok {
+				query["if_seq_no"] = []string{fmt.Sprint(v)}
+				query["if_primary_term"] = []string{fmt.Sprint(m["if_primary_term"])}
+			}
+
+			var status int
+			var res interface{}
+			switch action {
+			case "index", "create":
+				i++
+				opType := ""
+				if action == "create" {
+					opType = "create"
+				}
+				status, res = s.indexDoc(name, id, []byte(lines[i]), opType, query)
+			case "update":
+				i++
+				status, res = s.updateDoc(name, id, []byte(lines[i]), query)
+			case "delete":
+				status, res = s.deleteDoc(name, id, query)
+			default:
+				return 0, newError(http.StatusBadRequest, "illegal_argument_exception", "unknown bulk action [%s]", action)
+			}
+
+			var item map[string]interface{}
+			if e, ok := res.(*esError); ok {
+				hasErrors = true
+				item = map[string]interface{}{
+					"_index": name,
+					"_type":  "_doc",
+					"_id":    id,
+					"status": e.status,
+					"error":  map[string]interface{}{"type": e.typ, "reason": e.reason},
+				}
+			} else {
+				item = res.(map[string]interface{})
+				item["status"] = status
+				if status == http.StatusNotFound {
+					hasErrors = true
+				}
+			}
+			items = append(items, map[string]interface{}{action: item})
+		}
+	}
+
+	return http.StatusOK, map[string]interface{}{
+		"took":   1,
+		"errors": hasErrors,
+		"items":  items,
+	}
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,315 @@
+package estest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// repository 快照仓库
+	repository struct {
+		typ       string
+		settings  map[string]interface{}
+		snapshots map[string]*snapshot
+	}
+
+	// snapshot 快照，保存创建时索引的副本
+	snapshot struct {
+		name    string
+		uuid    string
+		indices []*index
+		start   time.Time
+		end     time.Time
+	}
+)
+
+// snapshotAPI 处理 /_snapshot 下的请求
+func (s *Server) snapshotAPI(m string, parts []string, body []byte, query map[string][]string) (int, interface{}) {
+	if len(parts) < 2 {
+		res := make(map[string]interface{})
+		for name, repo := range s.repos {
+			res[name] = repo.info()
+		}
+		return http.StatusOK, res
+	}
+
+	name := parts[1]
+	if len(parts) == 2 {
+		switch m {
+		case http.MethodPut, http.MethodPost:
+			return s.putRepository(name, body)
+		case http.MethodDelete:
+			if _, ok := s.repos[name]; !ok {
+				return 0, repositoryMissing(name)
+			}
+			delete(s.repos, name)
+			return http.StatusOK, map[string]interface{}{"acknowledged": true}
+		case http.MethodGet:
+			repo, ok := s.repos[name]
+			if !ok {
+				return 0, repositoryMissing(name)
+			}
+			return http.StatusOK, map[string]interface{}{name: repo.info()}
+		}
+	}
+
+	repo, ok := s.repos[name]
+	if !ok {
+		return 0, repositoryMissing(name)
+	}
+	if len(parts) == 3 && parts[2] == "_verify" {
+		return http.StatusOK, map[string]interface{}{"nodes": map[string]interface{}{}}
+	}
+
+	wait := first(query["wait_for_completion"]) == "true"
+	snap := parts[2]
+	if len(parts) == 4 && parts[3] == "_restore" {
+		return s.restoreSnapshot(repo, snap, body, wait)
+	}
+
+	switch m {
+	case http.MethodPut, http.MethodPost:
+		return s.createSnapshot(repo, snap, body, wait)
+	case http.MethodGet:
+		return repo.getSnapshots(name, snap)
+	case http.MethodDelete:
+		if _, ok := repo.snapshots[snap]; !ok {
+			return 0, snapshotMissing(name, snap)
+		}
+		delete(repo.snapshots, snap)
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	}
+
+	return 0, newError(http.StatusBadRequest, "illegal_argument_exception", "estest: unsupported snapshot request %s", m)
+}
+
+func repositoryMissing(name string) *esError {
+	return newError(http.StatusNotFound, "repository_missing_exception", "[%s] missing", name)
+}
+
+func snapshotMissing(repo, name string) *esError {
+	return newError(http.StatusNotFound, "snapshot_missing_exception", "[%s:%s] is missing", repo, name)
+}
+
+func (r *repository) info() map[string]interface{} {
+	return map[string]interface{}{"type": r.typ, "settings": r.settings}
+}
+
+func (s *Server) putRepository(name string, body []byte) (int, interface{}) {
+	var req struct {
+		Type     string                 `json:"type"`
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+	}
+	if req.Type != "fs" {
+		return 0, newError(http.StatusInternalServerError, "repository_exception", "[%s] repository type [%s] does not exist", name, req.Type)
+	}
+	if loc, _ := req.Settings["location"].(string); loc == "" {
+		return 0, newError(http.StatusInternalServerError, "repository_exception", "[%s] missing location", name)
+	}
+
+	repo, ok := s.repos[name]
+	if !ok {
+		repo = &repository{snapshots: make(map[string]*snapshot)}
+		s.repos[name] = repo
+	}
+	repo.typ = req.Type
+	repo.settings = req.Settings
+
+	return http.StatusOK, map[string]interface{}{"acknowledged": true}
+}
+
+func (s *Server) createSnapshot(repo *repository, name string, body []byte, wait bool) (int, interface{}) {
+	if _, ok := repo.snapshots[name]; ok {
+		return 0, newError(http.StatusBadRequest, "invalid_snapshot_name_exception", "[%s] Invalid snapshot name [%s], snapshot with the same name already exists", name, name)
+	}
+	if name != strings.ToLower(name) {
+		return 0, newError(http.StatusBadRequest, "invalid_snapshot_name_exception", "[%s] Invalid snapshot name [%s], must be lowercase", name, name)
+	}
+
+	var req struct {
+		Indices           interface{} `json:"indices"`
+		IgnoreUnavailable bool        `json:"ignore_unavailable"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+	}
+
+	expr := "_all"
+	switch v := req.Indices.(type) {
+	case string:
+		expr = v
+	case []interface{}:
+		var names []string
+		for _, n := range v {
+			names = append(names, fmt.Sprint(n))
+		}
+		expr = strings.Join(names, ",")
+	}
+
+	snap := &snapshot{name: name, start: time.Now()}
+	for _, n := range strings.Split(expr, ",") {
+		targets := s.resolve(n)
+		if len(targets) == 0 && !req.IgnoreUnavailable && n != "_all" && !strings.Contains(n, "*") {
+			return 0, indexNotFound(n)
+		}
+		for _, idx := range targets {
+			snap.indices = append(snap.indices, cloneIndex(idx, idx.name))
+		}
+	}
+	s.seq++
+	snap.uuid = fmt.Sprintf("snapshot-%d", s.seq)
+	snap.end = time.Now()
+	repo.snapshots[name] = snap
+
+	if !wait {
+		return http.StatusOK, map[string]interface{}{"accepted": true}
+	}
+	return http.StatusOK, map[string]interface{}{"snapshot": snap.info()}
+}
+
+func (r *repository) getSnapshots(repo, expr string) (int, interface{}) {
+	var snaps []*snapshot
+	if expr == "_all" || expr == "*" {
+		for _, snap := range r.snapshots {
+			snaps = append(snaps, snap)
+		}
+	} else {
+		for _, name := range strings.Split(expr, ",") {
+			snap, ok := r.snapshots[name]
+			if !ok {
+				return 0, snapshotMissing(repo, name)
+			}
+			snaps = append(snaps, snap)
+		}
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].start.Before(snaps[j].start) })
+
+	list := make([]interface{}, 0, len(snaps))
+	for _, snap := range snaps {
+		list = append(list, snap.info())
+	}
+	return http.StatusOK, map[string]interface{}{"snapshots": list}
+}
+
+func (s *Server) restoreSnapshot(repo *repository, name string, body []byte, wait bool) (int, interface{}) {
+	snap, ok := repo.snapshots[name]
+	if !ok {
+		return 0, newError(http.StatusNotFound, "snapshot_restore_exception", "[%s] snapshot does not exist", name)
+	}
+
+	req := struct {
+		Indices           string                 `json:"indices"`
+		RenamePattern     string                 `json:"rename_pattern"`
+		RenameReplacement string                 `json:"rename_replacement"`
+		IncludeAliases    *bool                  `json:"include_aliases"`
+		IndexSettings     map[string]interface{} `json:"index_settings"`
+	}{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+	}
+
+	var pattern *regexp.Regexp
+	if req.RenamePattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(req.RenamePattern); err != nil {
+			return 0, newError(http.StatusBadRequest, "illegal_argument_exception", "%v", err)
+		}
+	}
+
+	var restored []*index
+	for _, idx := range snap.indices {
+		if req.Indices != "" && !matchAny(req.Indices, idx.name) {
+			continue
+		}
+		target := idx.name
+		if pattern != nil {
+			target = pattern.ReplaceAllString(idx.name, req.RenameReplacement)
+		}
+		if _, ok := s.indices[target]; ok {
+			return 0, newError(http.StatusInternalServerError, "snapshot_restore_exception",
+				"[%s] cannot restore index [%s] because an open index with same name already exists in the cluster", name, target)
+		}
+		restored = append(restored, cloneIndex(idx, target))
+	}
+
+	var names []string
+	for _, idx := range restored {
+		if req.IncludeAliases != nil && !*req.IncludeAliases {
+			idx.aliases = make(map[string]map[string]interface{})
+		}
+		for k, v := range flatten("", req.IndexSettings) {
+			idx.settings["index."+strings.TrimPrefix(k, "index.")] = v
+		}
+		s.indices[idx.name] = idx
+		names = append(names, idx.name)
+	}
+
+	if !wait {
+		return http.StatusOK, map[string]interface{}{"accepted": true}
+	}
+	return http.StatusOK, map[string]interface{}{
+		"snapshot": map[string]interface{}{
+			"snapshot": name,
+			"indices":  names,
+			"shards":   map[string]interface{}{"total": len(names), "successful": len(names), "failed": 0},
+		},
+	}
+}
+
+func (snap *snapshot) info() map[string]interface{} {
+	var names []string
+	for _, idx := range snap.indices {
+		names = append(names, idx.name)
+	}
+	return map[string]interface{}{
+		"snapshot":             snap.name,
+		"uuid":                 snap.uuid,
+		"indices":              names,
+		"state":                "SUCCESS",
+		"start_time":           snap.start.UTC().Format(time.RFC3339Nano),
+		"start_time_in_millis": snap.start.UnixNano() / int64(time.Millisecond),
+		"end_time":             snap.end.UTC().Format(time.RFC3339Nano),
+		"end_time_in_millis":   snap.end.UnixNano() / int64(time.Millisecond),
+		"duration_in_millis":   snap.end.Sub(snap.start).Nanoseconds() / int64(time.Millisecond),
+		"failures":             []interface{}{},
+		"shards":               map[string]interface{}{"total": len(names), "successful": len(names), "failed": 0},
+	}
+}
+
+// matchAny 判断索引名是否匹配逗号分隔的名称或通配符列表
+func matchAny(expr, name string) bool {
+	for _, p := range strings.Split(expr, ",") {
+		if p == "_all" || p == name || wildcardMatch(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneIndex 复制索引的设置、mapping、别名和文档
+func cloneIndex(src *index, name string) *index {
+	idx := newIndex(name)
+	idx.settings = copyMap(src.settings)
+	idx.mappings = copyMap(src.mappings)
+	for alias, def := range src.aliases {
+		idx.aliases[alias] = copyMap(def)
+	}
+	for id, doc := range src.docs {
+		d := *doc
+		d.source = copyMap(doc.source)
+		idx.docs[id] = &d
+	}
+	return idx
+}
@@ -0,0 +1,107 @@
+package estest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// templateAPI 处理 /_index_template、/_component_template 下的请求，只保存内容，不应用到新建的索引
+func (s *Server) templateAPI(m string, parts []string, body []byte) (int, interface{}) {
+	kind := parts[0]
+	store := s.templates[kind]
+	if store == nil {
+		store = make(map[string]map[string]interface{})
+		s.templates[kind] = store
+	}
+
+	if len(parts) < 2 {
+		if m != http.MethodGet {
+			return 0, newError(http.StatusBadRequest, "illegal_argument_exception", "template name is required")
+		}
+		return http.StatusOK, templateList(kind, store, "")
+	}
+
+	name := parts[1]
+	switch m {
+	case http.MethodPut, http.MethodPost:
+		var tpl map[string]interface{}
+		if err := json.Unmarshal(body, &tpl); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+		store[name] = tpl
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	case http.MethodDelete:
+		if _, ok := store[name]; !ok {
+			return 0, newError(http.StatusNotFound, "resource_not_found_exception", "%s [%s] missing", kind, name)
+		}
+		delete(store, name)
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	case http.MethodHead:
+		if _, ok := store[name]; !ok {
+			return http.StatusNotFound, nil
+		}
+		return http.StatusOK, nil
+	}
+
+	if _, ok := store[name]; !ok {
+		return 0, newError(http.StatusNotFound, "resource_not_found_exception", "%s [%s] missing", kind, name)
+	}
+	return http.StatusOK, templateList(kind, store, name)
+}
+
+// templateList 生成 GET 的返回，name 为空时返回全部
+func templateList(kind string, store map[string]map[string]interface{}, name string) map[string]interface{} {
+	// _index_template -> index_templates, index_template
+	key := kind[1:]
+
+	var names []string
+	for n := range store {
+		if name == "" || n == name {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	list := make([]interface{}, 0, len(names))
+	for _, n := range names {
+		list = append(list, map[string]interface{}{"name": n, key: store[n]})
+	}
+	return map[string]interface{}{key + "s": list}
+}
+
+// ilmAPI 处理 /_ilm/policy 下的请求
+func (s *Server) ilmAPI(m string, parts []string, body []byte) (int, interface{}) {
+	if len(parts) < 3 || parts[1] != "policy" {
+		return 0, newError(http.StatusBadRequest, "illegal_argument_exception", "estest: unsupported ilm request")
+	}
+
+	name := parts[2]
+	switch m {
+	case http.MethodPut:
+		var req struct {
+			Policy map[string]interface{} `json:"policy"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return 0, newError(http.StatusBadRequest, "parse_exception", "%v", err)
+		}
+		version := 1
+		if old, ok := s.policies[name]; ok {
+			version = old["version"].(int) + 1
+		}
+		s.policies[name] = map[string]interface{}{"version": version, "policy": req.Policy}
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	case http.MethodDelete:
+		if _, ok := s.policies[name]; !ok {
+			return 0, newError(http.StatusNotFound, "resource_not_found_exception", "Lifecycle policy not found: %s", name)
+		}
+		delete(s.policies, name)
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	}
+
+	p, ok := s.policies[name]
+	if !ok {
+		return 0, newError(http.StatusNotFound, "resource_not_found_exception", "Lifecycle policy not found: %s", name)
+	}
+	return http.StatusOK, map[string]interface{}{name: p}
+}
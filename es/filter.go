@@ -0,0 +1,519 @@
+package es
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// 前端传入的过滤条件转换为 bool 查询
+//
+// JSON 形式：
+//
+//	{"op": "and", "filters": [
+//	    {"op": "eq", "field": "status", "value": "active"},
+//	    {"op": "or", "filters": [
+//	        {"op": "gte", "field": "amount", "value": 100},
+//	        {"op": "in", "field": "tags", "values": ["a", "b"]}
+//	    ]}
+//	]}
+//
+// 表达式形式：
+//
+//	status = "active" and (amount >= 100 or tags in ("a", "b")) and not memo exists
+//
+// 条件的运算符：= != > >= < <= in、not in、prefix、like（通配符，* 和 ? 有效）、contains（部分一致）、exists
+
+// 过滤条件的运算符
+const (
+	FilterAnd      = "and"
+	FilterOr       = "or"
+	FilterNot      = "not"
+	FilterEq       = "eq"
+	FilterNe       = "ne"
+	FilterIn       = "in"
+	FilterNotIn    = "nin"
+	FilterGt       = "gt"
+	FilterGte      = "gte"
+	FilterLt       = "lt"
+	FilterLte      = "lte"
+	FilterBetween  = "between"
+	FilterPrefix   = "prefix"
+	FilterWildcard = "wildcard"
+	FilterContains = "contains"
+	FilterExists   = "exists"
+)
+
+type (
+	// Filter 过滤条件，and/or/not 使用 Filters，其余为字段条件
+	Filter struct {
+		Op      string        `json:"op"`
+		Field   string        `json:"field,omitempty"`
+		Value   interface{}   `json:"value,omitempty"`  // eq/ne/gt/gte/lt/lte/prefix/wildcard/contains 的值，exists 为 false 时表示不存在
+		Values  []interface{} `json:"values,omitempty"` // in/nin 的值，between 为 [from, to]，为 null 的一端不限制
+		Filters []Filter      `json:"filters,omitempty"`
+	}
+)
+
+var (
+	// ErrInvalidFilter 过滤条件不正确
+	ErrInvalidFilter = errors.New("invalid filter")
+
+	// filterField 允许的字段名，防止传入脚本或特殊字段
+	filterField = regexp.MustCompile(`^[A-Za-z][\w]*(\.[\w]+)*$`)
+)
+
+// EscapeWildcard 转义通配符查询中的特殊字符，使输入按字面匹配
+func EscapeWildcard(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+	return r.Replace(s)
+}
+
+// BuildFilter 把过滤条件转换为 bool 查询
+func BuildFilter(f Filter) (*elastic.BoolQuery, error) {
+	q, err := buildFilter(f, 0)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := q.(*elastic.BoolQuery); ok {
+		return b, nil
+	}
+	return elastic.NewBoolQuery().Filter(q), nil
+}
+
+// ParseFilterJSON 解析 JSON 形式的过滤条件并转换为 bool 查询
+func ParseFilterJSON(data []byte) (*elastic.BoolQuery, error) {
+	var f Filter
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+	return BuildFilter(f)
+}
+
+// ParseFilterExpr 解析表达式形式的过滤条件并转换为 bool 查询
+func ParseFilterExpr(expr string) (*elastic.BoolQuery, error) {
+	f, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	return BuildFilter(*f)
+}
+
+// maxFilterDepth 条件嵌套的最大层数
+const maxFilterDepth = 32
+
+func invalidFilter(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFilter, fmt.Sprintf(format, args...))
+}
+
+func buildFilter(f Filter, depth int) (elastic.Query, error) {
+	if depth > maxFilterDepth {
+		return nil, invalidFilter("nested too deep")
+	}
+
+	op := strings.ToLower(f.Op)
+	switch op {
+	case FilterAnd, FilterOr, FilterNot:
+		if len(f.Filters) == 0 {
+			return nil, invalidFilter("%s requires filters", op)
+		}
+		var queries []elastic.Query
+		for _, child := range f.Filters {
+			q, err := buildFilter(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			queries = append(queries, q)
+		}
+		switch op {
+		case FilterAnd:
+			return elastic.NewBoolQuery().Filter(queries...), nil
+		case FilterOr:
+			return elastic.NewBoolQuery().Should(queries...).MinimumNumberShouldMatch(1), nil
+		}
+		return elastic.NewBoolQuery().MustNot(queries...), nil
+	}
+
+	if !filterField.MatchString(f.Field) {
+		return nil, invalidFilter("invalid field %q", f.Field)
+	}
+
+	switch op {
+	case FilterEq, FilterNe:
+		if err := checkScalar(f.Field, f.Value); err != nil {
+			return nil, err
+		}
+		q := elastic.NewTermQuery(f.Field, f.Value)
+		if op == FilterNe {
+			return elastic.NewBoolQuery().MustNot(q), nil
+		}
+		return q, nil
+	case FilterIn, FilterNotIn:
+		if len(f.Values) == 0 {
+			return nil, invalidFilter("%s on %s requires values", op, f.Field)
+		}
+		for _, v := range f.Values {
+			if err := checkScalar(f.Field, v); err != nil {
+				return nil, err
+			}
+		}
+		q := elastic.NewTermsQuery(f.Field, f.Values...)
+		if op == FilterNotIn {
+			return elastic.NewBoolQuery().MustNot(q), nil
+		}
+		return q, nil
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		if f.Value == nil {
+			return nil, invalidFilter("%s on %s requires value", op, f.Field)
+		}
+		if err := checkScalar(f.Field, f.Value); err != nil {
+			return nil, err
+		}
+		q := elastic.NewRangeQuery(f.Field)
+		switch op {
+		case FilterGt:
+			q = q.Gt(f.Value)
+		case FilterGte:
+			q = q.Gte(f.Value)
+		case FilterLt:
+			q = q.Lt(f.Value)
+		default:
+			q = q.Lte(f.Value)
+		}
+		return q, nil
+	case FilterBetween:
+		if len(f.Values) != 2 || (f.Values[0] == nil && f.Values[1] == nil) {
+			return nil, invalidFilter("between on %s requires [from, to]", f.Field)
+		}
+		for _, v := range f.Values {
+			if v == nil {
+				continue
+			}
+			if err := checkScalar(f.Field, v); err != nil {
+				return nil, err
+			}
+		}
+		q := elastic.NewRangeQuery(f.Field)
+		if f.Values[0] != nil {
+			q = q.Gte(f.Values[0])
+		}
+		if f.Values[1] != nil {
+			q = q.Lte(f.Values[1])
+		}
+		return q, nil
+	case FilterPrefix, FilterWildcard, FilterContains:
+		s, ok := f.Value.(string)
+		if !ok || s == "" {
+			return nil, invalidFilter("%s on %s requires a string value", op, f.Field)
+		}
+		switch op {
+		case FilterPrefix:
+			return elastic.NewPrefixQuery(f.Field, s), nil
+		case FilterContains:
+			return elastic.NewWildcardQuery(f.Field, "*"+EscapeWildcard(s)+"*"), nil
+		}
+		// 只保留 * 和 ? 的通配符含义，反斜杠按字面处理
+		return elastic.NewWildcardQuery(f.Field, strings.Replace(s, `\`, `\\`, -1)), nil
+	case FilterExists:
+		q := elastic.NewExistsQuery(f.Field)
+		if b, ok := f.Value.(bool); ok && !b {
+			return elastic.NewBoolQuery().MustNot(q), nil
+		}
+		return q, nil
+	}
+
+	return nil, invalidFilter("unknown op %q", f.Op)
+}
+
+// checkScalar 字段条件的值只能是字符串、数值或布尔值
+func checkScalar(field string, v interface{}) error {
+	switch v.(type) {
+	case string, bool, float64, float32, int, int32, int64, uint, uint32, uint64, json.Number:
+		return nil
+	}
+	return invalidFilter("invalid value %v for %s", v, field)
+}
+
+// ParseFilter 解析表达式形式的过滤条件
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidFilter("unexpected %q", p.tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+type (
+	// filterToken 表达式的词
+	filterToken struct {
+		kind  byte // i:标识符 s:字符串 n:数值 o:运算符和括号
+		text  string
+		value interface{}
+	}
+
+	// filterParser 表达式的递归下降解析
+	filterParser struct {
+		tokens []filterToken
+		pos    int
+	}
+)
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	rs := []rune(expr)
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, filterToken{kind: 'o', text: string(r)})
+			i++
+		case r == '=' || r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(rs) && rs[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, invalidFilter("unexpected '!'")
+			}
+			tokens = append(tokens, filterToken{kind: 'o', text: op})
+			i += len(op)
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, invalidFilter("unterminated string")
+			}
+			tokens = append(tokens, filterToken{kind: 's', text: sb.String(), value: sb.String()})
+			i = j + 1
+		case r == '-' || unicode.IsDigit(r):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			text := string(rs[i:j])
+			n, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, invalidFilter("invalid number %q", text)
+			}
+			tokens = append(tokens, filterToken{kind: 'n', text: text, value: n})
+			i = j
+		case r == '_' || unicode.IsLetter(r):
+			j := i + 1
+			for j < len(rs) && (rs[j] == '_' || rs[j] == '.' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: 'i', text: string(rs[i:j])})
+			i = j
+		default:
+			return nil, invalidFilter("unexpected %q", string(r))
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p *filterParser) peek() *filterToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+// keyword 下一个词是否为指定的关键字（不区分大小写），是则读入
+func (p *filterParser) keyword(word string) bool {
+	t := p.peek()
+	if t != nil && (t.kind == 'i' || t.kind == 'o') && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(text string) error {
+	if !p.keyword(text) {
+		return invalidFilter("expected %q", text)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr(depth int) (*Filter, error) {
+	return p.parseGroup(FilterOr, depth, p.parseAnd)
+}
+
+func (p *filterParser) parseAnd(depth int) (*Filter, error) {
+	return p.parseGroup(FilterAnd, depth, p.parseUnary)
+}
+
+// parseGroup 解析以 and/or 连接的条件，只有一个条件时不生成分组
+func (p *filterParser) parseGroup(op string, depth int, next func(int) (*Filter, error)) (*Filter, error) {
+	first, err := next(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []Filter{*first}
+	for p.keyword(op) {
+		f, err := next(depth)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, *f)
+	}
+
+	if len(filters) == 1 {
+		return first, nil
+	}
+	return &Filter{Op: op, Filters: filters}, nil
+}
+
+func (p *filterParser) parseUnary(depth int) (*Filter, error) {
+	if depth > maxFilterDepth {
+		return nil, invalidFilter("nested too deep")
+	}
+
+	if p.keyword(FilterNot) {
+		f, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &Filter{Op: FilterNot, Filters: []Filter{*f}}, nil
+	}
+
+	if p.keyword("(") {
+		f, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	return p.parseCondition()
+}
+
+// parseCondition 解析 field op value
+func (p *filterParser) parseCondition() (*Filter, error) {
+	t := p.peek()
+	if t == nil || t.kind != 'i' {
+		return nil, invalidFilter("expected field")
+	}
+	p.pos++
+	field := t.text
+
+	op := p.peek()
+	if op == nil {
+		return nil, invalidFilter("expected operator after %s", field)
+	}
+	p.pos++
+
+	switch strings.ToLower(op.text) {
+	case "=", "==":
+		return p.scalar(FilterEq, field)
+	case "!=":
+		return p.scalar(FilterNe, field)
+	case ">":
+		return p.scalar(FilterGt, field)
+	case ">=":
+		return p.scalar(FilterGte, field)
+	case "<":
+		return p.scalar(FilterLt, field)
+	case "<=":
+		return p.scalar(FilterLte, field)
+	case "prefix":
+		return p.scalar(FilterPrefix, field)
+	case "like":
+		return p.scalar(FilterWildcard, field)
+	case "contains":
+		return p.scalar(FilterContains, field)
+	case "exists":
+		return &Filter{Op: FilterExists, Field: field}, nil
+	case "in":
+		return p.list(FilterIn, field)
+	case "not":
+		if err := p.expect("in"); err != nil {
+			return nil, err
+		}
+		return p.list(FilterNotIn, field)
+	}
+
+	return nil, invalidFilter("unknown operator %q", op.text)
+}
+
+func (p *filterParser) value() (interface{}, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, invalidFilter("expected value")
+	}
+	p.pos++
+
+	switch t.kind {
+	case 's', 'n':
+		return t.value, nil
+	case 'i':
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return nil, invalidFilter("unexpected %q, expected value", t.text)
+}
+
+func (p *filterParser) scalar(op, field string) (*Filter, error) {
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{Op: op, Field: field, Value: v}, nil
+}
+
+func (p *filterParser) list(op, field string) (*Filter, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		if p.keyword(")") {
+			break
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Filter{Op: op, Field: field, Values: values}, nil
+}
@@ -0,0 +1,138 @@
+package es
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// querySource 把查询转换为便于比较的 map
+func querySource(t *testing.T, q interface {
+	Source() (interface{}, error)
+}) map[string]interface{} {
+	t.Helper()
+	src, err := q.Source()
+	if err != nil {
+		t.Fatalf("Source() error = %v", err)
+	}
+	b, _ := json.Marshal(src)
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	return m
+}
+
+func TestParseFilterExprMatchesJSON(t *testing.T) {
+	fromExpr, err := ParseFilterExpr(`status = "active" and (amount >= 100 or tags in ("a", 'b')) and not memo exists`)
+	if err != nil {
+		t.Fatalf("ParseFilterExpr() error = %v", err)
+	}
+
+	fromJSON, err := ParseFilterJSON([]byte(`{"op": "and", "filters": [
+		{"op": "eq", "field": "status", "value": "active"},
+		{"op": "or", "filters": [
+			{"op": "gte", "field": "amount", "value": 100},
+			{"op": "in", "field": "tags", "values": ["a", "b"]}
+		]},
+		{"op": "not", "filters": [{"op": "exists", "field": "memo"}]}
+	]}`))
+	if err != nil {
+		t.Fatalf("ParseFilterJSON() error = %v", err)
+	}
+
+	got, want := querySource(t, fromExpr), querySource(t, fromJSON)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expr = %v, json = %v", got, want)
+	}
+}
+
+func TestBuildFilterOperators(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`a != 1`, `{"bool":{"must_not":{"term":{"a":1}}}}`},
+		{`a not in (1, 2)`, `{"bool":{"must_not":{"terms":{"a":[1,2]}}}}`},
+		{`a < 5`, `{"range":{"a":{"from":null,"include_lower":true,"include_upper":false,"to":5}}}`},
+		{`name prefix "ab"`, `{"prefix":{"name":"ab"}}`},
+		{`name like "a*b?"`, `{"wildcard":{"name":{"wildcard":"a*b?"}}}`},
+		{`name contains "1*2?\\"`, `{"wildcard":{"name":{"wildcard":"*1\\*2\\?\\\\*"}}}`},
+		{`deleted = false`, `{"term":{"deleted":false}}`},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseFilterExpr(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilterExpr(%s) error = %v", tt.expr, err)
+			continue
+		}
+		var want map[string]interface{}
+		json.Unmarshal([]byte(tt.want), &want)
+		// bool 以外的单个条件包装在 bool.filter 中
+		if _, ok := want["bool"]; !ok {
+			want = map[string]interface{}{"bool": map[string]interface{}{"filter": want}}
+		}
+		if got := querySource(t, q); !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseFilterExpr(%s) = %v, want %v", tt.expr, got, want)
+		}
+	}
+}
+
+func TestBuildFilterInvalid(t *testing.T) {
+	exprs := []string{
+		``,
+		`a =`,
+		`a = 1 and`,
+		`(a = 1`,
+		`a ~ 1`,
+		`_source = 1`,
+		`a in ()`,
+		`a = "x`,
+	}
+	for _, expr := range exprs {
+		if _, err := ParseFilterExpr(expr); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("ParseFilterExpr(%q) error = %v, want ErrInvalidFilter", expr, err)
+		}
+	}
+
+	filters := []Filter{
+		{Op: "and"},
+		{Op: "eq", Field: "a", Value: map[string]interface{}{"script": "x"}},
+		{Op: "between", Field: "a", Values: []interface{}{nil, nil}},
+		{Op: "between", Field: "a", Values: []interface{}{map[string]interface{}{"script": "x"}, 1}},
+		{Op: "between", Field: "a", Values: []interface{}{nil, []interface{}{1}}},
+		{Op: "prefix", Field: "a", Value: 1},
+		{Op: "regexp", Field: "a", Value: ".*"},
+	}
+	for _, f := range filters {
+		if _, err := BuildFilter(f); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("BuildFilter(%+v) error = %v, want ErrInvalidFilter", f, err)
+		}
+	}
+}
+
+func TestFilterSearch(t *testing.T) {
+	seed(t, "lease", map[string]map[string]interface{}{
+		"1": {"status": "active", "amount": 50, "tags": []string{"a"}},
+		"2": {"status": "active", "amount": 150, "memo": "x"},
+		"3": {"status": "closed", "amount": 500, "tags": []string{"b"}},
+		"4": {"status": "active", "amount": 10},
+	})
+
+	q, err := ParseFilterExpr(`status = "active" and (amount >= 100 or tags in ("a", "b")) and not memo exists`)
+	if err != nil {
+		t.Fatalf("ParseFilterExpr() error = %v", err)
+	}
+
+	result, err := ESSearch("lease", q, "amount", 0, 10)
+	if err != nil {
+		t.Fatalf("ESSearch() error = %v", err)
+	}
+	var ids []string
+	for _, hit := range result.Hits.Hits {
+		ids = append(ids, hit.Id)
+	}
+	if !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Errorf("ESSearch() ids = %v, want [1]", ids)
+	}
+}
@@ -0,0 +1,199 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+type (
+	// RolloverPolicy 常用的滚动策略：hot 阶段按条件滚动，可选 warm 阶段和删除阶段
+	RolloverPolicy struct {
+		MaxAge      string // 索引的最大存活时间，例如 "30d"
+		MaxSize     string // 主分片合计的最大大小，例如 "50gb"
+		MaxDocs     int64  // 最大文档数
+		WarmAfter   string // 滚动后多久进入 warm 阶段（合并段并设为只读），为空时不设置
+		DeleteAfter string // 滚动后多久删除，为空时不删除
+	}
+)
+
+// ErrNoRolloverCondition RolloverPolicy 没有设置任何滚动条件，ES 不接受空的 rollover
+var ErrNoRolloverCondition = errors.New("rollover policy needs MaxAge, MaxSize or MaxDocs")
+
+// Body 生成 ILM 策略的内容，MaxAge、MaxSize、MaxDocs 都没有设置时返回 ErrNoRolloverCondition
+func (p RolloverPolicy) Body() (map[string]interface{}, error) {
+	rollover := make(map[string]interface{})
+	if p.MaxAge != "" {
+		rollover["max_age"] = p.MaxAge
+	}
+	if p.MaxSize != "" {
+		rollover["max_size"] = p.MaxSize
+	}
+	if p.MaxDocs > 0 {
+		rollover["max_docs"] = p.MaxDocs
+	}
+	if len(rollover) == 0 {
+		return nil, ErrNoRolloverCondition
+	}
+
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{
+			"actions": map[string]interface{}{
+				"rollover": rollover,
+			},
+		},
+	}
+	if p.WarmAfter != "" {
+		phases["warm"] = map[string]interface{}{
+			"min_age": p.WarmAfter,
+			"actions": map[string]interface{}{
+				"forcemerge": map[string]interface{}{"max_num_segments": 1},
+				"readonly":   map[string]interface{}{},
+			},
+		}
+	}
+	if p.DeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": p.DeleteAfter,
+			"actions": map[string]interface{}{
+				"delete": map[string]interface{}{},
+			},
+		}
+	}
+
+	return map[string]interface{}{"phases": phases}, nil
+}
+
+// LifecycleSettings 生成应用 ILM 策略所需的索引设置，可放入 IndexTemplate 的 Settings
+func LifecycleSettings(policy, rolloverAlias string) map[string]interface{} {
+	return map[string]interface{}{
+		"index.lifecycle.name":           policy,
+		"index.lifecycle.rollover_alias": rolloverAlias,
+	}
+}
+
+// PutLifecyclePolicy 创建或更新 ILM 策略，policy 为策略内容（phases 部分）
+func (c *Client) PutLifecyclePolicy(name string, policy map[string]interface{}) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = client.XPackIlmPutLifecycle().
+		Policy(name).
+		BodyJson(map[string]interface{}{"policy": policy}).
+		Do(ctx)
+	if err != nil {
+		log.Errorf("ES put lifecycle policy %s error: %v", name, err)
+		return err
+	}
+
+	log.Infof("ES put lifecycle policy ok: %v", name)
+	return nil
+}
+
+// GetLifecyclePolicy 获取 ILM 策略的内容，不存在时返回 nil
+func (c *Client) GetLifecyclePolicy(name string) (map[string]interface{}, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	res, err := client.XPackIlmGetLifecycle().
+		Policy(name).
+		Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, nil
+		}
+		log.Errorf("ES get lifecycle policy %s error: %v", name, err)
+		return nil, err
+	}
+
+	p, ok := res[name]
+	if !ok {
+		return nil, nil
+	}
+	return p.Policy, nil
+}
+
+// DeleteLifecyclePolicy 删除 ILM 策略
+func (c *Client) DeleteLifecyclePolicy(name string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = client.XPackIlmDeleteLifecycle().
+		Policy(name).
+		Do(ctx)
+	if err != nil {
+		log.Errorf("ES delete lifecycle policy %s error: %v", name, err)
+		return err
+	}
+
+	log.Infof("ES delete lifecycle policy ok: %v", name)
+	return nil
+}
+
+// BootstrapRolloverIndex 为滚动别名创建第一个索引 <alias>-000001，并设为写入索引
+// 别名已存在时不做任何处理，索引的设置和 mapping 由匹配的索引模板提供
+func (c *Client) BootstrapRolloverIndex(alias string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	exists, err := client.IndexExists(alias).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-000001", alias)
+	result, err := client.CreateIndex(name).
+		BodyJson(map[string]interface{}{
+			"aliases": map[string]interface{}{
+				alias: map[string]interface{}{"is_write_index": true},
+			},
+		}).
+		Do(ctx)
+	if err != nil {
+		log.Errorf("ES create index error: %v", err)
+		return err
+	}
+
+	log.Infof("ES create index ok: %v", result.Index)
+	return nil
+}
+
+// RolloverIndex 手动滚动别名，conditions 为空时无条件滚动，返回是否滚动和新索引名
+func (c *Client) RolloverIndex(alias string, conditions map[string]interface{}) (bool, string, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return false, "", err
+	}
+
+	ctx := context.Background()
+	svc := client.RolloverIndex(alias)
+	if len(conditions) > 0 {
+		svc = svc.Conditions(conditions)
+	}
+
+	res, err := svc.Do(ctx)
+	if err != nil {
+		log.Errorf("ES rollover %s error: %v", alias, err)
+		return false, "", err
+	}
+
+	log.Infof("ES rollover %s: %v -> %v rolled(%v)", alias, res.OldIndex, res.NewIndex, res.RolledOver)
+	return res.RolledOver, res.NewIndex, nil
+}
@@ -0,0 +1,66 @@
+package es
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRolloverPolicyBody(t *testing.T) {
+	if _, err := (RolloverPolicy{DeleteAfter: "90d"}).Body(); err != ErrNoRolloverCondition {
+		t.Errorf("Body() error = %v, want %v", err, ErrNoRolloverCondition)
+	}
+
+	body, err := RolloverPolicy{MaxAge: "30d", MaxDocs: 1000, DeleteAfter: "90d"}.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"phases": map[string]interface{}{
+			"hot": map[string]interface{}{
+				"actions": map[string]interface{}{
+					"rollover": map[string]interface{}{"max_age": "30d", "max_docs": int64(1000)},
+				},
+			},
+			"delete": map[string]interface{}{
+				"min_age": "90d",
+				"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("Body() = %v, want %v", body, want)
+	}
+
+	body, _ = RolloverPolicy{MaxSize: "50gb", WarmAfter: "7d"}.Body()
+	if _, ok := body["phases"].(map[string]interface{})["warm"]; !ok {
+		t.Errorf("Body() without warm phase: %v", body)
+	}
+}
+
+func TestLifecyclePolicy(t *testing.T) {
+	seed(t, "logs", nil)
+
+	body, err := RolloverPolicy{MaxAge: "1d"}.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PutLifecyclePolicy("logs", body); err != nil {
+		t.Fatalf("PutLifecyclePolicy() error = %v", err)
+	}
+
+	got, err := GetLifecyclePolicy("logs")
+	if err != nil {
+		t.Fatalf("GetLifecyclePolicy() error = %v", err)
+	}
+	hot := got["phases"].(map[string]interface{})["hot"].(map[string]interface{})
+	if rollover := hot["actions"].(map[string]interface{})["rollover"]; !reflect.DeepEqual(rollover, map[string]interface{}{"max_age": "1d"}) {
+		t.Errorf("GetLifecyclePolicy() rollover = %v", rollover)
+	}
+
+	if err := DeleteLifecyclePolicy("logs"); err != nil {
+		t.Fatalf("DeleteLifecyclePolicy() error = %v", err)
+	}
+	if got, err := GetLifecyclePolicy("logs"); err != nil || got != nil {
+		t.Errorf("GetLifecyclePolicy() after delete = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,231 @@
+package es
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 通过结构体的 tag 生成 ES 的 mapping
+//
+// 字段名取 json tag，es tag 的第一项为字段类型，其余为 key=value 形式的参数：
+//
+//	type Lease struct {
+//	    ID        string    `json:"id" es:"keyword"`
+//	    Name      string    `json:"name" es:"text,analyzer=kuromoji"`
+//	    Amount    float64   `json:"amount"`
+//	    StartDate time.Time `json:"start_date" es:"date,format=yyyy-MM-dd"`
+//	    Memo      string    `json:"memo" es:"text,index=false"`
+//	    Payments  []Payment `json:"payments" es:"nested"`
+//	    Internal  string    `json:"-"`
+//	}
+//
+// 未指定类型时按 Go 的类型推断，string 默认为 keyword。
+// es:"ja_text" 生成日文的多字段 mapping，参照 JapaneseTextField。
+// es:"-" 的字段不会出现在 mapping 中。
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ErrRecursiveType 结构体直接或间接包含自身，无法生成 mapping
+var ErrRecursiveType = errors.New("es mapping: recursive type")
+
+// BuildMapping 生成可直接传给 CreateESIndexByJson 的 mapping
+func BuildMapping(v interface{}) (map[string]interface{}, error) {
+	props, err := BuildProperties(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"properties": props,
+		},
+	}, nil
+}
+
+// BuildProperties 生成结构体对应的 properties
+func BuildProperties(v interface{}) (map[string]interface{}, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("es mapping: %v is not a struct", t)
+	}
+
+	return structProperties(t, make(map[reflect.Type]bool))
+}
+
+// structProperties 解析结构体的所有导出字段，path 为正在展开的结构体，用于检查循环引用
+func structProperties(t reflect.Type, path map[reflect.Type]bool) (map[string]interface{}, error) {
+	if path[t] {
+		return nil, fmt.Errorf("%w: %v", ErrRecursiveType, t)
+	}
+	path[t] = true
+	defer delete(path, t)
+
+	props := make(map[string]interface{})
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		name, skip := fieldName(f)
+		if skip {
+			continue
+		}
+
+		tag := f.Tag.Get("es")
+		if tag == "-" {
+			continue
+		}
+
+		// 匿名结构体字段展开到上一层
+		if f.Anonymous && name == "" {
+			ft := indirect(f.Type)
+			if ft.Kind() == reflect.Struct {
+				sub, err := structProperties(ft, path)
+				if err != nil {
+					return nil, err
+				}
+				for k, v := range sub {
+					props[k] = v
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop, err := fieldProperty(f.Type, tag, path)
+		if err != nil {
+			return nil, fmt.Errorf("es mapping: field %s: %w", f.Name, err)
+		}
+		props[name] = prop
+	}
+
+	return props, nil
+}
+
+// fieldName 从 json tag 中取得字段名
+func fieldName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+
+	return strings.Split(tag, ",")[0], false
+}
+
+// fieldProperty 生成单个字段的定义
+func fieldProperty(t reflect.Type, tag string, path map[reflect.Type]bool) (map[string]interface{}, error) {
+	prop := make(map[string]interface{})
+
+	var typ string
+	if tag != "" {
+		parts := strings.Split(tag, ",")
+		typ = strings.TrimSpace(parts[0])
+		for _, p := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid es tag option %q", p)
+			}
+			prop[kv[0]] = tagValue(kv[1])
+		}
+	}
+
+	et := indirect(t)
+	if et.Kind() == reflect.Slice || et.Kind() == reflect.Array {
+		if et.Elem().Kind() != reflect.Uint8 {
+			et = indirect(et.Elem())
+		}
+	}
+
+	if typ == "" {
+		typ = inferType(et)
+	}
+
+	// 日文多字段，tag 中的参数覆盖预设
+	if typ == TypeJapaneseText {
+		field := JapaneseTextField()
+		for k, v := range prop {
+			field[k] = v
+		}
+		return field, nil
+	}
+	prop["type"] = typ
+
+	// object 和 nested 需要展开子字段
+	if (typ == "object" || typ == "nested") && et.Kind() == reflect.Struct && et != timeType {
+		sub, err := structProperties(et, path)
+		if err != nil {
+			return nil, err
+		}
+		prop["properties"] = sub
+	}
+
+	return prop, nil
+}
+
+// inferType 根据 Go 的类型推断 ES 的类型
+func inferType(t reflect.Type) string {
+	if t == timeType {
+		return "date"
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "keyword"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int8:
+		return "byte"
+	case reflect.Int16, reflect.Uint8:
+		return "short"
+	case reflect.Int32, reflect.Uint16:
+		return "integer"
+	case reflect.Int, reflect.Int64, reflect.Uint32:
+		return "long"
+	case reflect.Uint, reflect.Uint64:
+		return "unsigned_long"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	case reflect.Slice:
+		// []byte
+		return "binary"
+	default:
+		return "object"
+	}
+}
+
+// tagValue 把 tag 中的值转为 bool 或数字，其余保持字符串
+func tagValue(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return s
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,128 @@
+package es
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type mappingPayment struct {
+	No     int     `json:"no"`
+	Amount float64 `json:"amount"`
+}
+
+type mappingBase struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type mappingLease struct {
+	mappingBase
+	ID       string           `json:"id"`
+	Name     string           `json:"name" es:"text,analyzer=kuromoji"`
+	Tags     []string         `json:"tags"`
+	Memo     string           `json:"memo" es:"text,index=false"`
+	Address  string           `json:"address" es:"ja_text"`
+	Limit    string           `json:"limit" es:"keyword,ignore_above=256"`
+	Payments []mappingPayment `json:"payments" es:"nested"`
+	Owner    *mappingPayment  `json:"owner"`
+	Skip     string           `json:"skip" es:"-"`
+	Ignored  string           `json:"-"`
+	private  string
+}
+
+func TestBuildProperties(t *testing.T) {
+	got, err := BuildProperties(&mappingLease{})
+	if err != nil {
+		t.Fatalf("BuildProperties() error = %v", err)
+	}
+
+	paymentProps := map[string]interface{}{
+		"no":     map[string]interface{}{"type": "long"},
+		"amount": map[string]interface{}{"type": "double"},
+	}
+	want := map[string]interface{}{
+		"created_at": map[string]interface{}{"type": "date"},
+		"id":         map[string]interface{}{"type": "keyword"},
+		"name":       map[string]interface{}{"type": "text", "analyzer": "kuromoji"},
+		"tags":       map[string]interface{}{"type": "keyword"},
+		"memo":       map[string]interface{}{"type": "text", "index": false},
+		"address":    JapaneseTextField(),
+		"limit":      map[string]interface{}{"type": "keyword", "ignore_above": 256},
+		"payments":   map[string]interface{}{"type": "nested", "properties": paymentProps},
+		"owner":      map[string]interface{}{"type": "object", "properties": paymentProps},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildProperties() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildPropertiesNotStruct(t *testing.T) {
+	if _, err := BuildProperties("lease"); err == nil {
+		t.Errorf("BuildProperties() expected error for non struct")
+	}
+}
+
+func TestBuildPropertiesRecursive(t *testing.T) {
+	type Node struct {
+		Name   string `json:"name"`
+		Parent *Node  `json:"parent"`
+	}
+	if _, err := BuildProperties(Node{}); !errors.Is(err, ErrRecursiveType) {
+		t.Errorf("BuildProperties() error = %v, want %v", err, ErrRecursiveType)
+	}
+
+	type Children struct {
+		Items []Node `json:"items" es:"nested"`
+	}
+	if _, err := BuildProperties(Children{}); !errors.Is(err, ErrRecursiveType) {
+		t.Errorf("BuildProperties() nested error = %v, want %v", err, ErrRecursiveType)
+	}
+
+	// 同一类型出现在不同字段中不是循环
+	type Period struct {
+		From time.Time `json:"from"`
+	}
+	type Lease struct {
+		Contract Period `json:"contract"`
+		Payment  Period `json:"payment"`
+	}
+	if _, err := BuildProperties(Lease{}); err != nil {
+		t.Errorf("BuildProperties() error = %v", err)
+	}
+}
+
+func TestWithJapaneseAnalysis(t *testing.T) {
+	body := map[string]interface{}{
+		"settings": map[string]interface{}{
+			"analysis": map[string]interface{}{
+				"analyzer": map[string]interface{}{
+					"custom": map[string]interface{}{"type": "standard"},
+				},
+			},
+		},
+	}
+
+	analysis := WithJapaneseAnalysis(body)["settings"].(map[string]interface{})["analysis"].(map[string]interface{})
+	analyzers := analysis["analyzer"].(map[string]interface{})
+	for _, name := range []string{"custom", AnalyzerKuromoji, AnalyzerNgram} {
+		if _, ok := analyzers[name]; !ok {
+			t.Errorf("analyzer %s not found", name)
+		}
+	}
+	if _, ok := analysis["normalizer"].(map[string]interface{})[NormalizerJapanese]; !ok {
+		t.Errorf("normalizer %s not found", NormalizerJapanese)
+	}
+}
+
+func TestKanaFoldMappings(t *testing.T) {
+	mappings := kanaFoldMappings()
+	want := map[string]bool{"あ=>ア": true, "ん=>ン": true, "ゔ=>ヴ": true}
+	for _, m := range mappings {
+		delete(want, m)
+	}
+	if len(want) != 0 {
+		t.Errorf("kanaFoldMappings() missing %v", want)
+	}
+}
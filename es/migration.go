@@ -0,0 +1,419 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+type (
+	// MigrateOptions 索引迁移的配置
+	MigrateOptions struct {
+		Routing           string        // 复制时使用的路由，为空时保持文档原有的路由
+		BatchSize         int           // 每批复制的文档数
+		RequestsPerSecond int           // 复制的限流，0 表示不限流
+		PollInterval      time.Duration // 轮询复制进度的间隔，0 时使用默认值
+		Retention         time.Duration // 切换后旧索引的保留时间，0 表示立即删除
+		// UpdatedField 文档的更新时间字段（date 类型）
+		// 设置后先在不禁止写入的情况下复制全部数据，再禁止写入，只复制复制期间更新的文档；
+		// 为空时整个复制期间旧索引都禁止写入
+		UpdatedField string
+		// OnProgress 每次轮询到复制进度时的回调，可为空
+		OnProgress func(*TaskStatus)
+	}
+
+	// Migration 一次索引迁移的记录，用于回滚
+	Migration struct {
+		Alias       string    // 别名
+		OldIndex    string    // 旧索引
+		NewIndex    string    // 新索引
+		TaskID      string    // 复制任务的ID
+		Created     bool      // 新索引是否由本次迁移创建
+		Swapped     bool      // 别名是否已切换到新索引
+		DeleteAfter time.Time // 旧索引的删除期限，为零值时旧索引已删除
+	}
+)
+
+const (
+	metaMigratedTo  = "migrated_to"
+	metaDeleteAfter = "delete_after"
+)
+
+var (
+	// ErrCountMismatch 复制后新旧索引的文档数不一致
+	ErrCountMismatch = errors.New("document count mismatch after reindex")
+	// ErrRollbackUnavailable 旧索引已删除，无法回滚
+	ErrRollbackUnavailable = errors.New("old index has been deleted, rollback is unavailable")
+	// ErrInvalidMigration 迁移的参数不正确
+	ErrInvalidMigration = errors.New("invalid migration")
+	// ErrRollbackAfterSwap 别名已切换到新索引，回滚会丢失切换后写入新索引的数据
+	ErrRollbackAfterSwap = errors.New("alias has been swapped to the new index, rollback would lose writes since the swap")
+)
+
+// DefaultMigrateOptions 默认的迁移配置
+func DefaultMigrateOptions() MigrateOptions {
+	return MigrateOptions{
+		BatchSize:    5000,
+		PollInterval: 5 * time.Second,
+		Retention:    24 * time.Hour,
+	}
+}
+
+// catchUpMargin 追加复制时更新时间的余量，吸收写入方与本机的时钟偏差
+const catchUpMargin = time.Minute
+
+// MigrateIndex 把别名从旧索引迁移到新索引
+// 1. 确保别名指向旧索引
+// 2. 创建新索引
+// 3. 禁止旧索引写入，异步复制数据并轮询进度
+// 4. 校验新旧索引的文档数
+// 5. 原子地切换别名
+// 6. 按保留时间标记或删除旧索引
+// 第 3 步到切换别名为止旧索引不能写入，写入方会收到 cluster_block_exception。
+// 设置 UpdatedField 时先不禁止写入复制全部数据，禁止写入后只追加复制期间更新的文档，
+// 复制期间被删除的文档会使第 4 步的校验失败，此时需要重新迁移。
+// 切换前失败时会恢复旧索引的写入，新索引保留以便排查，可通过 RollbackMigration 清理
+func (c *Client) MigrateIndex(oldIndexName, newIndexName, alias string, mapping interface{}, opts MigrateOptions) (*Migration, error) {
+	if err := opts.normalize(oldIndexName, newIndexName, alias); err != nil {
+		return nil, err
+	}
+
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	m := &Migration{
+		Alias:    alias,
+		OldIndex: oldIndexName,
+		NewIndex: newIndexName,
+	}
+
+	// 1. 创建别名
+	_, err = client.Alias().
+		Action(elastic.NewAliasAddAction(alias).Index(oldIndexName)).
+		Do(ctx)
+	if err != nil {
+		return m, err
+	}
+
+	// 2. 创建新索引
+	exists, err := client.IndexExists(newIndexName).Do(ctx)
+	if err != nil {
+		return m, err
+	}
+
+	if !exists {
+		result, err := client.CreateIndex(newIndexName).BodyJson(mapping).Do(ctx)
+		if err != nil {
+			log.Errorf("ES create index error: %v", err)
+			return m, err
+		}
+
+		m.Created = true
+		log.Infof("ES create index ok: %v", result.Index)
+	}
+
+	// 3. 复制数据
+	var catchUp elastic.Query
+	if opts.UpdatedField != "" {
+		since := time.Now().UTC().Add(-catchUpMargin)
+		if err := reindex(ctx, client, m, opts, nil); err != nil {
+			return m, err
+		}
+		catchUp = elastic.NewRangeQuery(opts.UpdatedField).Gte(since.Format(time.RFC3339))
+	}
+
+	if err := setWriteBlock(ctx, client, oldIndexName, true); err != nil {
+		return m, err
+	}
+
+	if err := reindexAndVerify(ctx, client, m, opts, catchUp); err != nil {
+		if e := setWriteBlock(ctx, client, oldIndexName, false); e != nil {
+			log.Errorf("ES unblock index %s error: %v", oldIndexName, e)
+		}
+		return m, err
+	}
+
+	// 5. 修改别名
+	_, err = client.Alias().
+		Action(elastic.NewAliasAddAction(alias).Index(newIndexName), elastic.NewAliasRemoveAction(alias).Index(oldIndexName)).
+		Do(ctx)
+	if err != nil {
+		if e := setWriteBlock(ctx, client, oldIndexName, false); e != nil {
+			log.Errorf("ES unblock index %s error: %v", oldIndexName, e)
+		}
+		return m, err
+	}
+	m.Swapped = true
+
+	// 6. 处理旧索引
+	if opts.Retention <= 0 {
+		if _, err := client.DeleteIndex(oldIndexName).Do(ctx); err != nil {
+			return m, err
+		}
+		log.Infof("ES migrate index %s -> %s ok, old index deleted", oldIndexName, newIndexName)
+		return m, nil
+	}
+
+	m.DeleteAfter = time.Now().Add(opts.Retention)
+	if err := putMigrationMeta(ctx, client, oldIndexName, map[string]interface{}{
+		metaMigratedTo:  newIndexName,
+		metaDeleteAfter: m.DeleteAfter.Format(time.RFC3339),
+	}); err != nil {
+		return m, err
+	}
+
+	log.Infof("ES migrate index %s -> %s ok, old index kept until %v", oldIndexName, newIndexName, m.DeleteAfter)
+	return m, nil
+}
+
+// normalize 校验参数并补充默认值，在修改任何索引之前调用
+func (o *MigrateOptions) normalize(oldIndexName, newIndexName, alias string) error {
+	if oldIndexName == "" || newIndexName == "" || alias == "" {
+		return fmt.Errorf("%w: index and alias names are required", ErrInvalidMigration)
+	}
+	if oldIndexName == newIndexName {
+		return fmt.Errorf("%w: old and new index are both %s", ErrInvalidMigration, oldIndexName)
+	}
+	if o.BatchSize < 0 || o.RequestsPerSecond < 0 || o.Retention < 0 {
+		return fmt.Errorf("%w: negative BatchSize, RequestsPerSecond or Retention", ErrInvalidMigration)
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = DefaultMigrateOptions().PollInterval
+	}
+	return nil
+}
+
+// reindexAndVerify 复制数据，结束后校验文档数，query 为空时复制全部文档
+func reindexAndVerify(ctx context.Context, client *elastic.Client, m *Migration, opts MigrateOptions, query elastic.Query) error {
+	if err := reindex(ctx, client, m, opts, query); err != nil {
+		return err
+	}
+
+	// 4. 校验文档数
+	if _, err := client.Refresh(m.NewIndex).Do(ctx); err != nil {
+		return err
+	}
+
+	oldCount, err := client.Count(m.OldIndex).Do(ctx)
+	if err != nil {
+		return err
+	}
+	newCount, err := client.Count(m.NewIndex).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if oldCount != newCount {
+		return fmt.Errorf("%w: %s(%d) %s(%d)", ErrCountMismatch, m.OldIndex, oldCount, m.NewIndex, newCount)
+	}
+
+	return nil
+}
+
+// reindex 异步复制数据并轮询进度，query 为空时复制全部文档
+func reindex(ctx context.Context, client *elastic.Client, m *Migration, opts MigrateOptions, query elastic.Query) error {
+	dst := elastic.NewReindexDestination().Index(m.NewIndex)
+	if opts.Routing != "" {
+		dst = dst.Routing(opts.Routing)
+	}
+
+	src := elastic.NewReindexSource().Index(m.OldIndex)
+	if opts.BatchSize > 0 {
+		src = src.Request(elastic.NewSearchRequest().Size(opts.BatchSize)).Index(m.OldIndex)
+	}
+	if query != nil {
+		src = src.Query(query)
+	}
+
+	reindex := client.Reindex().
+		Source(src).
+		Destination(dst).
+		WaitForCompletion(false)
+	if opts.RequestsPerSecond > 0 {
+		reindex = reindex.RequestsPerSecond(opts.RequestsPerSecond)
+	}
+
+	task, err := reindex.DoAsync(ctx)
+	if err != nil {
+		log.Errorf("ES start reindex error: %v", err)
+		return err
+	}
+	m.TaskID = task.TaskId
+
+	status, err := waitForTask(ctx, client, task.TaskId, opts.PollInterval, opts.OnProgress)
+	if err != nil {
+		return err
+	}
+	if status.Failed() {
+		return fmt.Errorf("es reindex %s -> %s has %d failures", m.OldIndex, m.NewIndex, len(status.Failures))
+	}
+
+	return nil
+}
+
+// RollbackMigration 回滚迁移：别名切回旧索引，恢复旧索引的写入，删除本次创建的新索引
+// 别名已切换时，切换后通过别名写入新索引的数据不会复制回旧索引，
+// force 为 false 时返回 ErrRollbackAfterSwap 且不做任何修改，确认可以丢弃这些数据时 force 传 true
+func (c *Client) RollbackMigration(m *Migration, force bool) error {
+	if m.Swapped && !force {
+		return ErrRollbackAfterSwap
+	}
+
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	exists, err := client.IndexExists(m.OldIndex).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRollbackUnavailable
+	}
+
+	if m.Swapped {
+		log.Warnf("ES rollback migration %s -> %s after alias swap, writes to %s since the swap are discarded", m.OldIndex, m.NewIndex, m.NewIndex)
+		_, err := client.Alias().
+			Action(elastic.NewAliasAddAction(m.Alias).Index(m.OldIndex), elastic.NewAliasRemoveAction(m.Alias).Index(m.NewIndex)).
+			Do(ctx)
+		if err != nil {
+			return err
+		}
+		m.Swapped = false
+	}
+
+	if err := setWriteBlock(ctx, client, m.OldIndex, false); err != nil {
+		return err
+	}
+	if err := putMigrationMeta(ctx, client, m.OldIndex, map[string]interface{}{
+		metaMigratedTo:  nil,
+		metaDeleteAfter: nil,
+	}); err != nil {
+		return err
+	}
+	m.DeleteAfter = time.Time{}
+
+	if m.Created {
+		if _, err := client.DeleteIndex(m.NewIndex).Do(ctx); err != nil && !elastic.IsNotFound(err) {
+			return err
+		}
+		m.Created = false
+	}
+
+	log.Infof("ES rollback migration %s -> %s ok", m.OldIndex, m.NewIndex)
+	return nil
+}
+
+// PurgeExpiredIndices 删除已超过保留时间的旧索引，返回删除的索引名
+func (c *Client) PurgeExpiredIndices() ([]string, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_all/_mapping",
+		Params: map[string][]string{"filter_path": {"*.mappings._meta"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var indices map[string]struct {
+		Mappings struct {
+			Meta map[string]interface{} `json:"_meta"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal(res.Body, &indices); err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	now := time.Now()
+	for name, idx := range indices {
+		v, ok := idx.Mappings.Meta[metaDeleteAfter].(string)
+		if !ok {
+			continue
+		}
+		deadline, err := time.Parse(time.RFC3339, v)
+		if err != nil || deadline.After(now) {
+			continue
+		}
+
+		if _, err := client.DeleteIndex(name).Do(ctx); err != nil {
+			log.Errorf("ES delete expired index %s error: %v", name, err)
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+		log.Infof("ES delete expired index ok: %v", name)
+	}
+
+	return deleted, nil
+}
+
+// setWriteBlock 禁止或恢复索引的写入
+func setWriteBlock(ctx context.Context, client *elastic.Client, indexName string, block bool) error {
+	_, err := client.IndexPutSettings(indexName).
+		BodyJson(map[string]interface{}{"index.blocks.write": block}).
+		Do(ctx)
+	if err != nil {
+		log.Errorf("ES set write block on %s error: %v", indexName, err)
+	}
+	return err
+}
+
+// putMigrationMeta 在索引的 _meta 中记录迁移信息
+// PUT _mapping 会整体替换 _meta，因此先取得现有的 _meta 再合并，值为 nil 的项从 _meta 中删除
+func putMigrationMeta(ctx context.Context, client *elastic.Client, indexName string, meta map[string]interface{}) error {
+	merged, err := getMappingMeta(ctx, client, indexName)
+	if err != nil {
+		log.Errorf("ES get mapping meta on %s error: %v", indexName, err)
+		return err
+	}
+	for k, v := range meta {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+
+	_, err = client.PutMapping().
+		Index(indexName).
+		BodyJson(map[string]interface{}{"_meta": merged}).
+		Do(ctx)
+	if err != nil {
+		log.Errorf("ES put migration meta on %s error: %v", indexName, err)
+	}
+	return err
+}
+
+// getMappingMeta 取得索引 mapping 中的 _meta，没有时返回空 map
+func getMappingMeta(ctx context.Context, client *elastic.Client, indexName string) (map[string]interface{}, error) {
+	res, err := client.GetMapping().Index(indexName).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]interface{})
+	for _, v := range res {
+		idx, _ := v.(map[string]interface{})
+		mappings, _ := idx["mappings"].(map[string]interface{})
+		current, _ := mappings["_meta"].(map[string]interface{})
+		for k, v := range current {
+			meta[k] = v
+		}
+	}
+	return meta, nil
+}
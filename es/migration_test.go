@@ -0,0 +1,199 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var migrationMapping = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"name":       map[string]interface{}{"type": "keyword"},
+			"updated_at": map[string]interface{}{"type": "date"},
+		},
+	},
+}
+
+func seedMigration(t *testing.T) {
+	t.Helper()
+	now := time.Now().UTC().Format(time.RFC3339)
+	seed(t, "lease_v1", map[string]map[string]interface{}{
+		"1": {"name": "a", "updated_at": now},
+		"2": {"name": "b", "updated_at": now},
+	})
+}
+
+func TestMigrateIndex(t *testing.T) {
+	for _, field := range []string{"", "updated_at"} {
+		seedMigration(t)
+
+		// PollInterval 为零时使用默认值
+		m, err := MigrateIndex("lease_v1", "lease_v2", "lease", migrationMapping, MigrateOptions{Retention: time.Hour, UpdatedField: field})
+		if err != nil {
+			t.Fatalf("MigrateIndex(%q) error = %v", field, err)
+		}
+		if !m.Created || !m.Swapped || m.TaskID == "" || m.DeleteAfter.IsZero() {
+			t.Errorf("MigrateIndex(%q) = %+v", field, m)
+		}
+		if got := srv.Source("lease_v2", "2"); got == nil || got["name"] != "b" {
+			t.Errorf("MigrateIndex(%q) doc 2 = %v", field, got)
+		}
+		if names, _ := GetESIndexName("lease"); !reflect.DeepEqual(names, []string{"lease_v2"}) {
+			t.Errorf("MigrateIndex(%q) alias = %v", field, names)
+		}
+	}
+}
+
+func TestMigrateIndexInvalidOptions(t *testing.T) {
+	seedMigration(t)
+
+	for _, opts := range []MigrateOptions{{BatchSize: -1}, {Retention: -time.Hour}} {
+		if _, err := MigrateIndex("lease_v1", "lease_v2", "lease", migrationMapping, opts); !errors.Is(err, ErrInvalidMigration) {
+			t.Errorf("MigrateIndex(%+v) error = %v, want %v", opts, err, ErrInvalidMigration)
+		}
+	}
+	if _, err := MigrateIndex("lease_v1", "lease_v1", "lease", migrationMapping, MigrateOptions{}); !errors.Is(err, ErrInvalidMigration) {
+		t.Errorf("MigrateIndex() same index error = %v, want %v", err, ErrInvalidMigration)
+	}
+
+	// 参数错误时不修改任何索引
+	if names, _ := GetESIndexName("lease"); len(names) != 0 {
+		t.Errorf("alias created on invalid options: %v", names)
+	}
+	if err := ESInsert("lease_v1", "3", map[string]interface{}{"name": "c"}); err != nil {
+		t.Errorf("old index should stay writable: %v", err)
+	}
+}
+
+func TestRollbackMigration(t *testing.T) {
+	seedMigration(t)
+
+	m, err := MigrateIndex("lease_v1", "lease_v2", "lease", migrationMapping, MigrateOptions{Retention: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 别名切换后不强制时拒绝回滚，且不修改任何索引
+	if err := RollbackMigration(m, false); err != ErrRollbackAfterSwap {
+		t.Fatalf("RollbackMigration() error = %v, want %v", err, ErrRollbackAfterSwap)
+	}
+	if names, _ := GetESIndexName("lease"); !reflect.DeepEqual(names, []string{"lease_v2"}) || !ExistsESIndex("lease_v2") {
+		t.Errorf("refused rollback changed alias = %v", names)
+	}
+
+	if err := RollbackMigration(m, true); err != nil {
+		t.Fatalf("RollbackMigration() error = %v", err)
+	}
+	if m.Swapped || m.Created || !m.DeleteAfter.IsZero() {
+		t.Errorf("RollbackMigration() migration = %+v", m)
+	}
+	if names, _ := GetESIndexName("lease"); !reflect.DeepEqual(names, []string{"lease_v1"}) {
+		t.Errorf("alias after rollback = %v", names)
+	}
+	if ExistsESIndex("lease_v2") {
+		t.Error("new index should be deleted")
+	}
+	if err := ESInsert("lease_v1", "3", map[string]interface{}{"name": "c"}); err != nil {
+		t.Errorf("old index should be writable after rollback: %v", err)
+	}
+	// 旧索引的删除期限已清除
+	if deleted, err := PurgeExpiredIndices(); err != nil || len(deleted) != 0 {
+		t.Errorf("PurgeExpiredIndices() = %v, %v", deleted, err)
+	}
+
+	// 旧索引已删除时不能回滚
+	m, err = MigrateIndex("lease_v1", "lease_v2", "lease", migrationMapping, MigrateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RollbackMigration(m, true); err != ErrRollbackUnavailable {
+		t.Errorf("RollbackMigration() error = %v, want %v", err, ErrRollbackUnavailable)
+	}
+}
+
+func TestMigrationMetaMerge(t *testing.T) {
+	seedMigration(t)
+
+	c, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := c.Elastic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_, err = client.PutMapping().
+		Index("lease_v1").
+		BodyJson(map[string]interface{}{"_meta": map[string]interface{}{"owner": "lease"}}).
+		Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := MigrateIndex("lease_v1", "lease_v2", "lease", migrationMapping, MigrateOptions{Retention: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := getMappingMeta(ctx, client, "lease_v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["owner"] != "lease" || meta[metaMigratedTo] != "lease_v2" || meta[metaDeleteAfter] == nil {
+		t.Errorf("_meta after migration = %v", meta)
+	}
+
+	if err := RollbackMigration(m, true); err != nil {
+		t.Fatal(err)
+	}
+	meta, err = getMappingMeta(ctx, client, "lease_v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"owner": "lease"}; !reflect.DeepEqual(meta, want) {
+		t.Errorf("_meta after rollback = %v, want %v", meta, want)
+	}
+}
+
+func TestPurgeExpiredIndices(t *testing.T) {
+	seedMigration(t)
+	if err := CreateESIndex("customer_v1", "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateIndex("lease_v1", "lease_v2", "lease", migrationMapping, MigrateOptions{Retention: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateIndex("customer_v1", "customer_v2", "customer", migrationMapping, MigrateOptions{Retention: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未到期限时不删除
+	if deleted, err := PurgeExpiredIndices(); err != nil || len(deleted) != 0 {
+		t.Errorf("PurgeExpiredIndices() = %v, %v", deleted, err)
+	}
+
+	client, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec, _ := client.Elastic()
+	if err := putMigrationMeta(context.Background(), ec, "lease_v1", map[string]interface{}{
+		metaDeleteAfter: time.Now().Add(-time.Minute).Format(time.RFC3339),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := PurgeExpiredIndices()
+	if err != nil {
+		t.Fatalf("PurgeExpiredIndices() error = %v", err)
+	}
+	if !reflect.DeepEqual(deleted, []string{"lease_v1"}) {
+		t.Errorf("PurgeExpiredIndices() = %v, want [lease_v1]", deleted)
+	}
+	if !ExistsESIndex("customer_v1") {
+		t.Error("customer_v1 should be kept until its deadline")
+	}
+}
@@ -0,0 +1,123 @@
+package mongosync
+
+import (
+	"context"
+	"encoding/json"
+
+	elastic "github.com/olivere/elastic/v7"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"rxcsoft.cn/utils/es"
+	rxmongo "rxcsoft.cn/utils/mongo"
+)
+
+type (
+	// ESAuditSink 把 mongo 包的审计记录保存到 ES 的索引，实现 mongo.AuditSink
+	// 文档ID 与 Config.ID 的默认值相同转换为字符串，文档的内容转换为 Extended JSON 保存，不建立索引
+	// ES 是近实时的，刚写入的记录约 1 秒后才能被 History 检索到
+	ESAuditSink struct {
+		index  string
+		client *es.Client
+	}
+)
+
+// maxHistory History 的 limit 为 0 时最多取得的件数，与 ES 的 max_result_window 默认值相同
+const maxHistory = 10000
+
+// AuditMapping 审计索引的 mapping，文档的内容不建立索引以免字段的类型冲突
+var AuditMapping = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"database":    map[string]interface{}{"type": "keyword"},
+			"collection":  map[string]interface{}{"type": "keyword"},
+			"document_id": map[string]interface{}{"type": "keyword"},
+			"action":      map[string]interface{}{"type": "keyword"},
+			"actor":       map[string]interface{}{"type": "keyword"},
+			"at":          map[string]interface{}{"type": "date"},
+			"before":      map[string]interface{}{"type": "object", "enabled": false},
+			"after":       map[string]interface{}{"type": "object", "enabled": false},
+			"changes":     map[string]interface{}{"type": "object", "enabled": false},
+		},
+	},
+}
+
+// NewESAuditSink 创建保存到 ES 的审计，client 为 nil 时使用 es 的默认客户端
+func NewESAuditSink(index string, client *es.Client) (*ESAuditSink, error) {
+	if client == nil {
+		c, err := es.Default()
+		if err != nil {
+			return nil, err
+		}
+		client = c
+	}
+	return &ESAuditSink{index: index, client: client}, nil
+}
+
+// CreateIndex 按 AuditMapping 创建索引，已存在时不做任何处理
+func (s *ESAuditSink) CreateIndex() error {
+	return s.client.CreateESIndexByJson(s.index, AuditMapping, false)
+}
+
+// Write 保存一条审计记录
+func (s *ESAuditSink) Write(ctx context.Context, entry *rxmongo.AuditEntry) error {
+	doc := *entry
+	doc.DocumentID = defaultID(entry.DocumentID)
+
+	var err error
+	if doc.Before, err = extJSON(entry.Before); err != nil {
+		return err
+	}
+	if doc.After, err = extJSON(entry.After); err != nil {
+		return err
+	}
+	doc.Changes = nil
+	for _, c := range entry.Changes {
+		v, err := extJSON(bson.M{"before": c.Before, "after": c.After})
+		if err != nil {
+			return err
+		}
+		doc.Changes = append(doc.Changes, rxmongo.FieldChange{Field: c.Field, Before: v["before"], After: v["after"]})
+	}
+
+	return s.client.ESInsert(s.index, primitive.NewObjectID().Hex(), doc)
+}
+
+// History 获取一个文档的变更历史，按时间从新到旧，DocumentID 为字符串，文档的内容为 Extended JSON 的形式
+func (s *ESAuditSink) History(ctx context.Context, database, collection string, id interface{}, limit int64) ([]rxmongo.AuditEntry, error) {
+	if limit <= 0 || limit > maxHistory {
+		limit = maxHistory
+	}
+
+	query := elastic.NewBoolQuery().Filter(
+		elastic.NewTermQuery("database", database),
+		elastic.NewTermQuery("collection", collection),
+		elastic.NewTermQuery("document_id", defaultID(id)),
+	)
+	var entries []rxmongo.AuditEntry
+	_, err := s.client.ESSearchInto(s.index, es.SearchRequest{
+		Query: query,
+		Sorts: []es.SortField{{Field: "at", Ascending: false}},
+		Size:  int(limit),
+	}, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// extJSON 把文档转换为 Extended JSON 的 map，保留 ObjectID、日期等类型的信息
+func extJSON(doc bson.M) (bson.M, error) {
+	if doc == nil {
+		return nil, nil
+	}
+	b, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
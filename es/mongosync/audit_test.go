@@ -0,0 +1,82 @@
+package mongosync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"rxcsoft.cn/utils/config"
+	"rxcsoft.cn/utils/es"
+	"rxcsoft.cn/utils/es/estest"
+	rxmongo "rxcsoft.cn/utils/mongo"
+)
+
+func TestESAuditSink(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	sink, err := NewESAuditSink("audit", es.New(config.DB{Host: srv.URL}, es.WithHealthcheckInterval(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.CreateIndex(); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	ctx := rxmongo.WithActor(context.Background(), "user-1")
+	oid := primitive.NewObjectID()
+	at := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	inserted := bson.M{"_id": oid, "no": "L1", "start": primitive.NewDateTimeFromTime(at)}
+	updated := bson.M{"_id": oid, "no": "L2", "start": primitive.NewDateTimeFromTime(at)}
+
+	for _, e := range []struct {
+		action        string
+		before, after interface{}
+	}{
+		{rxmongo.AuditInsert, nil, inserted},
+		{rxmongo.AuditUpdate, inserted, updated},
+	} {
+		entry, err := rxmongo.NewAuditEntry(ctx, "Db", "lease", e.action, oid, e.before, e.after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.At = at
+		at = at.Add(time.Minute)
+		if err := sink.Write(ctx, entry); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	// 其他集合的同一ID不应出现在历史中
+	other, _ := rxmongo.NewAuditEntry(ctx, "Db", "customer", rxmongo.AuditDelete, oid, inserted, nil)
+	if err := sink.Write(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := sink.History(ctx, "Db", "lease", oid, 0)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("History() = %v, want 2 entries", history)
+	}
+	latest := history[0]
+	if latest.Action != rxmongo.AuditUpdate || latest.Actor != "user-1" || latest.DocumentID != oid.Hex() {
+		t.Errorf("History()[0] = %+v", latest)
+	}
+	if len(latest.Changes) != 1 || latest.Changes[0].Field != "no" || latest.Changes[0].After != "L2" {
+		t.Errorf("History()[0].Changes = %v", latest.Changes)
+	}
+	if id, ok := latest.After["_id"].(map[string]interface{}); !ok || id["$oid"] != oid.Hex() {
+		t.Errorf("History()[0].After = %v", latest.After)
+	}
+	if history[1].Action != rxmongo.AuditInsert || history[1].Before != nil {
+		t.Errorf("History()[1] = %+v", history[1])
+	}
+
+	if history, _ := sink.History(ctx, "Db", "lease", oid, 1); len(history) != 1 {
+		t.Errorf("History(limit 1) = %v", history)
+	}
+}
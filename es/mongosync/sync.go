@@ -0,0 +1,488 @@
+// Package mongosync 通过 MongoDB 的 change stream 把集合同步到 Elasticsearch
+//
+//	s, err := mongosync.New(mongosync.Config{
+//	    Name:       "lease",
+//	    Database:   mongo.GetDBName(customerID),
+//	    Collection: "leases",
+//	    Index:      "lease",
+//	    Transform: func(doc bson.M) (interface{}, error) {
+//	        return toLeaseDoc(doc), nil
+//	    },
+//	})
+//	err = s.Run(ctx)
+//
+// 第一次启动或 token 失效时先全量同步，之后从 redis 中保存的 resume token 继续。
+// 写入 ES 使用单 worker 的批量写入，保证同一文档的变更按顺序生效。
+//
+// ESAuditSink 把 mongo 包的审计记录保存到 ES，用于在 ES 中检索文档的变更历史。
+package mongosync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"rxcsoft.cn/utils/es"
+	"rxcsoft.cn/utils/logger"
+	rxmongo "rxcsoft.cn/utils/mongo"
+)
+
+var (
+	log = logger.New()
+
+	// ErrInvalidated 集合被删除或改名，change stream 已失效，需要全量同步
+	ErrInvalidated = errors.New("change stream invalidated, full resync required")
+)
+
+// change stream 无法从 token 继续时的错误码
+const (
+	codeChangeStreamFatal       = 280
+	codeChangeStreamHistoryLost = 286
+)
+
+type (
+	// Transform 把 mongo 文档转换为 ES 文档，返回 nil 时从 ES 中删除该文档
+	Transform func(doc bson.M) (interface{}, error)
+
+	// Config 同步配置
+	Config struct {
+		Name       string                       // 同步名称，用作 token 的 key，同一个名称同时只能运行一个
+		Database   string                       // mongo 数据库名
+		Collection string                       // mongo 集合名
+		Index      string                       // ES 索引名或别名
+		Transform  Transform                    // 文档转换，为空时去掉 _id 后原样写入
+		ID         func(key interface{}) string // 由 mongo 的 _id 生成 ES 的文档ID，为空时 ObjectID 使用 hex
+		Pipeline   mongo.Pipeline               // 过滤 change stream 的管道，可为空
+
+		BatchSize          int32         // 全量同步时每批读取的件数
+		CheckpointInterval time.Duration // 保存 token 的间隔
+		CheckpointEvents   int           // 处理多少件变更后保存 token
+		Prune              bool          // 全量同步后删除 ES 中 mongo 已不存在的文档，需要在内存中保存所有文档ID
+		// OnFailure 转换失败或写入永久失败（重试也不会成功的 4xx）时的回调，这些文档会被跳过，token 照常保存；
+		// 写入暂时失败（429、5xx、索引禁止写入）时也会回调，但不保存 token，Run 返回错误，重启后从上次的 token 重新处理
+		OnFailure func(f es.BulkFailure)
+
+		Mongo  *mongo.Client // 为空时使用 mongo 包的连接
+		ES     *es.Client    // 为空时使用 es 的默认客户端
+		Tokens TokenStore    // 为空时保存在 redisx 的 redis 中
+	}
+
+	// Syncer 一个集合到一个索引的同步
+	Syncer struct {
+		retryable int64 // 写入暂时失败的文档数，放在首位以保证 atomic 操作的 64 位对齐
+
+		cfg  Config
+		coll *mongo.Collection
+		bulk *es.BulkIndexer
+
+		pending        int
+		lastCheckpoint time.Time
+	}
+
+	// changeEvent change stream 的事件
+	changeEvent struct {
+		OperationType string `bson:"operationType"`
+		FullDocument  bson.M `bson:"fullDocument"`
+		DocumentKey   struct {
+			ID interface{} `bson:"_id"`
+		} `bson:"documentKey"`
+	}
+)
+
+// New 创建同步
+func New(cfg Config) (*Syncer, error) {
+	if cfg.Name == "" || cfg.Database == "" || cfg.Collection == "" || cfg.Index == "" {
+		return nil, errors.New("mongosync: Name, Database, Collection and Index are required")
+	}
+
+	if cfg.Transform == nil {
+		cfg.Transform = defaultTransform
+	}
+	if cfg.ID == nil {
+		cfg.ID = defaultID
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	if cfg.CheckpointInterval <= 0 {
+		cfg.CheckpointInterval = 5 * time.Second
+	}
+	if cfg.CheckpointEvents <= 0 {
+		cfg.CheckpointEvents = 1000
+	}
+	if cfg.Mongo == nil {
+		cfg.Mongo = rxmongo.New()
+	}
+	if cfg.Mongo == nil {
+		return nil, errors.New("mongosync: mongodb is not started")
+	}
+	if cfg.ES == nil {
+		c, err := es.Default()
+		if err != nil {
+			return nil, err
+		}
+		cfg.ES = c
+	}
+	if cfg.Tokens == nil {
+		cfg.Tokens = NewRedisTokenStore(nil)
+	}
+
+	return &Syncer{
+		cfg:  cfg,
+		coll: cfg.Mongo.Database(cfg.Database).Collection(cfg.Collection),
+	}, nil
+}
+
+// Run 开始同步，直到 ctx 结束或出错
+// 没有保存的 token 或 token 已失效时先全量同步，集合被删除或改名时返回 ErrInvalidated
+func (s *Syncer) Run(ctx context.Context) error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	defer s.close()
+
+	token, err := s.cfg.Tokens.Load(ctx, s.cfg.Name)
+	if err != nil {
+		return err
+	}
+
+	var stream *mongo.ChangeStream
+	if token != nil {
+		stream, err = s.watch(ctx, token)
+		if isHistoryLost(err) {
+			log.Warnf("mongosync %s: resume token expired, start full resync", s.cfg.Name)
+			token = nil
+		} else if err != nil {
+			return err
+		}
+	}
+	if token == nil {
+		if stream, err = s.resync(ctx); err != nil {
+			return err
+		}
+	}
+
+	return s.consume(ctx, stream)
+}
+
+// FullResync 只执行全量同步并保存 token，不继续监听变更
+func (s *Syncer) FullResync(ctx context.Context) error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	defer s.close()
+
+	if err := s.cfg.Tokens.Delete(ctx, s.cfg.Name); err != nil {
+		return err
+	}
+
+	stream, err := s.resync(ctx)
+	if err != nil {
+		return err
+	}
+	return stream.Close(ctx)
+}
+
+// open 创建批量写入器，单 worker 保证写入顺序
+func (s *Syncer) open() error {
+	cfg := es.DefaultBulkConfig()
+	cfg.Name = "mongosync-" + s.cfg.Name
+	cfg.Workers = 1
+	cfg.FlushInterval = 0
+	cfg.OnFailure = s.bulkFail
+
+	// 使用独立的 ctx，避免 Run 的 ctx 结束后无法提交最后一批
+	bulk, err := s.cfg.ES.NewBulkIndexer(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	s.bulk = bulk
+	s.lastCheckpoint = time.Now()
+	atomic.StoreInt64(&s.retryable, 0)
+	return nil
+}
+
+func (s *Syncer) close() {
+	if err := s.bulk.Close(); err != nil {
+		log.Errorf("mongosync %s: close bulk error: %v", s.cfg.Name, err)
+	}
+}
+
+func (s *Syncer) fail(f es.BulkFailure) {
+	log.Errorf("mongosync %s: %s %s/%s failed: %v %v", s.cfg.Name, f.Action, f.Index, f.ID, f.Detail, f.Err)
+	if s.cfg.OnFailure != nil {
+		s.cfg.OnFailure(f)
+	}
+}
+
+// bulkFail 写入失败的回调，记录暂时失败的文档数
+func (s *Syncer) bulkFail(f es.BulkFailure) {
+	if f.Retryable() {
+		atomic.AddInt64(&s.retryable, 1)
+	}
+	s.fail(f)
+}
+
+// flush 提交待处理的操作
+// 永久失败的文档已通过 OnFailure 通知并跳过，只有暂时失败时返回错误，
+// 自动提交的批次中的暂时失败也会计入，直到重新 open 为止
+func (s *Syncer) flush() error {
+	if err := s.bulk.Flush(); err != nil && !errors.Is(err, es.ErrBulkFailed) {
+		return err
+	}
+	if n := atomic.LoadInt64(&s.retryable); n > 0 {
+		return fmt.Errorf("%w: %d retryable items", es.ErrBulkFailed, n)
+	}
+	return nil
+}
+
+// watch 打开 change stream，token 为 nil 时从当前时间开始
+func (s *Syncer) watch(ctx context.Context, token bson.Raw) (*mongo.ChangeStream, error) {
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetMaxAwaitTime(time.Second)
+	if token != nil {
+		opts.SetStartAfter(token)
+	}
+
+	pipeline := s.cfg.Pipeline
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
+	}
+	return s.coll.Watch(ctx, pipeline, opts)
+}
+
+// resync 全量同步
+// 先打开 change stream 记录起点，复制期间发生的变更会在之后重新应用，写入是幂等的
+func (s *Syncer) resync(ctx context.Context) (*mongo.ChangeStream, error) {
+	stream, err := s.watch(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	seen, count, err := s.copyAll(ctx)
+	if err == nil {
+		err = s.flush()
+	}
+	if err == nil && s.cfg.Prune {
+		err = s.prune(ctx, seen)
+	}
+	if err == nil {
+		err = s.checkpoint(ctx, stream.ResumeToken())
+	}
+	if err != nil {
+		stream.Close(ctx)
+		return nil, err
+	}
+
+	log.Infof("mongosync %s: full resync %d documents in %v", s.cfg.Name, count, time.Since(start))
+	return stream, nil
+}
+
+// copyAll 把集合的所有文档写入 ES，Prune 时返回写入的文档ID
+func (s *Syncer) copyAll(ctx context.Context) (map[string]struct{}, int, error) {
+	cur, err := s.coll.Find(ctx, bson.M{}, options.Find().SetBatchSize(s.cfg.BatchSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(ctx)
+
+	var seen map[string]struct{}
+	if s.cfg.Prune {
+		seen = make(map[string]struct{})
+	}
+
+	count := 0
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return nil, count, err
+		}
+
+		item, ok := s.document(doc)
+		if !ok {
+			continue
+		}
+		if err := s.bulk.Add(item); err != nil {
+			return nil, count, err
+		}
+		if seen != nil && item.Action != es.BulkDelete {
+			seen[item.ID] = struct{}{}
+		}
+		count++
+	}
+
+	return seen, count, cur.Err()
+}
+
+// prune 删除 ES 中不在 seen 里的文档
+func (s *Syncer) prune(ctx context.Context, seen map[string]struct{}) error {
+	it, err := s.cfg.ES.NewScrollIterator(s.cfg.Index, nil, nil, int(s.cfg.BatchSize), "1m")
+	if err != nil {
+		return err
+	}
+	defer it.Close(ctx)
+
+	deleted := 0
+	for {
+		var docs []map[string]interface{}
+		meta, err := it.Next(ctx, &docs)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, h := range meta.Hits {
+			if _, ok := seen[h.ID]; !ok {
+				s.bulk.Delete(s.cfg.Index, h.ID)
+				deleted++
+			}
+		}
+	}
+
+	if deleted > 0 {
+		log.Infof("mongosync %s: pruned %d documents", s.cfg.Name, deleted)
+	}
+	return s.flush()
+}
+
+// consume 处理变更，定期保存 token
+func (s *Syncer) consume(ctx context.Context, stream *mongo.ChangeStream) error {
+	defer stream.Close(context.Background())
+
+	for {
+		if stream.TryNext(ctx) {
+			var ev changeEvent
+			if err := stream.Decode(&ev); err != nil {
+				return err
+			}
+
+			switch ev.OperationType {
+			case "drop", "rename", "dropDatabase", "invalidate":
+				if err := s.checkpoint(context.Background(), stream.ResumeToken()); err != nil {
+					return err
+				}
+				return ErrInvalidated
+			}
+
+			if item, ok := s.event(ev); ok {
+				if err := s.bulk.Add(item); err != nil {
+					return err
+				}
+			}
+			s.pending++
+		}
+
+		if ctx.Err() != nil {
+			// 结束前提交已处理的变更
+			if err := s.checkpoint(context.Background(), stream.ResumeToken()); err != nil {
+				log.Errorf("mongosync %s: checkpoint error: %v", s.cfg.Name, err)
+			}
+			return ctx.Err()
+		}
+		if err := stream.Err(); err != nil {
+			return err
+		}
+
+		if s.pending >= s.cfg.CheckpointEvents || time.Since(s.lastCheckpoint) >= s.cfg.CheckpointInterval {
+			if err := s.checkpoint(ctx, stream.ResumeToken()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkpoint 提交已处理的变更后保存 token
+// 有写入 ES 暂时失败的文档时不保存 token，重启后从上次的 token 重新处理；
+// 永久失败的文档被跳过，token 照常保存，避免同一个事件在每次重启时都失败
+func (s *Syncer) checkpoint(ctx context.Context, token bson.Raw) error {
+	if err := s.flush(); err != nil {
+		log.Errorf("mongosync %s: flush error, token not saved: %v", s.cfg.Name, err)
+		return err
+	}
+
+	if token != nil {
+		if err := s.cfg.Tokens.Save(ctx, s.cfg.Name, token); err != nil {
+			return err
+		}
+	}
+
+	s.pending = 0
+	s.lastCheckpoint = time.Now()
+	return nil
+}
+
+// event 把变更事件转换为批量操作，不需要写入时返回 false
+func (s *Syncer) event(ev changeEvent) (es.BulkItem, bool) {
+	switch ev.OperationType {
+	case "insert", "replace", "update":
+		// 更新后文档又被删除时 fullDocument 为空，之后的 delete 事件会处理
+		if ev.FullDocument == nil {
+			return es.BulkItem{}, false
+		}
+		return s.document(ev.FullDocument)
+	case "delete":
+		return es.BulkItem{
+			Action: es.BulkDelete,
+			Index:  s.cfg.Index,
+			ID:     s.cfg.ID(ev.DocumentKey.ID),
+		}, true
+	}
+
+	return es.BulkItem{}, false
+}
+
+// document 转换文档，转换失败时通知 OnFailure 并跳过
+func (s *Syncer) document(doc bson.M) (es.BulkItem, bool) {
+	id := s.cfg.ID(doc["_id"])
+
+	body, err := s.cfg.Transform(doc)
+	if err != nil {
+		s.fail(es.BulkFailure{Action: string(es.BulkIndex), Index: s.cfg.Index, ID: id, Err: err})
+		return es.BulkItem{}, false
+	}
+
+	if body == nil {
+		return es.BulkItem{Action: es.BulkDelete, Index: s.cfg.Index, ID: id}, true
+	}
+	return es.BulkItem{Action: es.BulkIndex, Index: s.cfg.Index, ID: id, Doc: body}, true
+}
+
+// isHistoryLost token 对应的 oplog 已不存在
+func isHistoryLost(err error) bool {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		return ce.Code == codeChangeStreamHistoryLost || ce.Code == codeChangeStreamFatal
+	}
+	return false
+}
+
+func defaultTransform(doc bson.M) (interface{}, error) {
+	body := make(bson.M, len(doc))
+	for k, v := range doc {
+		if k != "_id" {
+			body[k] = v
+		}
+	}
+	return body, nil
+}
+
+func defaultID(key interface{}) string {
+	switch v := key.(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case string:
+		return v
+	}
+	return fmt.Sprint(key)
+}
@@ -0,0 +1,214 @@
+package mongosync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"rxcsoft.cn/utils/config"
+	"rxcsoft.cn/utils/es"
+	"rxcsoft.cn/utils/es/estest"
+)
+
+// newTestSyncer 不连接 mongo，只用于测试事件到 ES 的写入
+func newTestSyncer(t *testing.T, srv *estest.Server, transform Transform) *Syncer {
+	t.Helper()
+	s := &Syncer{cfg: Config{
+		Name:      "lease",
+		Index:     "lease",
+		Transform: transform,
+		ID:        defaultID,
+		BatchSize: 2,
+		ES:        es.New(config.DB{Host: srv.URL}, es.WithHealthcheckInterval(0)),
+	}}
+	if s.cfg.Transform == nil {
+		s.cfg.Transform = defaultTransform
+	}
+	if err := s.open(); err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	return s
+}
+
+func TestEvent(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	s := newTestSyncer(t, srv, nil)
+	defer s.close()
+
+	oid := primitive.NewObjectID()
+	events := []changeEvent{
+		{OperationType: "insert", FullDocument: bson.M{"_id": oid, "name": "a"}},
+		{OperationType: "insert", FullDocument: bson.M{"_id": "k2", "name": "b"}},
+		{OperationType: "update", FullDocument: bson.M{"_id": oid, "name": "c"}},
+		// 更新后被删除，fullDocument 为空
+		{OperationType: "update"},
+	}
+	del := changeEvent{OperationType: "delete"}
+	del.DocumentKey.ID = "k2"
+	events = append(events, del)
+
+	for _, ev := range events {
+		if item, ok := s.event(ev); ok {
+			if err := s.bulk.Add(item); err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+		}
+	}
+	if err := s.bulk.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	got := srv.Source("lease", oid.Hex())
+	if got == nil || got["name"] != "c" {
+		t.Errorf("doc %s = %v, want name c", oid.Hex(), got)
+	}
+	if _, ok := got["_id"]; ok {
+		t.Errorf("default transform should drop _id: %v", got)
+	}
+	if srv.Source("lease", "k2") != nil {
+		t.Errorf("doc k2 should be deleted")
+	}
+}
+
+func TestDocumentTransform(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	var failed []es.BulkFailure
+	s := newTestSyncer(t, srv, func(doc bson.M) (interface{}, error) {
+		switch doc["status"] {
+		case "deleted":
+			return nil, nil
+		case "broken":
+			return nil, errors.New("broken")
+		}
+		return bson.M{"title": doc["name"]}, nil
+	})
+	defer s.close()
+	s.cfg.OnFailure = func(f es.BulkFailure) { failed = append(failed, f) }
+
+	item, ok := s.document(bson.M{"_id": "1", "name": "a"})
+	if !ok || item.Action != es.BulkIndex || item.ID != "1" {
+		t.Errorf("document() = %+v, %v", item, ok)
+	}
+	item, ok = s.document(bson.M{"_id": 2, "status": "deleted"})
+	if !ok || item.Action != es.BulkDelete || item.ID != "2" {
+		t.Errorf("document() deleted = %+v, %v", item, ok)
+	}
+	if _, ok := s.document(bson.M{"_id": "3", "status": "broken"}); ok || len(failed) != 1 || failed[0].ID != "3" {
+		t.Errorf("document() broken: ok = %v, failed = %v", ok, failed)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	s := newTestSyncer(t, srv, nil)
+	defer s.close()
+
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		s.bulk.Index("lease", id, bson.M{"name": id})
+	}
+	if err := s.bulk.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	seen := map[string]struct{}{"1": {}, "4": {}}
+	if err := s.prune(context.Background(), seen); err != nil {
+		t.Fatalf("prune() error = %v", err)
+	}
+
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		_, keep := seen[id]
+		if exists := srv.Source("lease", id) != nil; exists != keep {
+			t.Errorf("doc %s exists = %v, want %v", id, exists, keep)
+		}
+	}
+}
+
+// memoryTokens 保存在内存中的 token，用于测试
+type memoryTokens map[string]bson.Raw
+
+func (m memoryTokens) Load(ctx context.Context, name string) (bson.Raw, error) {
+	return m[name], nil
+}
+
+func (m memoryTokens) Save(ctx context.Context, name string, token bson.Raw) error {
+	m[name] = token
+	return nil
+}
+
+func (m memoryTokens) Delete(ctx context.Context, name string) error {
+	delete(m, name)
+	return nil
+}
+
+func TestCheckpoint(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	tokens := memoryTokens{}
+	var failed []es.BulkFailure
+	s := newTestSyncer(t, srv, nil)
+	defer s.close()
+	s.cfg.Tokens = tokens
+	s.cfg.OnFailure = func(f es.BulkFailure) { failed = append(failed, f) }
+
+	ctx := context.Background()
+	t1, _ := bson.Marshal(bson.M{"_data": "1"})
+	t2, _ := bson.Marshal(bson.M{"_data": "2"})
+	t3, _ := bson.Marshal(bson.M{"_data": "3"})
+
+	s.bulk.Index("lease", "1", bson.M{"name": "a"})
+	s.pending = 1
+	if err := s.checkpoint(ctx, t1); err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+	if !bytes.Equal(tokens["lease"], t1) || s.pending != 0 {
+		t.Fatalf("token = %v, pending = %d", tokens["lease"], s.pending)
+	}
+
+	// 永久失败（更新不存在的文档）通知 OnFailure 后跳过，token 照常保存
+	s.bulk.Update("lease", "9", bson.M{"name": "x"})
+	s.bulk.Index("lease", "3", bson.M{"name": "c"})
+	s.pending = 2
+	if err := s.checkpoint(ctx, t2); err != nil {
+		t.Fatalf("checkpoint() with permanent failure error = %v", err)
+	}
+	if !bytes.Equal(tokens["lease"], t2) || s.pending != 0 {
+		t.Errorf("token = %v, pending = %d, want advanced", tokens["lease"], s.pending)
+	}
+	if len(failed) != 1 || failed[0].ID != "9" || failed[0].Retryable() {
+		t.Errorf("failures = %+v", failed)
+	}
+	if srv.Source("lease", "3") == nil {
+		t.Error("doc 3 should be indexed")
+	}
+
+	// 索引禁止写入是暂时失败，不保存 token
+	client, err := s.cfg.ES.Elastic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.IndexPutSettings("lease").BodyJson(bson.M{"index.blocks.write": true}).Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.bulk.Index("lease", "2", bson.M{"name": "b"})
+	s.pending = 1
+	if err := s.checkpoint(ctx, t3); !errors.Is(err, es.ErrBulkFailed) {
+		t.Fatalf("checkpoint() error = %v, want %v", err, es.ErrBulkFailed)
+	}
+	if len(failed) != 2 || !failed[1].Retryable() {
+		t.Errorf("failures = %+v", failed)
+	}
+	if !bytes.Equal(tokens["lease"], t2) || s.pending != 1 {
+		t.Errorf("token = %v, pending = %d, want unchanged", tokens["lease"], s.pending)
+	}
+}
@@ -0,0 +1,64 @@
+package mongosync
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"rxcsoft.cn/utils/redisx"
+)
+
+type (
+	// TokenStore 保存 change stream 的 resume token
+	TokenStore interface {
+		// Load 读取 token，不存在时返回 nil
+		Load(ctx context.Context, name string) (bson.Raw, error)
+		// Save 保存 token
+		Save(ctx context.Context, name string, token bson.Raw) error
+		// Delete 删除 token，下次启动时需要全量同步
+		Delete(ctx context.Context, name string) error
+	}
+
+	// RedisTokenStore 保存在 redis 中的 token
+	RedisTokenStore struct {
+		client *redis.Client
+		prefix string
+	}
+)
+
+// DefaultTokenPrefix redis key 的默认前缀
+const DefaultTokenPrefix = "mongosync:token:"
+
+// NewRedisTokenStore 创建 redis 的 token 保存，client 为 nil 时使用 redisx 的共享连接
+func NewRedisTokenStore(client *redis.Client) *RedisTokenStore {
+	if client == nil {
+		client = redisx.New()
+	}
+	return &RedisTokenStore{
+		client: client,
+		prefix: DefaultTokenPrefix,
+	}
+}
+
+// Load 读取 token，不存在时返回 nil
+func (s *RedisTokenStore) Load(ctx context.Context, name string) (bson.Raw, error) {
+	b, err := s.client.Get(ctx, s.prefix+name).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bson.Raw(b), nil
+}
+
+// Save 保存 token
+func (s *RedisTokenStore) Save(ctx context.Context, name string, token bson.Raw) error {
+	return s.client.Set(ctx, s.prefix+name, []byte(token), 0).Err()
+}
+
+// Delete 删除 token
+func (s *RedisTokenStore) Delete(ctx context.Context, name string) error {
+	return s.client.Del(ctx, s.prefix+name).Err()
+}
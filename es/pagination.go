@@ -0,0 +1,220 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+type (
+	// SortField 排序字段
+	SortField struct {
+		Field     string // 字段名
+		Ascending bool   // 是否升序
+	}
+
+	// PointInTime 时间点，用于在翻页期间保持一致的数据视图
+	PointInTime struct {
+		ID        string // OpenPointInTime 返回的ID
+		KeepAlive string // 每次请求后保持的时间，例如 "1m"
+	}
+
+	// pitSearchResult 带时间点ID的检索结果
+	pitSearchResult struct {
+		elastic.SearchResult
+		PitID string `json:"pit_id"`
+	}
+
+	// ScrollIterator 基于 scroll 的遍历器，用于导出大量数据
+	ScrollIterator struct {
+		scroll *elastic.ScrollService
+	}
+)
+
+// sorters 把排序字段转换为 elastic 的排序
+func sorters(sorts []SortField) []elastic.Sorter {
+	var result []elastic.Sorter
+	for _, s := range sorts {
+		result = append(result, elastic.NewFieldSort(s.Field).Order(s.Ascending))
+	}
+	return result
+}
+
+// NextSearchAfter 取得下一页使用的 search_after 值，没有命中时返回 nil
+func (m *SearchMeta) NextSearchAfter() []interface{} {
+	if len(m.Hits) == 0 {
+		return nil
+	}
+	return m.Hits[len(m.Hits)-1].Sort
+}
+
+// OpenPointInTime 为索引创建一个时间点
+func (c *Client) OpenPointInTime(indexName, keepAlive string) (string, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   "/" + url.PathEscape(indexName) + "/_pit",
+		Params: url.Values{"keep_alive": []string{keepAlive}},
+	})
+	if err != nil {
+		log.Errorf("ES open point in time error: %v", err)
+		return "", err
+	}
+
+	var pit struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(res.Body, &pit); err != nil {
+		return "", err
+	}
+
+	return pit.ID, nil
+}
+
+// ClosePointInTime 关闭时间点，释放服务端资源
+func (c *Client) ClosePointInTime(id string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "DELETE",
+		Path:   "/_pit",
+		Body:   map[string]interface{}{"id": id},
+	})
+	if err != nil {
+		log.Errorf("ES close point in time error: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// searchWithPointInTime 使用时间点检索，请求中不能指定索引
+func searchWithPointInTime(ctx context.Context, client *elastic.Client, req SearchRequest) (*elastic.SearchResult, string, error) {
+	src, err := buildSearchSource(req).Source()
+	if err != nil {
+		return nil, "", err
+	}
+
+	body, ok := src.(map[string]interface{})
+	if !ok {
+		body = make(map[string]interface{})
+	}
+	pit := map[string]interface{}{"id": req.PointInTime.ID}
+	if req.PointInTime.KeepAlive != "" {
+		pit["keep_alive"] = req.PointInTime.KeepAlive
+	}
+	body["pit"] = pit
+
+	res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   "/_search",
+		Body:   body,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var result pitSearchResult
+	if err := json.Unmarshal(res.Body, &result); err != nil {
+		return nil, "", err
+	}
+
+	return &result.SearchResult, result.PitID, nil
+}
+
+// ESSearchAfter 使用 search_after 翻页检索，适用于超过 10000 件的深度分页
+// 必须指定包含唯一字段的 Sorts，第一页 after 传 nil，之后传 meta.NextSearchAfter()
+func (c *Client) ESSearchAfter(indexName string, req SearchRequest, after []interface{}, result interface{}) (*SearchMeta, error) {
+	req.From = 0
+	req.SearchAfter = after
+	return c.ESSearchInto(indexName, req, result)
+}
+
+// ESSearchEach 在时间点上按 search_after 遍历所有命中的文档，每页解码到 result 后调用 fn
+// 必须指定包含唯一字段的 Sorts，fn 返回错误时停止遍历并返回该错误，结束或出错时都会关闭时间点
+func (c *Client) ESSearchEach(indexName string, req SearchRequest, keepAlive string, result interface{}, fn func(meta *SearchMeta) error) (err error) {
+	pitID, err := c.OpenPointInTime(indexName, keepAlive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// 时间点ID可能在翻页过程中更新，关闭最新的ID
+		if cerr := c.ClosePointInTime(pitID); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	req.From = 0
+	req.SearchAfter = nil
+	for {
+		req.PointInTime = &PointInTime{ID: pitID, KeepAlive: keepAlive}
+		meta, err := c.ESSearchInto("", req, result)
+		if err != nil {
+			return err
+		}
+		if meta.PitID != "" {
+			pitID = meta.PitID
+		}
+		if len(meta.Hits) == 0 {
+			return nil
+		}
+		if err := fn(meta); err != nil {
+			return err
+		}
+		req.SearchAfter = meta.NextSearchAfter()
+	}
+}
+
+// NewScrollIterator 创建一个 scroll 遍历器，使用完后需要调用 Close
+func (c *Client) NewScrollIterator(indexName string, query elastic.Query, sorts []SortField, size int, keepAlive string) (*ScrollIterator, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	scroll := client.Scroll(strings.Split(indexName, ",")...).
+		Size(size).
+		KeepAlive(keepAlive)
+	if query != nil {
+		scroll = scroll.Query(query)
+	}
+	if len(sorts) > 0 {
+		scroll = scroll.SortBy(sorters(sorts)...)
+	} else {
+		// 不需要排序时按 _doc 遍历效率最高
+		scroll = scroll.Sort("_doc", true)
+	}
+
+	return &ScrollIterator{scroll: scroll}, nil
+}
+
+// Next 取得下一批文档并解码到 result，没有更多数据时返回 io.EOF
+func (it *ScrollIterator) Next(ctx context.Context, result interface{}) (*SearchMeta, error) {
+	res, err := it.scroll.Do(ctx)
+	if err != nil {
+		if err != io.EOF {
+			log.Errorf("ES scroll error: %v", err)
+		}
+		return nil, err
+	}
+
+	return decodeHits(res, result)
+}
+
+// Close 清除服务端的 scroll 上下文
+func (it *ScrollIterator) Close(ctx context.Context) error {
+	return it.scroll.Clear(ctx)
+}
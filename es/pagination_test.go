@@ -0,0 +1,196 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type pageDoc struct {
+	N int `json:"n"`
+}
+
+// seedPages 写入 n 件按 n 排序的文档
+func seedPages(t *testing.T, indexName string, n int) {
+	t.Helper()
+	docs := make(map[string]map[string]interface{})
+	for i := 1; i <= n; i++ {
+		docs[fmt.Sprintf("d%02d", i)] = map[string]interface{}{"n": i}
+	}
+	seed(t, indexName, docs)
+}
+
+func numbers(docs []pageDoc) []int {
+	var result []int
+	for _, d := range docs {
+		result = append(result, d.N)
+	}
+	return result
+}
+
+func TestESSearchAfter(t *testing.T) {
+	seedPages(t, "pages", 5)
+
+	req := SearchRequest{Sorts: []SortField{{Field: "n", Ascending: true}}, Size: 2}
+	var got []int
+	var after []interface{}
+	for page := 0; page < 10; page++ {
+		var docs []pageDoc
+		meta, err := ESSearchAfter("pages", req, after, &docs)
+		if err != nil {
+			t.Fatalf("ESSearchAfter() error = %v", err)
+		}
+		if meta.TotalHits != 5 {
+			t.Errorf("ESSearchAfter() TotalHits = %d, want 5", meta.TotalHits)
+		}
+		if len(docs) == 0 {
+			if meta.NextSearchAfter() != nil {
+				t.Errorf("NextSearchAfter() on empty page = %v, want nil", meta.NextSearchAfter())
+			}
+			break
+		}
+		got = append(got, numbers(docs)...)
+		after = meta.NextSearchAfter()
+	}
+
+	if want := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ESSearchAfter() pages = %v, want %v", got, want)
+	}
+}
+
+func TestESSearchEach(t *testing.T) {
+	seedPages(t, "pages", 5)
+
+	req := SearchRequest{Sorts: []SortField{{Field: "n", Ascending: true}}, Size: 2}
+	var docs []pageDoc
+	var pages [][]int
+	err := ESSearchEach("pages", req, "1m", &docs, func(meta *SearchMeta) error {
+		if len(docs) == 1 {
+			// 时间点之后写入的文档不应出现在遍历结果中
+			if err := ESInsert("pages", "d99", map[string]interface{}{"n": 99}); err != nil {
+				return err
+			}
+		}
+		pages = append(pages, numbers(docs))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ESSearchEach() error = %v", err)
+	}
+
+	if want := [][]int{{1, 2}, {3, 4}, {5}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("ESSearchEach() pages = %v, want %v", pages, want)
+	}
+	if ids := srv.PointsInTime(); len(ids) != 0 {
+		t.Errorf("ESSearchEach() left points in time open: %v", ids)
+	}
+}
+
+func TestESSearchEachExactPages(t *testing.T) {
+	seedPages(t, "pages", 4)
+
+	// 件数正好是页大小的倍数时，最后一页为空并结束遍历
+	req := SearchRequest{Sorts: []SortField{{Field: "n", Ascending: true}}, Size: 2}
+	var docs []pageDoc
+	calls := 0
+	err := ESSearchEach("pages", req, "1m", &docs, func(meta *SearchMeta) error {
+		calls++
+		if len(docs) == 0 {
+			t.Errorf("ESSearchEach() called fn with an empty page")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ESSearchEach() error = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("ESSearchEach() calls = %d, want 2", calls)
+	}
+}
+
+func TestESSearchEachClosesOnError(t *testing.T) {
+	seedPages(t, "pages", 5)
+
+	stop := errors.New("stop")
+	req := SearchRequest{Sorts: []SortField{{Field: "n", Ascending: true}}, Size: 2}
+	var docs []pageDoc
+	calls := 0
+	err := ESSearchEach("pages", req, "1m", &docs, func(meta *SearchMeta) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("ESSearchEach() error = %v, want %v", err, stop)
+	}
+	if calls != 1 {
+		t.Errorf("ESSearchEach() calls = %d, want 1", calls)
+	}
+	if ids := srv.PointsInTime(); len(ids) != 0 {
+		t.Errorf("ESSearchEach() left points in time open after error: %v", ids)
+	}
+
+	if err := ESSearchEach("missing", req, "1m", &docs, func(*SearchMeta) error { return nil }); err == nil {
+		t.Errorf("ESSearchEach() on missing index error = nil")
+	}
+}
+
+func TestPointInTime(t *testing.T) {
+	seedPages(t, "pages", 3)
+
+	id, err := OpenPointInTime("pages", "1m")
+	if err != nil {
+		t.Fatalf("OpenPointInTime() error = %v", err)
+	}
+	if ids := srv.PointsInTime(); !reflect.DeepEqual(ids, []string{id}) {
+		t.Errorf("PointsInTime() = %v, want [%s]", ids, id)
+	}
+
+	if err := ClosePointInTime(id); err != nil {
+		t.Errorf("ClosePointInTime() error = %v", err)
+	}
+	if ids := srv.PointsInTime(); len(ids) != 0 {
+		t.Errorf("PointsInTime() after close = %v", ids)
+	}
+	if err := ClosePointInTime(id); err == nil {
+		t.Errorf("ClosePointInTime() on closed id error = nil")
+	}
+
+	var docs []pageDoc
+	_, err = ESSearchInto("", SearchRequest{PointInTime: &PointInTime{ID: id}}, &docs)
+	if err == nil {
+		t.Errorf("ESSearchInto() on closed point in time error = nil")
+	}
+}
+
+func TestScrollIterator(t *testing.T) {
+	seedPages(t, "pages", 5)
+
+	it, err := NewScrollIterator("pages", nil, []SortField{{Field: "n", Ascending: true}}, 2, "1m")
+	if err != nil {
+		t.Fatalf("NewScrollIterator() error = %v", err)
+	}
+	defer it.Close(context.Background())
+
+	var got []int
+	for {
+		var docs []pageDoc
+		_, err := it.Next(context.Background(), &docs)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		got = append(got, numbers(docs)...)
+	}
+
+	if want := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ScrollIterator pages = %v, want %v", got, want)
+	}
+	if err := it.Close(context.Background()); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+type (
+	// RestoreOptions 恢复快照的配置
+	RestoreOptions struct {
+		Indices []string // 要恢复的索引，为空时恢复快照中的全部索引
+		// RenamePattern 与 RenameReplacement 一起使用，按正则重命名恢复后的索引，
+		// 例如 "(.+)" 和 "restored_$1"，避免覆盖现有的索引
+		RenamePattern     string
+		RenameReplacement string
+		IncludeAliases    bool                   // 是否同时恢复别名，重命名恢复时一般不需要
+		IndexSettings     map[string]interface{} // 覆盖恢复后索引的设置，例如 {"index.number_of_replicas": 0}
+		WaitForCompletion bool                   // 是否等待恢复结束
+	}
+)
+
+// snapshotTimeFormat BackupIndices 生成的快照名中的时间格式
+const snapshotTimeFormat = "20060102150405"
+
+// RegisterFSRepository 注册共享文件系统类型的快照仓库
+// location 必须包含在每个节点的 path.repo 中，settings 可追加 compress、max_snapshot_bytes_per_sec 等设置
+func (c *Client) RegisterFSRepository(name, location string, settings map[string]interface{}) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	s := map[string]interface{}{"location": location}
+	for k, v := range settings {
+		s[k] = v
+	}
+
+	ctx := context.Background()
+	_, err = client.SnapshotCreateRepository(name).
+		Type("fs").
+		Settings(s).
+		Verify(true).
+		Do(ctx)
+	if err != nil {
+		log.Errorf("ES register snapshot repository error: %v", err)
+		return err
+	}
+
+	log.Infof("ES register snapshot repository ok: %v -> %v", name, location)
+	return nil
+}
+
+// DeleteRepository 注销快照仓库，仓库中的文件不会被删除
+func (c *Client) DeleteRepository(name string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if _, err := client.SnapshotDeleteRepository(name).Do(ctx); err != nil {
+		log.Errorf("ES delete snapshot repository error: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// CreateSnapshot 创建指定索引的快照，不包含集群的全局状态
+// wait 为 false 时立即返回 nil，可通过 GetSnapshot 确认状态
+func (c *Client) CreateSnapshot(repository, snapshot string, indices []string, wait bool) (*elastic.Snapshot, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{
+		"ignore_unavailable":   false,
+		"include_global_state": false,
+	}
+	if len(indices) > 0 {
+		body["indices"] = strings.Join(indices, ",")
+	}
+
+	ctx := context.Background()
+	res, err := client.SnapshotCreate(repository, snapshot).
+		BodyJson(body).
+		WaitForCompletion(wait).
+		Do(ctx)
+	if err != nil {
+		log.Errorf("ES create snapshot error: %v", err)
+		return nil, err
+	}
+
+	if res.Snapshot != nil && res.Snapshot.State != "SUCCESS" {
+		return res.Snapshot, fmt.Errorf("es snapshot %s/%s finished with state %s: %s", repository, snapshot, res.Snapshot.State, res.Snapshot.Reason)
+	}
+
+	log.Infof("ES create snapshot ok: %v/%v %v", repository, snapshot, indices)
+	return res.Snapshot, nil
+}
+
+// GetSnapshot 获取快照信息，不存在时返回 nil
+func (c *Client) GetSnapshot(repository, snapshot string) (*elastic.Snapshot, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	res, err := client.SnapshotGet(repository).Snapshot(snapshot).Do(ctx)
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Errorf("ES get snapshot error: %v", err)
+		return nil, err
+	}
+	if len(res.Snapshots) == 0 {
+		return nil, nil
+	}
+
+	return res.Snapshots[0], nil
+}
+
+// ListSnapshots 获取仓库中的全部快照，按开始时间排序
+func (c *Client) ListSnapshots(repository string) ([]*elastic.Snapshot, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	res, err := client.SnapshotGet(repository).Do(ctx)
+	if err != nil {
+		log.Errorf("ES list snapshots error: %v", err)
+		return nil, err
+	}
+
+	return res.Snapshots, nil
+}
+
+// DeleteSnapshot 删除快照
+func (c *Client) DeleteSnapshot(repository, snapshot string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if _, err := client.SnapshotDelete(repository, snapshot).Do(ctx); err != nil {
+		log.Errorf("ES delete snapshot error: %v", err)
+		return err
+	}
+
+	log.Infof("ES delete snapshot ok: %v/%v", repository, snapshot)
+	return nil
+}
+
+// RestoreSnapshot 从快照恢复索引
+// 恢复的目标索引已存在且处于打开状态时会失败，需要先删除、关闭或通过 RenamePattern 恢复到新的索引名
+func (c *Client) RestoreSnapshot(repository, snapshot string, opts RestoreOptions) (*elastic.RestoreInfo, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	svc := client.SnapshotRestore(repository, snapshot).
+		Indices(opts.Indices...).
+		IncludeGlobalState(false).
+		IncludeAliases(opts.IncludeAliases).
+		WaitForCompletion(opts.WaitForCompletion)
+	if opts.RenamePattern != "" {
+		svc = svc.RenamePattern(opts.RenamePattern).RenameReplacement(opts.RenameReplacement)
+	}
+	if len(opts.IndexSettings) > 0 {
+		svc = svc.IndexSettings(opts.IndexSettings)
+	}
+
+	ctx := context.Background()
+	res, err := svc.Do(ctx)
+	if err != nil {
+		log.Errorf("ES restore snapshot error: %v", err)
+		return nil, err
+	}
+
+	if res.Snapshot != nil {
+		log.Infof("ES restore snapshot ok: %v/%v %v", repository, snapshot, res.Snapshot.Indices)
+	}
+	return res.Snapshot, nil
+}
+
+// BackupIndices 在 UpdateESIndex、RecreateIndex 等操作前备份索引
+// 快照名为 prefix 加上当前时间，例如 tenant1-20200102150405，等待快照结束后返回快照名
+func (c *Client) BackupIndices(repository, prefix string, indices ...string) (string, error) {
+	snapshot := strings.ToLower(prefix) + "-" + time.Now().Format(snapshotTimeFormat)
+	if _, err := c.CreateSnapshot(repository, snapshot, indices, true); err != nil {
+		return "", err
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,65 @@
+package es
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	seed(t, "tenant_a", map[string]map[string]interface{}{
+		"1": {"name": "a"},
+		"2": {"name": "b"},
+	})
+	if err := RegisterFSRepository("backup", "/mount/backups", map[string]interface{}{"compress": true}); err != nil {
+		t.Fatalf("RegisterFSRepository() error = %v", err)
+	}
+
+	snapshot, err := BackupIndices("backup", "Tenant_A", "tenant_a")
+	if err != nil {
+		t.Fatalf("BackupIndices() error = %v", err)
+	}
+	if !strings.HasPrefix(snapshot, "tenant_a-") {
+		t.Errorf("BackupIndices() = %v, want lowercase name with prefix", snapshot)
+	}
+
+	snaps, err := ListSnapshots("backup")
+	if err != nil {
+		t.Fatalf("ListSnapshots() error = %v", err)
+	}
+	if len(snaps) != 1 || snaps[0].Snapshot != snapshot || !reflect.DeepEqual(snaps[0].Indices, []string{"tenant_a"}) {
+		t.Errorf("ListSnapshots() = %+v", snaps)
+	}
+
+	// 快照后的修改不影响恢复的内容
+	if err := ESUpdate("tenant_a", "1", map[string]interface{}{"name": "changed"}); err != nil {
+		t.Fatalf("ESUpdate() error = %v", err)
+	}
+
+	// 原索引存在时不能直接恢复
+	if _, err := RestoreSnapshot("backup", snapshot, RestoreOptions{WaitForCompletion: true}); err == nil {
+		t.Errorf("RestoreSnapshot() into existing index should fail")
+	}
+
+	info, err := RestoreSnapshot("backup", snapshot, RestoreOptions{
+		RenamePattern:     "(.+)",
+		RenameReplacement: "restored_$1",
+		WaitForCompletion: true,
+	})
+	if err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	if info == nil || !reflect.DeepEqual(info.Indices, []string{"restored_tenant_a"}) {
+		t.Errorf("RestoreSnapshot() = %+v", info)
+	}
+	if got := srv.Source("restored_tenant_a", "1"); got == nil || got["name"] != "a" {
+		t.Errorf("restored doc = %v, want name a", got)
+	}
+
+	if err := DeleteSnapshot("backup", snapshot); err != nil {
+		t.Fatalf("DeleteSnapshot() error = %v", err)
+	}
+	if snap, err := GetSnapshot("backup", snapshot); err != nil || snap != nil {
+		t.Errorf("GetSnapshot() after delete = %v, %v", snap, err)
+	}
+}
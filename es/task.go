@@ -0,0 +1,325 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+type (
+	// TaskProgress reindex、update_by_query、delete_by_query 任务的进度
+	TaskProgress struct {
+		Total             int64   `json:"total"`
+		Created           int64   `json:"created"`
+		Updated           int64   `json:"updated"`
+		Deleted           int64   `json:"deleted"`
+		Batches           int64   `json:"batches"`
+		VersionConflicts  int64   `json:"version_conflicts"`
+		Noops             int64   `json:"noops"`
+		RequestsPerSecond float64 `json:"requests_per_second"`
+		Canceled          string  `json:"canceled"` // 被取消时的原因
+		// Slices 切片执行时各切片的进度
+		Slices []TaskProgress `json:"slices"`
+	}
+
+	// ByQueryOptions 异步 update_by_query、delete_by_query 的配置
+	ByQueryOptions struct {
+		Slices            int  // 并行的切片数，0 或 1 表示不切片，-1 表示由 ES 按分片数决定
+		RequestsPerSecond int  // 限流，0 表示不限流，执行中可通过 RethrottleTask 调整
+		BatchSize         int  // 每批处理的文档数，0 时使用 ES 的默认值
+		ProceedOnConflict bool // 遇到版本冲突时继续执行，冲突数记录在 VersionConflicts
+	}
+
+	// TaskStatus 任务的状态
+	TaskStatus struct {
+		TaskID    string                // 任务ID
+		Completed bool                  // 是否已结束
+		Progress  TaskProgress          // 当前进度，结束后为最终结果
+		Failures  []json.RawMessage     // 结束后的失败明细
+		Error     *elastic.ErrorDetails // 任务本身的错误
+	}
+
+	// taskResponse GET _tasks/{id} 的返回
+	taskResponse struct {
+		Completed bool `json:"completed"`
+		Task      struct {
+			Status TaskProgress `json:"status"`
+		} `json:"task"`
+		Response *struct {
+			TaskProgress
+			Failures []json.RawMessage `json:"failures"`
+		} `json:"response"`
+		Error *elastic.ErrorDetails `json:"error"`
+	}
+)
+
+// ErrTaskCanceled 任务被取消
+var ErrTaskCanceled = errors.New("es task canceled")
+
+// DefaultTaskPollInterval WaitForTask 的 interval 不大于 0 时使用的轮询间隔
+const DefaultTaskPollInterval = time.Second
+
+// Done 完成的文档数
+func (p TaskProgress) Done() int64 {
+	return p.Created + p.Updated + p.Deleted + p.Noops + p.VersionConflicts
+}
+
+// Percent 完成的百分比，总数未知时返回 0
+func (p TaskProgress) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return float64(p.Done()) * 100 / float64(p.Total)
+}
+
+// Canceled 任务是否被取消
+func (s *TaskStatus) Canceled() bool {
+	return s.Progress.Canceled != ""
+}
+
+// Failed 任务是否失败，包括部分文档失败
+func (s *TaskStatus) Failed() bool {
+	return s.Error != nil || len(s.Failures) > 0
+}
+
+// GetTask 获取任务状态
+func (c *Client) GetTask(taskID string) (*TaskStatus, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	return getTask(context.Background(), client, taskID)
+}
+
+func getTask(ctx context.Context, client *elastic.Client, taskID string) (*TaskStatus, error) {
+	res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_tasks/" + url.PathEscape(taskID),
+	})
+	if err != nil {
+		log.Errorf("ES get task error: %v", err)
+		return nil, err
+	}
+
+	var tr taskResponse
+	if err := json.Unmarshal(res.Body, &tr); err != nil {
+		return nil, err
+	}
+
+	status := &TaskStatus{
+		TaskID:    taskID,
+		Completed: tr.Completed,
+		Progress:  tr.Task.Status,
+		Error:     tr.Error,
+	}
+	if tr.Response != nil {
+		status.Progress = tr.Response.TaskProgress
+		status.Failures = tr.Response.Failures
+	}
+
+	return status, nil
+}
+
+// WaitForTask 轮询任务直到结束，每次轮询后调用 onProgress（可为空）
+// interval 不大于 0 时使用 DefaultTaskPollInterval
+func (c *Client) WaitForTask(ctx context.Context, taskID string, interval time.Duration, onProgress func(*TaskStatus)) (*TaskStatus, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	return waitForTask(ctx, client, taskID, interval, onProgress)
+}
+
+func waitForTask(ctx context.Context, client *elastic.Client, taskID string, interval time.Duration, onProgress func(*TaskStatus)) (*TaskStatus, error) {
+	if interval <= 0 {
+		interval = DefaultTaskPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		status, err := getTask(ctx, client, taskID)
+		if err != nil {
+			return nil, err
+		}
+
+		if onProgress != nil {
+			onProgress(status)
+		}
+
+		if status.Completed {
+			if status.Error != nil {
+				return status, fmt.Errorf("es task %s failed: %s", taskID, status.Error.Reason)
+			}
+			if status.Canceled() {
+				return status, fmt.Errorf("%w: %s %s", ErrTaskCanceled, taskID, status.Progress.Canceled)
+			}
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecreateIndexAsync 异步执行 RecreateIndex，立即返回任务ID，通过 GetTask 或 WaitForTask 跟踪进度
+func (c *Client) RecreateIndexAsync(indexName string, script *elastic.Script, query elastic.Query, opts ByQueryOptions) (string, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return "", err
+	}
+
+	svc := client.UpdateByQuery(indexName).
+		Script(script).
+		Query(query).
+		WaitForCompletion(false)
+	if slices := opts.slices(); slices != nil {
+		svc = svc.Slices(slices)
+	}
+	if opts.RequestsPerSecond > 0 {
+		svc = svc.RequestsPerSecond(opts.RequestsPerSecond)
+	}
+	if opts.BatchSize > 0 {
+		svc = svc.ScrollSize(opts.BatchSize)
+	}
+	if opts.ProceedOnConflict {
+		svc = svc.ProceedOnVersionConflict()
+	}
+
+	ctx := context.Background()
+	task, err := svc.DoAsync(ctx)
+	if err != nil {
+		log.Errorf("ES start update by query error: %v", err)
+		return "", err
+	}
+
+	log.Infof("ES start update by query ok: %v task %v", indexName, task.TaskId)
+	return task.TaskId, nil
+}
+
+// DeleteByQueryAsync 异步删除满足条件的文档，立即返回任务ID
+func (c *Client) DeleteByQueryAsync(indexName string, query elastic.Query, opts ByQueryOptions) (string, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return "", err
+	}
+
+	svc := client.DeleteByQuery(indexName).
+		Query(query).
+		WaitForCompletion(false)
+	if slices := opts.slices(); slices != nil {
+		svc = svc.Slices(slices)
+	}
+	if opts.RequestsPerSecond > 0 {
+		svc = svc.RequestsPerSecond(opts.RequestsPerSecond)
+	}
+	if opts.BatchSize > 0 {
+		svc = svc.ScrollSize(opts.BatchSize)
+	}
+	if opts.ProceedOnConflict {
+		svc = svc.ProceedOnVersionConflict()
+	}
+
+	ctx := context.Background()
+	task, err := svc.DoAsync(ctx)
+	if err != nil {
+		log.Errorf("ES start delete by query error: %v", err)
+		return "", err
+	}
+
+	log.Infof("ES start delete by query ok: %v task %v", indexName, task.TaskId)
+	return task.TaskId, nil
+}
+
+// ESDeleteAllAsync 异步执行 ESDeleteAll，立即返回任务ID
+func (c *Client) ESDeleteAllAsync(indexName string, opts ByQueryOptions) (string, error) {
+	return c.DeleteByQueryAsync(indexName, elastic.NewMatchAllQuery(), opts)
+}
+
+// CancelTask 取消任务，已处理的文档不会回滚
+// 取消是异步的，任务结束后 GetTask 返回的 Canceled 为 true
+func (c *Client) CancelTask(taskID string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	res, err := client.TasksCancel().TaskId(taskID).Do(ctx)
+	if err != nil {
+		log.Errorf("ES cancel task error: %v", err)
+		return err
+	}
+
+	return taskFailure(taskID, res)
+}
+
+// RethrottleTask 调整执行中的 reindex、update_by_query、delete_by_query 任务的限流，
+// requestsPerSecond 为 0 时取消限流
+func (c *Client) RethrottleTask(taskID string, requestsPerSecond int) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	rps := "-1"
+	if requestsPerSecond > 0 {
+		rps = strconv.Itoa(requestsPerSecond)
+	}
+
+	// 三种任务的 _rethrottle 在 ES 中由同一个处理执行，这里统一使用 _update_by_query 的路径
+	ctx := context.Background()
+	res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   "/_update_by_query/" + url.PathEscape(taskID) + "/_rethrottle",
+		Params: url.Values{"requests_per_second": []string{rps}},
+	})
+	if err != nil {
+		log.Errorf("ES rethrottle task error: %v", err)
+		return err
+	}
+
+	var tr elastic.TasksListResponse
+	if err := json.Unmarshal(res.Body, &tr); err != nil {
+		return err
+	}
+
+	return taskFailure(taskID, &tr)
+}
+
+// slices 转换为 ES 的 slices 参数，不切片时返回 nil
+func (o ByQueryOptions) slices() interface{} {
+	switch {
+	case o.Slices < 0:
+		return "auto"
+	case o.Slices > 1:
+		return o.Slices
+	}
+	return nil
+}
+
+// taskFailure 取消、限流的返回中包含失败时转换为错误
+func taskFailure(taskID string, res *elastic.TasksListResponse) error {
+	for _, f := range res.TaskFailures {
+		if f.Reason != nil {
+			return fmt.Errorf("es task %s: %s", taskID, f.Reason.Reason)
+		}
+	}
+	for _, f := range res.NodeFailures {
+		if f.ErrorDetails != nil {
+			return fmt.Errorf("es task %s: %s", taskID, f.Reason)
+		}
+	}
+	return nil
+}
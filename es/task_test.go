@@ -0,0 +1,85 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+func TestDeleteAllAsync(t *testing.T) {
+	seed(t, "task1", map[string]map[string]interface{}{
+		"1": {"name": "a"},
+		"2": {"name": "b"},
+		"3": {"name": "c"},
+	})
+
+	taskID, err := ESDeleteAllAsync("task1", ByQueryOptions{Slices: 2, RequestsPerSecond: 500})
+	if err != nil {
+		t.Fatalf("ESDeleteAllAsync() error = %v", err)
+	}
+
+	status, err := WaitForTask(context.Background(), taskID, 10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("WaitForTask() error = %v", err)
+	}
+	p := status.Progress
+	if p.Deleted != 3 || p.Percent() != 100 || len(p.Slices) != 2 || p.RequestsPerSecond != 500 {
+		t.Errorf("WaitForTask() progress = %+v", p)
+	}
+	if srv.Source("task1", "1") != nil {
+		t.Errorf("doc 1 should be deleted")
+	}
+
+	// interval 为零时使用默认值
+	if _, err := WaitForTask(context.Background(), taskID, 0, nil); err != nil {
+		t.Errorf("WaitForTask() zero interval error = %v", err)
+	}
+}
+
+func TestCancelAndRethrottleTask(t *testing.T) {
+	seed(t, "task2", map[string]map[string]interface{}{
+		"1": {"name": "a"},
+		"2": {"name": "b"},
+	})
+	srv.HoldTasks(true)
+
+	script := elastic.NewScript("ctx._source.name = params.name").Param("name", "x")
+	taskID, err := RecreateIndexAsync("task2", script, elastic.NewMatchAllQuery(), ByQueryOptions{})
+	if err != nil {
+		t.Fatalf("RecreateIndexAsync() error = %v", err)
+	}
+
+	status, err := GetTask(taskID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if status.Completed || status.Progress.Total != 2 || status.Progress.Percent() != 0 {
+		t.Errorf("GetTask() running = %+v", status)
+	}
+
+	if err := RethrottleTask(taskID, 100); err != nil {
+		t.Fatalf("RethrottleTask() error = %v", err)
+	}
+	if status, _ := GetTask(taskID); status.Progress.RequestsPerSecond != 100 {
+		t.Errorf("RequestsPerSecond = %v, want 100", status.Progress.RequestsPerSecond)
+	}
+
+	if err := CancelTask(taskID); err != nil {
+		t.Fatalf("CancelTask() error = %v", err)
+	}
+	status, err = WaitForTask(context.Background(), taskID, 10*time.Millisecond, nil)
+	if !errors.Is(err, ErrTaskCanceled) || !status.Canceled() {
+		t.Errorf("WaitForTask() = %+v, %v, want ErrTaskCanceled", status, err)
+	}
+	if got := srv.Source("task2", "1"); got["name"] != "a" {
+		t.Errorf("canceled task should not update doc: %v", got)
+	}
+
+	// 结束的任务不能再调整限流
+	if err := RethrottleTask(taskID, 10); err == nil {
+		t.Errorf("RethrottleTask() on finished task should fail")
+	}
+}
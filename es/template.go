@@ -0,0 +1,175 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+type (
+	// IndexTemplate 组合索引模板，新建的索引名匹配 IndexPatterns 时自动应用
+	IndexTemplate struct {
+		IndexPatterns []string               `json:"index_patterns"`
+		ComposedOf    []string               `json:"composed_of,omitempty"` // 引用的组件模板，按顺序合并
+		Priority      int                    `json:"priority,omitempty"`    // 多个模板匹配时优先级高的生效
+		Version       int                    `json:"version,omitempty"`
+		Template      *TemplateBody          `json:"template,omitempty"`
+		Meta          map[string]interface{} `json:"_meta,omitempty"`
+	}
+
+	// TemplateBody 模板中的索引设置、mapping 和别名
+	TemplateBody struct {
+		Settings map[string]interface{} `json:"settings,omitempty"`
+		Mappings map[string]interface{} `json:"mappings,omitempty"`
+		Aliases  map[string]interface{} `json:"aliases,omitempty"`
+	}
+
+	// ComponentTemplate 组件模板，可被多个索引模板复用
+	ComponentTemplate struct {
+		Template TemplateBody           `json:"template"`
+		Version  int                    `json:"version,omitempty"`
+		Meta     map[string]interface{} `json:"_meta,omitempty"`
+	}
+)
+
+// PutIndexTemplate 创建或更新组合索引模板
+func (c *Client) PutIndexTemplate(name string, tpl IndexTemplate) error {
+	return c.putTemplate("/_index_template/", name, tpl)
+}
+
+// GetIndexTemplate 获取组合索引模板，不存在时返回 nil
+func (c *Client) GetIndexTemplate(name string) (*IndexTemplate, error) {
+	var res struct {
+		IndexTemplates []struct {
+			Name          string        `json:"name"`
+			IndexTemplate IndexTemplate `json:"index_template"`
+		} `json:"index_templates"`
+	}
+	found, err := c.getTemplate("/_index_template/", name, &res)
+	if err != nil || !found || len(res.IndexTemplates) == 0 {
+		return nil, err
+	}
+
+	return &res.IndexTemplates[0].IndexTemplate, nil
+}
+
+// ExistsIndexTemplate 判断组合索引模板是否存在
+func (c *Client) ExistsIndexTemplate(name string) (bool, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return false, err
+	}
+
+	ctx := context.Background()
+	res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method:       "HEAD",
+		Path:         "/_index_template/" + url.PathEscape(name),
+		IgnoreErrors: []int{http.StatusNotFound},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return res.StatusCode == http.StatusOK, nil
+}
+
+// DeleteIndexTemplate 删除组合索引模板
+func (c *Client) DeleteIndexTemplate(name string) error {
+	return c.deleteTemplate("/_index_template/", name)
+}
+
+// PutComponentTemplate 创建或更新组件模板
+func (c *Client) PutComponentTemplate(name string, tpl ComponentTemplate) error {
+	return c.putTemplate("/_component_template/", name, tpl)
+}
+
+// GetComponentTemplate 获取组件模板，不存在时返回 nil
+func (c *Client) GetComponentTemplate(name string) (*ComponentTemplate, error) {
+	var res struct {
+		ComponentTemplates []struct {
+			Name              string            `json:"name"`
+			ComponentTemplate ComponentTemplate `json:"component_template"`
+		} `json:"component_templates"`
+	}
+	found, err := c.getTemplate("/_component_template/", name, &res)
+	if err != nil || !found || len(res.ComponentTemplates) == 0 {
+		return nil, err
+	}
+
+	return &res.ComponentTemplates[0].ComponentTemplate, nil
+}
+
+// DeleteComponentTemplate 删除组件模板
+func (c *Client) DeleteComponentTemplate(name string) error {
+	return c.deleteTemplate("/_component_template/", name)
+}
+
+func (c *Client) putTemplate(path, name string, body interface{}) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "PUT",
+		Path:   path + url.PathEscape(name),
+		Body:   body,
+	})
+	if err != nil {
+		log.Errorf("ES put template %s error: %v", name, err)
+		return err
+	}
+
+	log.Infof("ES put template ok: %v", name)
+	return nil
+}
+
+func (c *Client) getTemplate(path, name string, result interface{}) (bool, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return false, err
+	}
+
+	ctx := context.Background()
+	res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method:       "GET",
+		Path:         path + url.PathEscape(name),
+		IgnoreErrors: []int{http.StatusNotFound},
+	})
+	if err != nil {
+		log.Errorf("ES get template %s error: %v", name, err)
+		return false, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(res.Body, result); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Client) deleteTemplate(path, name string) error {
+	client, err := c.Elastic()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "DELETE",
+		Path:   path + url.PathEscape(name),
+	})
+	if err != nil {
+		log.Errorf("ES delete template %s error: %v", name, err)
+		return err
+	}
+
+	log.Infof("ES delete template ok: %v", name)
+	return nil
+}
@@ -0,0 +1,70 @@
+package es
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexTemplate(t *testing.T) {
+	seed(t, "lease", nil)
+
+	base := ComponentTemplate{
+		Template: TemplateBody{
+			Settings: map[string]interface{}{"number_of_shards": float64(1)},
+		},
+		Version: 2,
+	}
+	if err := PutComponentTemplate("base", base); err != nil {
+		t.Fatalf("PutComponentTemplate() error = %v", err)
+	}
+	gotBase, err := GetComponentTemplate("base")
+	if err != nil {
+		t.Fatalf("GetComponentTemplate() error = %v", err)
+	}
+	if gotBase == nil || !reflect.DeepEqual(*gotBase, base) {
+		t.Errorf("GetComponentTemplate() = %+v, want %+v", gotBase, base)
+	}
+
+	tpl := IndexTemplate{
+		IndexPatterns: []string{"logs-*"},
+		ComposedOf:    []string{"base"},
+		Priority:      10,
+		Template: &TemplateBody{
+			Settings: LifecycleSettings("logs", "logs"),
+			Aliases:  map[string]interface{}{"logs_all": map[string]interface{}{}},
+		},
+	}
+	if err := PutIndexTemplate("logs", tpl); err != nil {
+		t.Fatalf("PutIndexTemplate() error = %v", err)
+	}
+	if ok, err := ExistsIndexTemplate("logs"); err != nil || !ok {
+		t.Errorf("ExistsIndexTemplate() = %v, %v", ok, err)
+	}
+	got, err := GetIndexTemplate("logs")
+	if err != nil {
+		t.Fatalf("GetIndexTemplate() error = %v", err)
+	}
+	if got == nil || !reflect.DeepEqual(*got, tpl) {
+		t.Errorf("GetIndexTemplate() = %+v, want %+v", got, tpl)
+	}
+
+	if err := DeleteIndexTemplate("logs"); err != nil {
+		t.Fatalf("DeleteIndexTemplate() error = %v", err)
+	}
+	if ok, err := ExistsIndexTemplate("logs"); err != nil || ok {
+		t.Errorf("ExistsIndexTemplate() after delete = %v, %v", ok, err)
+	}
+	if got, err := GetIndexTemplate("logs"); err != nil || got != nil {
+		t.Errorf("GetIndexTemplate() after delete = %v, %v", got, err)
+	}
+	if err := DeleteIndexTemplate("logs"); err == nil {
+		t.Error("DeleteIndexTemplate() missing template should fail")
+	}
+
+	if err := DeleteComponentTemplate("base"); err != nil {
+		t.Fatalf("DeleteComponentTemplate() error = %v", err)
+	}
+	if got, err := GetComponentTemplate("base"); err != nil || got != nil {
+		t.Errorf("GetComponentTemplate() after delete = %v, %v", got, err)
+	}
+}
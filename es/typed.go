@@ -0,0 +1,192 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+type (
+	// SearchRequest 检索条件
+	SearchRequest struct {
+		Query       elastic.Query      // 检索条件，为空时检索全部
+		Sort        string             // 排序字段，为空时按相关度排序
+		Ascending   bool               // 是否升序
+		Sorts       []SortField        // 多字段排序，优先于 Sort
+		From        int                // 开始位置，使用 SearchAfter 时忽略
+		Size        int                // 取得件数
+		Highlight   *elastic.Highlight // 高亮设置，可为空
+		SearchAfter []interface{}      // 上一页最后一条的排序值
+		PointInTime *PointInTime       // 时间点，设置后检索不再指定索引
+	}
+
+	// Hit 单个命中文档的附加信息，与解码后的结果按下标一一对应
+	Hit struct {
+		ID        string              // 文档ID
+		Index     string              // 所在索引
+		Score     *float64            // 相关度
+		Highlight map[string][]string // 高亮片段
+		Sort      []interface{}       // 排序值，可用于 search_after
+	}
+
+	// SearchMeta 检索结果的元信息
+	SearchMeta struct {
+		TotalHits    int64  // 命中总件数
+		TookInMillis int64  // 检索耗时
+		Hits         []Hit  // 命中文档的附加信息
+		PitID        string // 最新的时间点ID，下一页需使用该值
+	}
+)
+
+var (
+	// ErrInvalidResult 解码目标不是切片指针
+	ErrInvalidResult = errors.New("result argument must be a pointer to a slice")
+)
+
+// ESGetInto 获取单个文档并解码到 result，文档不存在时返回 false
+func (c *Client) ESGetInto(indexName string, id string, result interface{}) (bool, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return false, err
+	}
+
+	ctx := context.Background()
+	doc, err := client.Get().
+		Index(indexName).
+		Id(id).
+		Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return false, nil
+		}
+		log.Errorf("ES got document error: %v", err)
+		return false, err
+	}
+
+	if !doc.Found {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(doc.Source, result); err != nil {
+		log.Errorf("ES decode document error: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ESSearchInto 检索并把命中的文档解码到 result
+// result 必须是切片的指针，元素可以是结构体、结构体指针或 map
+//
+//	var leases []Lease
+//	meta, err := es.ESSearchInto("lease", es.SearchRequest{
+//	    Query: elastic.NewTermQuery("status", "active"),
+//	    Size:  20,
+//	}, &leases)
+func (c *Client) ESSearchInto(indexName string, req SearchRequest, result interface{}) (*SearchMeta, error) {
+	client, err := c.Elastic()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	var searchResult *elastic.SearchResult
+	var pitID string
+	if req.PointInTime != nil {
+		searchResult, pitID, err = searchWithPointInTime(ctx, client, req)
+	} else {
+		searchResult, err = client.Search().
+			Index(indexName).
+			SearchSource(buildSearchSource(req)).
+			Do(ctx)
+	}
+	if err != nil {
+		log.Errorf("ES search error: %v", err)
+		return nil, err
+	}
+
+	meta, err := decodeHits(searchResult, result)
+	if err != nil {
+		log.Errorf("ES decode search result error: %v", err)
+		return nil, err
+	}
+
+	meta.PitID = pitID
+	log.Infof("ES search took %d milliseconds\n", searchResult.TookInMillis)
+	return meta, nil
+}
+
+// buildSearchSource 根据检索条件生成请求体
+func buildSearchSource(req SearchRequest) *elastic.SearchSource {
+	source := elastic.NewSearchSource()
+	if req.Query != nil {
+		source = source.Query(req.Query)
+	}
+	if len(req.Sorts) > 0 {
+		source = source.SortBy(sorters(req.Sorts)...)
+	} else if req.Sort != "" {
+		source = source.Sort(req.Sort, req.Ascending)
+	}
+	if req.Highlight != nil {
+		source = source.Highlight(req.Highlight)
+	}
+	if req.Size > 0 {
+		source = source.Size(req.Size)
+	}
+	if len(req.SearchAfter) > 0 {
+		source = source.SearchAfter(req.SearchAfter...)
+	} else if req.From > 0 {
+		source = source.From(req.From)
+	}
+
+	return source
+}
+
+// DecodeSearchResult 把已有的检索结果解码到 result，用于 ESSearch 等返回原始结果的接口
+func DecodeSearchResult(searchResult *elastic.SearchResult, result interface{}) (*SearchMeta, error) {
+	return decodeHits(searchResult, result)
+}
+
+// decodeHits 逐个解码命中的文档
+func decodeHits(searchResult *elastic.SearchResult, result interface{}) (*SearchMeta, error) {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return nil, ErrInvalidResult
+	}
+
+	meta := &SearchMeta{
+		TotalHits:    searchResult.TotalHits(),
+		TookInMillis: searchResult.TookInMillis,
+	}
+
+	slice := rv.Elem()
+	elemType := slice.Type().Elem()
+	items := reflect.MakeSlice(slice.Type(), 0, 0)
+
+	if searchResult.Hits != nil {
+		items = reflect.MakeSlice(slice.Type(), 0, len(searchResult.Hits.Hits))
+		for _, hit := range searchResult.Hits.Hits {
+			elem := reflect.New(elemType)
+			if err := json.Unmarshal(hit.Source, elem.Interface()); err != nil {
+				return nil, fmt.Errorf("decode document %s: %w", hit.Id, err)
+			}
+			items = reflect.Append(items, elem.Elem())
+
+			meta.Hits = append(meta.Hits, Hit{
+				ID:        hit.Id,
+				Index:     hit.Index,
+				Score:     hit.Score,
+				Highlight: hit.Highlight,
+				Sort:      hit.Sort,
+			})
+		}
+	}
+
+	slice.Set(items)
+	return meta, nil
+}
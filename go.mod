@@ -8,7 +8,6 @@ require (
 	github.com/antonfisher/nested-logrus-formatter v1.3.0
 	github.com/dimchansky/utfbom v1.1.0
 	github.com/garyburd/redigo v1.6.2
-	github.com/globalsign/mgo v0.0.0-20181015135952-eeefdecb41b8
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/google/uuid v1.1.2
 	github.com/joho/godotenv v1.3.0
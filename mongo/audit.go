@@ -0,0 +1,435 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// AuditSink 审计记录的保存位置，MongoAuditSink 保存到集合，mongosync.ESAuditSink 保存到 ES
+	AuditSink interface {
+		// Write 保存一条审计记录
+		Write(ctx context.Context, entry *AuditEntry) error
+		// History 获取一个文档的变更历史，按时间从新到旧，limit 为 0 时不限制
+		History(ctx context.Context, database, collection string, id interface{}, limit int64) ([]AuditEntry, error)
+	}
+
+	// AuditEntry 一次写入的审计记录
+	AuditEntry struct {
+		Database   string        `bson:"database" json:"database"`
+		Collection string        `bson:"collection" json:"collection"`
+		DocumentID interface{}   `bson:"document_id" json:"document_id"`
+		Action     string        `bson:"action" json:"action"` // insert、update、delete
+		Actor      string        `bson:"actor" json:"actor"`   // 操作者，来自 WithActor，没有时为空
+		At         time.Time     `bson:"at" json:"at"`
+		Before     bson.M        `bson:"before,omitempty" json:"before,omitempty"` // 写入前的文档，插入时为空
+		After      bson.M        `bson:"after,omitempty" json:"after,omitempty"`   // 写入后的文档，删除时为空
+		Changes    []FieldChange `bson:"changes,omitempty" json:"changes,omitempty"`
+	}
+
+	// FieldChange 一个顶层字段的变更，嵌套的文档和数组整体比较
+	FieldChange struct {
+		Field  string      `bson:"field" json:"field"`
+		Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+		After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+	}
+
+	// MongoAuditSink 把审计记录保存到 mongodb 的集合
+	// 与数据在同一个集群时，在 WithTransaction 中写入的审计记录随事务一起提交或回滚
+	MongoAuditSink struct {
+		db         string
+		collection string
+	}
+
+	// actorKey context 中保存操作者的键
+	actorKey struct{}
+)
+
+// 审计记录的操作
+const (
+	AuditInsert = "insert"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// AuditCollection 默认的审计记录集合名
+const AuditCollection = "_audit"
+
+var (
+	// ErrAuditDisabled 仓库没有设置 AuditSink
+	ErrAuditDisabled = errors.New("audit is not enabled for this repository")
+	// ErrAuditFailed 数据已写入但审计记录保存失败，在事务中时应回滚
+	ErrAuditFailed = errors.New("audit record failed")
+)
+
+// WithActor 在 context 中设置操作者，例如用户ID
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext 获取 context 中的操作者
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	return actor, ok && actor != ""
+}
+
+// NewMongoAuditSink 创建保存到集合的审计，db 为空时使用 Start 配置的数据库，collection 为空时为 AuditCollection
+func NewMongoAuditSink(db, collection string) *MongoAuditSink {
+	if collection == "" {
+		collection = AuditCollection
+	}
+	return &MongoAuditSink{db: db, collection: collection}
+}
+
+// AuditIndexes 审计集合需要的索引，可以传给 SyncIndexes
+func AuditIndexes() []IndexSpec {
+	return []IndexSpec{
+		{Keys: bson.D{
+			{Key: "database", Value: 1},
+			{Key: "collection", Value: 1},
+			{Key: "document_id", Value: 1},
+			{Key: "at", Value: -1},
+		}},
+	}
+}
+
+// Write 保存一条审计记录
+func (s *MongoAuditSink) Write(ctx context.Context, entry *AuditEntry) error {
+	c, err := NewRepository(s.db, s.collection).Collection()
+	if err != nil {
+		return err
+	}
+	_, err = c.InsertOne(ctx, entry)
+	return err
+}
+
+// History 获取一个文档的变更历史，按时间从新到旧
+func (s *MongoAuditSink) History(ctx context.Context, database, collection string, id interface{}, limit int64) ([]AuditEntry, error) {
+	c, err := NewRepository(s.db, s.collection).Collection()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.D{
+		{Key: "database", Value: database},
+		{Key: "collection", Value: collection},
+		{Key: "document_id", Value: id},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cur, err := c.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	if err := cur.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// NewAuditEntry 生成审计记录，before、after 为文档（结构体、bson.M 等），操作者来自 ctx
+// after 中没有 _id 时使用 id，例如插入没有 _id 字段的结构体
+func NewAuditEntry(ctx context.Context, database, collection, action string, id, before, after interface{}) (*AuditEntry, error) {
+	entry := &AuditEntry{
+		Database:   database,
+		Collection: collection,
+		DocumentID: id,
+		Action:     action,
+		At:         time.Now().UTC(),
+	}
+	entry.Actor, _ = ActorFromContext(ctx)
+
+	var err error
+	if entry.Before, err = toDocument(before); err != nil {
+		return nil, err
+	}
+	if entry.After, err = toDocument(after); err != nil {
+		return nil, err
+	}
+	if entry.After != nil {
+		if _, ok := entry.After["_id"]; !ok {
+			entry.After["_id"] = id
+		}
+	}
+	entry.Changes = diffDocuments(entry.Before, entry.After)
+	return entry, nil
+}
+
+// RecordAudit 生成并保存审计记录，失败时返回 ErrAuditFailed 包装的错误
+// update 前后没有变化时不保存
+func RecordAudit(ctx context.Context, sink AuditSink, database, collection, action string, id, before, after interface{}) error {
+	entry, err := NewAuditEntry(ctx, database, collection, action, id, before, after)
+	if err == nil {
+		if action == AuditUpdate && len(entry.Changes) == 0 {
+			return nil
+		}
+		err = sink.Write(ctx, entry)
+	}
+	if err != nil {
+		log.Errorf("error RecordAudit %v collection: %v id: %v", err, collection, id)
+		return fmt.Errorf("%w: %v", ErrAuditFailed, err)
+	}
+	return nil
+}
+
+// WithAudit 返回记录审计的仓库，写入方法会先读取写入前的文档，适合需要变更历史的集合
+// UpdateMany、DeleteMany 按写入前读取的文档记录，与并发的写入之间不是原子的
+func (r *Repository) WithAudit(sink AuditSink) *Repository {
+	return &Repository{db: r.db, handle: r.handle, name: r.name, audit: sink}
+}
+
+// History 获取文档的变更历史，按时间从新到旧，limit 为 0 时不限制
+func (r *Repository) History(ctx context.Context, id interface{}, limit int64) ([]AuditEntry, error) {
+	if r.audit == nil {
+		return nil, ErrAuditDisabled
+	}
+	return r.audit.History(ctx, r.Database(), r.name, id, limit)
+}
+
+// plain 不记录审计、从 primary 读取的仓库，用于执行实际的写入和读取写入前后的文档
+// 连接默认 secondaryPreferred，从延迟的 secondary 读取写入后的文档会得到写入前的内容，审计记录会遗漏或出错
+func (r *Repository) plain() *Repository {
+	return &Repository{db: r.db, handle: r.handle, name: r.name, primary: true}
+}
+
+// record 保存审计记录
+func (r *Repository) record(ctx context.Context, action string, id, before, after interface{}) error {
+	return RecordAudit(ctx, r.audit, r.Database(), r.name, action, id, before, after)
+}
+
+// snapshot 读取一条文档，不存在时返回 nil
+func (r *Repository) snapshot(ctx context.Context, filter interface{}) (bson.M, error) {
+	var doc bson.M
+	if err := r.plain().FindOne(ctx, filter, &doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return doc, nil
+}
+
+// snapshots 读取满足条件的所有文档
+func (r *Repository) snapshots(ctx context.Context, filter interface{}) ([]bson.M, error) {
+	var docs []bson.M
+	if err := r.plain().Find(ctx, filter, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (r *Repository) auditedInsert(ctx context.Context, docs []interface{}) ([]interface{}, error) {
+	ids, err := r.plain().Insert(ctx, docs...)
+	if err != nil {
+		return ids, err
+	}
+	for i, id := range ids {
+		if err := r.record(ctx, AuditInsert, id, nil, docs[i]); err != nil {
+			return ids, err
+		}
+	}
+	return ids, nil
+}
+
+func (r *Repository) auditedUpdate(ctx context.Context, filter, update interface{}, opts []*options.UpdateOptions) (int64, error) {
+	before, err := r.snapshot(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	if before == nil {
+		// 没有匹配的文档，opts 指定了 upsert 时会插入
+		res, err := r.updateOne(ctx, filter, update, opts)
+		if err != nil {
+			return 0, err
+		}
+		return res.MatchedCount, r.recordUpserted(ctx, res)
+	}
+
+	id := before["_id"]
+	n, err := r.plain().Update(ctx, ByID(filter, id), update, opts...)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	after, err := r.snapshot(ctx, bson.M{"_id": id})
+	if err != nil {
+		return n, err
+	}
+	return n, r.record(ctx, AuditUpdate, id, before, after)
+}
+
+func (r *Repository) auditedUpdateMany(ctx context.Context, filter, update interface{}, opts []*options.UpdateOptions) (int64, error) {
+	befores, err := r.snapshots(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.updateMany(ctx, filter, update, opts)
+	if err != nil {
+		return 0, err
+	}
+	n := res.MatchedCount
+	if len(befores) == 0 {
+		return n, r.recordUpserted(ctx, res)
+	}
+
+	var ids []interface{}
+	for _, doc := range befores {
+		ids = append(ids, doc["_id"])
+	}
+	afters, err := r.snapshots(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return n, err
+	}
+	byID := make(map[string]bson.M)
+	for _, doc := range afters {
+		byID[fmt.Sprint(doc["_id"])] = doc
+	}
+	for _, before := range befores {
+		id := before["_id"]
+		after, ok := byID[fmt.Sprint(id)]
+		if !ok {
+			continue
+		}
+		if err := r.record(ctx, AuditUpdate, id, before, after); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// recordUpserted upsert 插入了文档时记录插入
+func (r *Repository) recordUpserted(ctx context.Context, res *mongo.UpdateResult) error {
+	if res.UpsertedID == nil {
+		return nil
+	}
+	after, err := r.snapshot(ctx, bson.M{"_id": res.UpsertedID})
+	if err != nil {
+		return err
+	}
+	return r.record(ctx, AuditInsert, res.UpsertedID, nil, after)
+}
+
+func (r *Repository) auditedUpsert(ctx context.Context, filter, update interface{}) (interface{}, error) {
+	before, err := r.snapshot(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if before != nil {
+		filter = ByID(filter, before["_id"])
+	}
+	upserted, err := r.plain().Upsert(ctx, filter, update)
+	if err != nil || (before == nil && upserted == nil) {
+		// 读取后被并发插入的文档，无法得到写入前的状态，不记录
+		return upserted, err
+	}
+
+	id, action := upserted, AuditInsert
+	if before != nil {
+		id, action = before["_id"], AuditUpdate
+	}
+	after, err := r.snapshot(ctx, bson.M{"_id": id})
+	if err != nil {
+		return upserted, err
+	}
+	return upserted, r.record(ctx, action, id, before, after)
+}
+
+func (r *Repository) auditedDelete(ctx context.Context, filter interface{}) (int64, error) {
+	before, err := r.snapshot(ctx, filter)
+	if err != nil || before == nil {
+		return 0, err
+	}
+	id := before["_id"]
+	n, err := r.plain().Delete(ctx, ByID(filter, id))
+	if err != nil || n == 0 {
+		return n, err
+	}
+	return n, r.record(ctx, AuditDelete, id, before, nil)
+}
+
+func (r *Repository) auditedDeleteMany(ctx context.Context, filter interface{}) (int64, error) {
+	befores, err := r.snapshots(ctx, filter)
+	if err != nil || len(befores) == 0 {
+		return 0, err
+	}
+	var ids []interface{}
+	for _, doc := range befores {
+		ids = append(ids, doc["_id"])
+	}
+	// 只删除读取到的文档，保证审计记录与删除的文档一致
+	n, err := r.plain().DeleteMany(ctx, ByID(filter, bson.M{"$in": ids}))
+	if err != nil {
+		return n, err
+	}
+	for _, before := range befores {
+		if err := r.record(ctx, AuditDelete, before["_id"], before, nil); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ByID 在条件上追加 _id 的条件，用于只写入审计时读取到的文档
+func ByID(filter, id interface{}) bson.D {
+	return bson.D{{Key: "$and", Value: bson.A{filter, bson.M{"_id": id}}}}
+}
+
+// toDocument 把文档转换为 bson.M，nil 时返回 nil
+func toDocument(doc interface{}) (bson.M, error) {
+	switch d := doc.(type) {
+	case nil:
+		return nil, nil
+	case bson.M:
+		if d == nil {
+			return nil, nil
+		}
+	}
+
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	if err := bson.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// diffDocuments 比较顶层字段，返回按字段名排序的变更
+func diffDocuments(before, after bson.M) []FieldChange {
+	fields := make(map[string]bool)
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+	delete(fields, "_id")
+
+	var names []string
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var changes []FieldChange
+	for _, k := range names {
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+		if inBefore && inAfter && canonical(bson.M{"v": b}) == canonical(bson.M{"v": a}) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: k, Before: b, After: a})
+	}
+	return changes
+}
@@ -0,0 +1,352 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// memorySink 保存在内存中的审计，用于测试
+type memorySink struct {
+	entries []*AuditEntry
+	err     error
+}
+
+func (s *memorySink) Write(ctx context.Context, entry *AuditEntry) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memorySink) History(ctx context.Context, database, collection string, id interface{}, limit int64) ([]AuditEntry, error) {
+	var result []AuditEntry
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		e := s.entries[i]
+		if e.Database == database && e.Collection == collection && e.DocumentID == id {
+			result = append(result, *e)
+		}
+	}
+	return result, nil
+}
+
+func TestDiffDocuments(t *testing.T) {
+	before := bson.M{"_id": 1, "name": "a", "amount": int32(100), "tags": bson.A{"x"}, "old": true}
+	after := bson.M{"_id": 1, "name": "b", "amount": int64(100), "tags": bson.A{"x", "y"}, "new": "n"}
+
+	got := fmt.Sprint(diffDocuments(before, after))
+	want := fmt.Sprint([]FieldChange{
+		{Field: "name", Before: "a", After: "b"},
+		{Field: "new", After: "n"},
+		{Field: "old", Before: true},
+		{Field: "tags", Before: bson.A{"x"}, After: bson.A{"x", "y"}},
+	})
+	if got != want {
+		t.Errorf("diffDocuments() = %v\nwant %v", got, want)
+	}
+
+	if changes := diffDocuments(nil, bson.M{"_id": 1, "name": "a"}); len(changes) != 1 || changes[0].Field != "name" {
+		t.Errorf("diffDocuments(insert) = %v", changes)
+	}
+}
+
+func TestNewAuditEntry(t *testing.T) {
+	type lease struct {
+		No     string `bson:"no"`
+		Amount int    `bson:"amount"`
+	}
+	oid := primitive.NewObjectID()
+	ctx := WithActor(context.Background(), "user-1")
+
+	entry, err := NewAuditEntry(ctx, "Db", "lease", AuditInsert, oid, nil, lease{No: "L1", Amount: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Actor != "user-1" || entry.Before != nil || entry.After["_id"] != oid || entry.After["no"] != "L1" {
+		t.Errorf("NewAuditEntry() = %+v", entry)
+	}
+	if len(entry.Changes) != 2 {
+		t.Errorf("NewAuditEntry() changes = %v", entry.Changes)
+	}
+
+	if _, ok := ActorFromContext(context.Background()); ok {
+		t.Error("ActorFromContext() ok = true without actor")
+	}
+}
+
+func TestRecordAudit(t *testing.T) {
+	ctx := WithActor(context.Background(), "user-1")
+	sink := &memorySink{}
+
+	doc := bson.M{"_id": "k1", "name": "a"}
+	if err := RecordAudit(ctx, sink, "Db", "lease", AuditUpdate, "k1", doc, doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.entries) != 0 {
+		t.Errorf("RecordAudit() saved unchanged update: %v", sink.entries)
+	}
+
+	if err := RecordAudit(ctx, sink, "Db", "lease", AuditUpdate, "k1", doc, bson.M{"_id": "k1", "name": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordAudit(ctx, sink, "Db", "lease", AuditDelete, "k1", bson.M{"_id": "k1", "name": "b"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	history, _ := sink.History(ctx, "Db", "lease", "k1", 0)
+	if len(history) != 2 || history[0].Action != AuditDelete || history[1].Action != AuditUpdate {
+		t.Errorf("History() = %v", history)
+	}
+
+	sink.err = errors.New("down")
+	if err := RecordAudit(ctx, sink, "Db", "lease", AuditDelete, "k1", doc, nil); !errors.Is(err, ErrAuditFailed) {
+		t.Errorf("RecordAudit() error = %v, want %v", err, ErrAuditFailed)
+	}
+}
+
+func TestRepositoryAudit(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := C("lease").History(ctx, "k1", 0); err != ErrAuditDisabled {
+		t.Errorf("History() error = %v, want %v", err, ErrAuditDisabled)
+	}
+
+	r := C("lease").WithAudit(&memorySink{})
+	if _, err := r.History(ctx, "k1", 0); err != nil {
+		t.Errorf("History() error = %v", err)
+	}
+	if _, err := r.Update(ctx, bson.M{"no": "L1"}, bson.M{"$set": bson.M{"x": 1}}); err != ErrNotStarted {
+		t.Errorf("Update() error = %v, want %v", err, ErrNotStarted)
+	}
+	if _, err := r.DeleteMany(ctx, bson.M{}); err != ErrNotStarted {
+		t.Errorf("DeleteMany() error = %v, want %v", err, ErrNotStarted)
+	}
+
+	// 没有插入时不记录，upsert 插入时读取插入的文档记录
+	if err := r.recordUpserted(ctx, &mongo.UpdateResult{MatchedCount: 1}); err != nil {
+		t.Errorf("recordUpserted() error = %v", err)
+	}
+	if err := r.recordUpserted(ctx, &mongo.UpdateResult{UpsertedID: "k1"}); err != ErrNotStarted {
+		t.Errorf("recordUpserted() error = %v, want %v", err, ErrNotStarted)
+	}
+}
+
+func TestByID(t *testing.T) {
+	got := fmt.Sprint(ByID(bson.M{"no": "L1"}, "k1"))
+	if got != "[{$and [map[no:L1] map[_id:k1]]}]" {
+		t.Errorf("ByID() = %v", got)
+	}
+}
+
+// laggingStore 单个集合的内存替身，从 primary 以外读取时返回开始时的数据，模拟延迟的 secondary
+// 只支持审计用到的条件：字段相等、_id 的 $in 和 $and，更新只支持 $set
+type laggingStore struct {
+	docs  []bson.M
+	stale []bson.M
+}
+
+func newLaggingStore(docs ...bson.M) *laggingStore {
+	s := &laggingStore{docs: docs}
+	for _, d := range docs {
+		s.stale = append(s.stale, copyDoc(d))
+	}
+	return s
+}
+
+func copyDoc(d bson.M) bson.M {
+	c := make(bson.M, len(d))
+	for k, v := range d {
+		c[k] = v
+	}
+	return c
+}
+
+func matchDoc(filter bson.M, doc bson.M) bool {
+	for k, v := range filter {
+		switch {
+		case k == "$and":
+			for _, f := range v.(bson.A) {
+				if !matchDoc(f.(bson.M), doc) {
+					return false
+				}
+			}
+		case isOperator(v, "$in"):
+			found := false
+			for _, x := range v.(bson.M)["$in"].(bson.A) {
+				found = found || fmt.Sprint(x) == fmt.Sprint(doc[k])
+			}
+			if !found {
+				return false
+			}
+		default:
+			if fmt.Sprint(v) != fmt.Sprint(doc[k]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isOperator(v interface{}, op string) bool {
+	m, ok := v.(bson.M)
+	_, has := m[op]
+	return ok && has
+}
+
+func (s *laggingStore) handle(cmd mockCommand) bson.D {
+	switch cmd.Name {
+	case "find":
+		docs := s.docs
+		if rp, _ := cmd.Doc["$readPreference"].(bson.M); rp != nil && rp["mode"] != "primary" {
+			docs = s.stale
+		}
+		filter, _ := cmd.Doc["filter"].(bson.M)
+		var found []interface{}
+		for _, d := range docs {
+			if matchDoc(filter, d) {
+				found = append(found, d)
+			}
+		}
+		return cursorResponse(0, "Db.lease", "firstBatch", found...)
+	case "update":
+		res := bson.D{{Key: "ok", Value: 1}}
+		n, modified := 0, 0
+		for i, u := range cmd.Doc["updates"].(bson.A) {
+			u := u.(bson.M)
+			q, _ := u["q"].(bson.M)
+			set, _ := u["u"].(bson.M)["$set"].(bson.M)
+			matched := false
+			for _, d := range s.docs {
+				if !matchDoc(q, d) {
+					continue
+				}
+				matched = true
+				n++
+				changed := false
+				for k, v := range set {
+					changed = changed || fmt.Sprint(d[k]) != fmt.Sprint(v)
+					d[k] = v
+				}
+				if changed {
+					modified++
+				}
+				if multi, _ := u["multi"].(bool); !multi {
+					break
+				}
+			}
+			if upsert, _ := u["upsert"].(bool); upsert && !matched {
+				doc := bson.M{"_id": primitive.NewObjectID()}
+				for k, v := range q {
+					if k[0] != '$' {
+						doc[k] = v
+					}
+				}
+				for k, v := range set {
+					doc[k] = v
+				}
+				s.docs = append(s.docs, doc)
+				n++
+				res = append(res, bson.E{Key: "upserted", Value: bson.A{bson.M{"index": i, "_id": doc["_id"]}}})
+			}
+		}
+		return append(res, bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: modified})
+	case "delete":
+		n := 0
+		for _, d := range cmd.Doc["deletes"].(bson.A) {
+			d := d.(bson.M)
+			q, _ := d["q"].(bson.M)
+			var kept []bson.M
+			for _, doc := range s.docs {
+				if matchDoc(q, doc) && (fmt.Sprint(d["limit"]) == "0" || n == 0) {
+					n++
+					continue
+				}
+				kept = append(kept, doc)
+			}
+			s.docs = kept
+		}
+		return bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: n}}
+	}
+	return nil
+}
+
+func TestAuditedWrites(t *testing.T) {
+	store := newLaggingStore(
+		bson.M{"_id": "k1", "no": "L1", "status": "new"},
+		bson.M{"_id": "k2", "no": "L2", "status": "new"},
+	)
+	m, done := startMock(t, store.handle)
+	defer done()
+
+	ctx := WithActor(context.Background(), "user-1")
+	sink := &memorySink{}
+	r := C("lease").WithAudit(sink)
+	set := func(field, value string) bson.M { return bson.M{"$set": bson.M{field: value}} }
+
+	// 读取写入后的文档不能读到 secondary 上写入前的内容
+	n, err := r.Update(ctx, bson.M{"no": "L1"}, set("status", "active"))
+	if err != nil || n != 1 {
+		t.Fatalf("Update() = %d, %v", n, err)
+	}
+	if len(sink.entries) != 1 {
+		t.Fatalf("Update() entries = %v", sink.entries)
+	}
+	e := sink.entries[0]
+	if e.Action != AuditUpdate || e.DocumentID != "k1" || e.Actor != "user-1" ||
+		fmt.Sprint(e.Changes) != fmt.Sprint([]FieldChange{{Field: "status", Before: "new", After: "active"}}) {
+		t.Errorf("Update() entry = %+v", e)
+	}
+
+	sink.entries = nil
+	n, err = r.UpdateMany(ctx, bson.M{}, set("owner", "u2"))
+	if err != nil || n != 2 || len(sink.entries) != 2 {
+		t.Fatalf("UpdateMany() = %d, %v entries(%d)", n, err, len(sink.entries))
+	}
+	for i, id := range []string{"k1", "k2"} {
+		if e := sink.entries[i]; e.DocumentID != id || len(e.Changes) != 1 || e.Changes[0].After != "u2" {
+			t.Errorf("UpdateMany() entry[%d] = %+v", i, e)
+		}
+	}
+
+	// 没有变化的更新不记录
+	sink.entries = nil
+	if _, err := r.Update(ctx, bson.M{"no": "L2"}, set("owner", "u2")); err != nil || len(sink.entries) != 0 {
+		t.Errorf("Update() without changes: %v entries = %v", err, sink.entries)
+	}
+
+	// Update 指定 upsert 和 Upsert 插入时记录插入
+	if _, err := r.Update(ctx, bson.M{"no": "L3"}, set("status", "new"), options.Update().SetUpsert(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Upsert(ctx, bson.M{"no": "L4"}, set("status", "new")); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.entries) != 2 {
+		t.Fatalf("upsert entries = %v", sink.entries)
+	}
+	for i, no := range []string{"L3", "L4"} {
+		if e := sink.entries[i]; e.Action != AuditInsert || e.Before != nil || e.After["no"] != no || e.DocumentID == nil {
+			t.Errorf("upsert entry[%d] = %+v", i, e)
+		}
+	}
+
+	sink.entries = nil
+	if n, err := r.Delete(ctx, bson.M{"no": "L1"}); err != nil || n != 1 {
+		t.Fatalf("Delete() = %d, %v", n, err)
+	}
+	if len(sink.entries) != 1 || sink.entries[0].Action != AuditDelete || sink.entries[0].Before["no"] != "L1" || sink.entries[0].After != nil {
+		t.Errorf("Delete() entries = %+v", sink.entries)
+	}
+
+	for _, c := range m.Commands("find") {
+		if rp, _ := c.Doc["$readPreference"].(bson.M); rp == nil || rp["mode"] != "primary" {
+			t.Errorf("audit snapshot read from %v, want primary", c.Doc["$readPreference"])
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// IndexSpec 索引的声明
+	IndexSpec struct {
+		// Name 索引名，为空时与驱动相同按键生成，例如 "customer_id_1_created_at_-1"
+		Name string
+		// Keys 索引键，顺序有意义，文本索引的值为 "text"
+		Keys bson.D
+		// Unique 唯一索引
+		Unique bool
+		// Sparse 稀疏索引
+		Sparse bool
+		// TTL 大于 0 时为 TTL 索引，文档在索引字段的时间加 TTL 后自动删除，精度为秒
+		TTL time.Duration
+		// Partial 部分索引的条件
+		Partial bson.M
+		// Collation 排序规则，日文使用 JapaneseCollation
+		Collation *options.Collation
+		// Weights 文本索引各字段的权重
+		Weights bson.M
+		// DefaultLanguage 文本索引的语言，日文等不支持分词的语言请使用 "none"
+		DefaultLanguage string
+	}
+
+	// IndexSyncOptions 同步索引的配置
+	IndexSyncOptions struct {
+		// DryRun 只报告需要的变更，不执行
+		DryRun bool
+		// DropUnknown 删除没有声明的索引（_id_ 除外），默认保留
+		DropUnknown bool
+	}
+
+	// IndexChange 一个索引的变更
+	IndexChange struct {
+		Collection string
+		Index      string
+		Action     string // create、replace、drop
+		Reason     string
+	}
+
+	// IndexReport 同步索引的结果
+	IndexReport struct {
+		DryRun  bool
+		Changes []IndexChange
+	}
+
+	// indexInfo listIndexes 返回的索引定义
+	indexInfo struct {
+		Name                    string      `bson:"name"`
+		Key                     bson.D      `bson:"key"`
+		Unique                  bool        `bson:"unique"`
+		Sparse                  bool        `bson:"sparse"`
+		ExpireAfterSeconds      interface{} `bson:"expireAfterSeconds"`
+		PartialFilterExpression bson.M      `bson:"partialFilterExpression"`
+		Collation               bson.M      `bson:"collation"`
+		Weights                 bson.M      `bson:"weights"`
+		DefaultLanguage         string      `bson:"default_language"`
+
+		// raw listIndexes 返回的原始定义，用于恢复索引
+		raw bson.Raw
+	}
+)
+
+const (
+	// IndexCreate 创建索引
+	IndexCreate = "create"
+	// IndexReplace 定义不同的索引，删除后按声明重建
+	IndexReplace = "replace"
+	// IndexDrop 删除索引
+	IndexDrop = "drop"
+
+	// indexTempSuffix 替换索引前验证新定义时使用的临时索引名的后缀
+	indexTempSuffix = "_sync_tmp"
+)
+
+// JapaneseCollation 日文的排序规则，strength 1 时不区分平假名和片假名、全角和半角
+func JapaneseCollation(strength int) *options.Collation {
+	return &options.Collation{Locale: "ja", Strength: strength}
+}
+
+// IndexName 索引名
+func (s IndexSpec) IndexName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	var parts []string
+	for _, k := range s.Keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", k.Key, k.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// Model 转换为驱动的索引定义，可以用于 TenantSpec.Indexes
+func (s IndexSpec) Model() mongo.IndexModel {
+	opts := options.Index().SetName(s.IndexName())
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.Sparse {
+		opts.SetSparse(true)
+	}
+	if s.TTL > 0 {
+		opts.SetExpireAfterSeconds(int32(s.TTL / time.Second))
+	}
+	if len(s.Partial) > 0 {
+		opts.SetPartialFilterExpression(s.Partial)
+	}
+	if s.Collation != nil {
+		opts.SetCollation(s.Collation)
+	}
+	if len(s.Weights) > 0 {
+		opts.SetWeights(s.Weights)
+	}
+	if s.DefaultLanguage != "" {
+		opts.SetDefaultLanguage(s.DefaultLanguage)
+	}
+
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+// SyncIndexes 按声明同步数据库中各集合的索引，specs 的键为集合名
+// 不存在的索引创建，定义不同的索引替换，DropUnknown 时删除没有声明的索引（_id_ 除外）
+// 替换时先以临时名创建新定义验证数据，失败时保留旧索引，见 replaceIndex
+func SyncIndexes(ctx context.Context, db *mongo.Database, specs map[string][]IndexSpec, opts IndexSyncOptions) (*IndexReport, error) {
+	report := &IndexReport{DryRun: opts.DryRun}
+
+	var names []string
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := time.Now()
+		col := db.Collection(name)
+		existing, err := listIndexes(ctx, col)
+		if err != nil {
+			log.Errorf("error SyncIndexes %v collection: %v", err, name)
+			return report, err
+		}
+
+		changes := diffIndexes(name, existing, specs[name], opts.DropUnknown)
+		if opts.DryRun {
+			report.Changes = append(report.Changes, changes...)
+			continue
+		}
+
+		byName := make(map[string]IndexSpec)
+		for _, spec := range specs[name] {
+			byName[spec.IndexName()] = spec
+		}
+		current := make(map[string]indexInfo)
+		for _, info := range existing {
+			current[info.Name] = info
+		}
+		for _, c := range changes {
+			switch c.Action {
+			case IndexCreate:
+				_, err = col.Indexes().CreateOne(ctx, byName[c.Index].Model())
+			case IndexReplace:
+				err = replaceIndex(ctx, col, current[c.Index], byName[c.Index])
+			case IndexDrop:
+				_, err = col.Indexes().DropOne(ctx, c.Index)
+			}
+			if err != nil {
+				log.Errorf("error SyncIndexes %v collection: %v index: %v", err, name, c.Index)
+				return report, err
+			}
+			report.Changes = append(report.Changes, c)
+		}
+
+		log.Infof("SyncIndexes took: %v collection: %v changes(%v)", time.Since(s), name, len(changes))
+	}
+
+	return report, nil
+}
+
+// replaceIndex 把定义不同的旧索引替换为声明的定义
+// 先以临时名创建新定义，验证数据满足新定义（例如唯一索引没有重复数据），失败时不删除旧索引；
+// 键相同、选项不同的索引或第二个文本索引不能与旧索引并存，此时跳过验证。
+// 删除旧索引后创建失败时按原定义恢复旧索引
+func replaceIndex(ctx context.Context, col *mongo.Collection, old indexInfo, spec IndexSpec) error {
+	tmp := spec
+	tmp.Name = spec.IndexName() + indexTempSuffix
+	_, err := col.Indexes().CreateOne(ctx, tmp.Model())
+	switch {
+	case err == nil:
+		// 最终的索引与临时索引的定义相同，不能并存
+		if _, err := col.Indexes().DropOne(ctx, tmp.Name); err != nil {
+			return err
+		}
+	case !isIndexConflict(err):
+		return err
+	}
+
+	if _, err := col.Indexes().DropOne(ctx, old.Name); err != nil {
+		return err
+	}
+	if _, err := col.Indexes().CreateOne(ctx, spec.Model()); err != nil {
+		if e := restoreIndex(ctx, col, old); e != nil {
+			log.Errorf("error SyncIndexes restore %v collection: %v index: %v", e, col.Name(), old.Name)
+			return fmt.Errorf("%w (restore index %s: %v)", err, old.Name, e)
+		}
+		return err
+	}
+	return nil
+}
+
+// restoreIndex 按 listIndexes 返回的原定义重建索引
+func restoreIndex(ctx context.Context, col *mongo.Collection, old indexInfo) error {
+	cmd, err := restoreCommand(col.Name(), old)
+	if err != nil {
+		return err
+	}
+	return col.Database().RunCommand(ctx, cmd).Err()
+}
+
+// restoreCommand 重建索引的 createIndexes 命令，去掉服务端生成的 v、ns
+func restoreCommand(collection string, old indexInfo) (bson.D, error) {
+	elems, err := old.raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	var index bson.D
+	for _, e := range elems {
+		if k := e.Key(); k != "v" && k != "ns" {
+			index = append(index, bson.E{Key: k, Value: e.Value()})
+		}
+	}
+	return bson.D{
+		{Key: "createIndexes", Value: collection},
+		{Key: "indexes", Value: bson.A{index}},
+	}, nil
+}
+
+// isIndexConflict 索引与现有索引的键或选项冲突
+func isIndexConflict(err error) bool {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		return ce.Code == 85 || ce.Code == 86 // IndexOptionsConflict、IndexKeySpecsConflict
+	}
+	return false
+}
+
+// listIndexes 获取集合的现有索引，集合不存在时返回空
+func listIndexes(ctx context.Context, col *mongo.Collection) ([]indexInfo, error) {
+	cur, err := col.Indexes().List(ctx)
+	if err != nil {
+		if ce, ok := err.(mongo.CommandError); ok && ce.Code == 26 { // NamespaceNotFound
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var docs []bson.Raw
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	result := make([]indexInfo, 0, len(docs))
+	for _, doc := range docs {
+		var info indexInfo
+		if err := bson.Unmarshal(doc, &info); err != nil {
+			return nil, err
+		}
+		info.raw = doc
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+// diffIndexes 比较现有索引和声明，返回需要的变更，按创建、替换、删除的顺序
+func diffIndexes(collection string, existing []indexInfo, specs []IndexSpec, dropUnknown bool) []IndexChange {
+	var creates, replaces, drops []IndexChange
+
+	current := make(map[string]indexInfo)
+	for _, info := range existing {
+		current[info.Name] = info
+	}
+
+	declared := make(map[string]bool)
+	for _, spec := range specs {
+		name := spec.IndexName()
+		declared[name] = true
+
+		info, ok := current[name]
+		if !ok {
+			creates = append(creates, IndexChange{Collection: collection, Index: name, Action: IndexCreate, Reason: "missing"})
+			continue
+		}
+		if reason := indexDiff(info, spec); reason != "" {
+			replaces = append(replaces, IndexChange{Collection: collection, Index: name, Action: IndexReplace, Reason: reason})
+		}
+	}
+
+	if dropUnknown {
+		for _, info := range existing {
+			if info.Name != "_id_" && !declared[info.Name] {
+				drops = append(drops, IndexChange{Collection: collection, Index: info.Name, Action: IndexDrop, Reason: "not declared"})
+			}
+		}
+	}
+
+	changes := append(creates, replaces...)
+	return append(changes, drops...)
+}
+
+// indexDiff 比较现有索引和声明，相同时返回空字符串，否则返回不同的项
+func indexDiff(info indexInfo, spec IndexSpec) string {
+	if !sameKeys(info, spec) {
+		return "keys"
+	}
+	if info.Unique != spec.Unique {
+		return "unique"
+	}
+	if info.Sparse != spec.Sparse {
+		return "sparse"
+	}
+
+	var ttl int64 = -1
+	if info.ExpireAfterSeconds != nil {
+		ttl = toInt64(info.ExpireAfterSeconds)
+	}
+	want := int64(-1)
+	if spec.TTL > 0 {
+		want = int64(spec.TTL / time.Second)
+	}
+	if ttl != want {
+		return "ttl"
+	}
+
+	if canonical(info.PartialFilterExpression) != canonical(spec.Partial) {
+		return "partial"
+	}
+
+	// 服务端会补全排序规则的默认值，只比较声明中设置的项，
+	// 没有声明时索引继承集合的排序规则，不比较
+	if spec.Collation != nil {
+		var want bson.M
+		bson.Unmarshal(spec.Collation.ToDocument(), &want)
+		for k, v := range want {
+			if canonical(bson.M{k: v}) != canonical(bson.M{k: info.Collation[k]}) {
+				return "collation"
+			}
+		}
+	}
+
+	if spec.DefaultLanguage != "" && spec.DefaultLanguage != info.DefaultLanguage {
+		return "default_language"
+	}
+	return ""
+}
+
+// sameKeys 比较索引键，文本索引比较字段和权重
+func sameKeys(info indexInfo, spec IndexSpec) bool {
+	var textFields []string
+	var keys bson.D
+	for _, k := range spec.Keys {
+		if k.Value == "text" {
+			textFields = append(textFields, k.Key)
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	if len(textFields) > 0 {
+		weights := bson.M{}
+		for _, f := range textFields {
+			weights[f] = 1
+		}
+		for f, w := range spec.Weights {
+			weights[f] = w
+		}
+		if canonical(weights) != canonical(info.Weights) {
+			return false
+		}
+		// 文本索引的键为 _fts、_ftsx 以及前后的普通字段
+		var rest bson.D
+		for _, k := range info.Key {
+			if k.Key != "_fts" && k.Key != "_ftsx" {
+				rest = append(rest, k)
+			}
+		}
+		return sameKeyList(rest, keys)
+	}
+
+	return sameKeyList(info.Key, keys)
+}
+
+func sameKeyList(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || fmt.Sprint(normalizeKey(a[i].Value)) != fmt.Sprint(normalizeKey(b[i].Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeKey 索引键的方向可能是 int32、int64 或 double
+func normalizeKey(v interface{}) interface{} {
+	switch v.(type) {
+	case int, int32, int64, float64:
+		return toInt64(v)
+	}
+	return v
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// canonical 把文档转换为键有序、数值类型无关的字符串，用于比较，空文档为空字符串
+func canonical(doc bson.M) string {
+	if len(doc) == 0 {
+		return ""
+	}
+	b, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return fmt.Sprint(doc)
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return string(b)
+	}
+	b, _ = json.Marshal(v)
+	return string(b)
+}
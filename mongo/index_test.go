@@ -0,0 +1,98 @@
+package mongo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexName(t *testing.T) {
+	spec := IndexSpec{Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "created_at", Value: -1}}}
+	if got := spec.IndexName(); got != "customer_id_1_created_at_-1" {
+		t.Errorf("IndexName() = %v", got)
+	}
+	if got := (IndexSpec{Name: "x", Keys: spec.Keys}).IndexName(); got != "x" {
+		t.Errorf("IndexName() = %v, want x", got)
+	}
+}
+
+func TestDiffIndexes(t *testing.T) {
+	existing := []indexInfo{
+		{Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+		{Name: "no_1", Key: bson.D{{Key: "no", Value: int32(1)}}, Unique: true,
+			Collation: bson.M{"locale": "ja", "strength": int32(1), "caseLevel": false}},
+		{Name: "expire_at_1", Key: bson.D{{Key: "expire_at", Value: 1.0}}, ExpireAfterSeconds: int32(60)},
+		{Name: "status_1", Key: bson.D{{Key: "status", Value: int64(1)}},
+			PartialFilterExpression: bson.M{"deleted": bson.M{"$eq": false}}},
+		{Name: "title_text", Key: bson.D{{Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}},
+			Weights: bson.M{"title": int32(1)}},
+		{Name: "old_1", Key: bson.D{{Key: "old", Value: int32(1)}}},
+	}
+	specs := []IndexSpec{
+		{Keys: bson.D{{Key: "no", Value: 1}}, Unique: true, Collation: JapaneseCollation(1)},
+		{Keys: bson.D{{Key: "expire_at", Value: 1}}, TTL: 2 * time.Minute},
+		{Keys: bson.D{{Key: "status", Value: 1}}, Partial: bson.M{"deleted": bson.M{"$eq": false}}},
+		{Keys: bson.D{{Key: "title", Value: "text"}}},
+		{Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	}
+
+	got := fmt.Sprint(diffIndexes("lease", existing, specs, true))
+	want := fmt.Sprint([]IndexChange{
+		{"lease", "customer_id_1_created_at_-1", IndexCreate, "missing"},
+		{"lease", "expire_at_1", IndexReplace, "ttl"},
+		{"lease", "old_1", IndexDrop, "not declared"},
+	})
+	if got != want {
+		t.Errorf("diffIndexes() = %v\nwant %v", got, want)
+	}
+
+	// 默认保留没有声明的索引
+	if changes := diffIndexes("lease", existing, specs, false); len(changes) != 2 {
+		t.Errorf("diffIndexes() keep unknown = %v", changes)
+	}
+
+	changed := []IndexSpec{
+		{Keys: bson.D{{Key: "no", Value: 1}}, Unique: true, Collation: JapaneseCollation(2)},
+		{Keys: bson.D{{Key: "title", Value: "text"}}, Weights: bson.M{"title": 5}},
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+	}
+	for _, spec := range changed {
+		info := existing[0]
+		for _, e := range existing {
+			if e.Name == spec.IndexName() {
+				info = e
+			}
+		}
+		if indexDiff(info, spec) == "" {
+			t.Errorf("indexDiff(%v) should detect change", spec.IndexName())
+		}
+	}
+}
+
+func TestRestoreCommand(t *testing.T) {
+	raw, err := bson.Marshal(bson.D{
+		{Key: "v", Value: int32(2)},
+		{Key: "key", Value: bson.D{{Key: "no", Value: int32(1)}}},
+		{Key: "name", Value: "no_1"},
+		{Key: "ns", Value: "Db.lease"},
+		{Key: "unique", Value: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := restoreCommand("lease", indexInfo{Name: "no_1", raw: raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := bson.MarshalExtJSON(cmd, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"createIndexes":"lease","indexes":[{"key":{"no":1},"name":"no_1","unique":true}]}`
+	if string(b) != want {
+		t.Errorf("restoreCommand() = %s\nwant %s", b, want)
+	}
+}
@@ -0,0 +1,460 @@
+// Package migrate 按版本执行 MongoDB 的索引和数据迁移
+//
+//	r, err := migrate.New(
+//	    migrate.Migration{Version: 1, Name: "lease index", Up: addLeaseIndex, Down: dropLeaseIndex},
+//	    migrate.Migration{Version: 2, Name: "fill lease status", Up: fillLeaseStatus},
+//	)
+//	// 所有租户数据库（Db_*）执行到最新版本
+//	reports, err := r.UpAll(ctx, migrate.Options{})
+//
+// 已执行的版本记录在各数据库的 _migrations 集合中，执行时在同一集合中加锁，
+// 多个实例同时启动时只有一个实例执行迁移，其他实例返回 ErrLocked。
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"rxcsoft.cn/utils/logger"
+	rxmongo "rxcsoft.cn/utils/mongo"
+)
+
+type (
+	// Func 迁移的处理，db 为执行迁移的数据库
+	Func func(ctx context.Context, db *mongo.Database) error
+
+	// Migration 一个版本的迁移
+	Migration struct {
+		Version int64  // 版本号，按从小到大的顺序执行，不能重复
+		Name    string // 说明
+		Up      Func   // 升级
+		Down    Func   // 回滚，为 nil 时不能回滚
+	}
+
+	// Options 执行的配置
+	Options struct {
+		// Target 目标版本，Up 时为 0 表示最新版本，Down 时回滚所有大于 Target 的版本
+		Target int64
+		// DryRun 只报告将要执行的版本，不执行也不加锁
+		DryRun bool
+		// LockTTL 锁的有效期，执行期间每 LockTTL/3 在后台延长一次，进程异常退出时锁在有效期后自动失效
+		// 延长失败（锁已被其他进程取得）时取消传给 Up、Down 的 context 并停止执行
+		LockTTL time.Duration
+	}
+
+	// Record _migrations 集合中已执行版本的记录
+	Record struct {
+		Version   int64     `bson:"_id"`
+		Name      string    `bson:"name"`
+		AppliedAt time.Time `bson:"applied_at"`
+		Took      int64     `bson:"took_ms"`
+	}
+
+	// Status 一个版本在数据库中的执行状态
+	Status struct {
+		Version   int64
+		Name      string
+		Applied   bool
+		AppliedAt time.Time // 未执行时为零值
+	}
+
+	// Report 一个数据库的执行结果
+	Report struct {
+		Database string
+		DryRun   bool
+		Versions []int64 // 执行的版本，DryRun 时为将要执行的版本
+		Err      error   // 执行中的错误，UpAll、DownAll 时各数据库的错误记录在这里
+	}
+
+	// Runner 迁移的执行器
+	Runner struct {
+		migrations []Migration
+	}
+)
+
+const (
+	// Collection 记录已执行版本的集合
+	Collection = "_migrations"
+
+	// lockID 锁的文档的 _id，和版本记录保存在同一集合中
+	lockID         = "lock"
+	defaultLockTTL = 10 * time.Minute
+)
+
+var (
+	log = logger.New()
+
+	// ErrLocked 其他进程正在执行迁移
+	ErrLocked = errors.New("migration is locked by another process")
+	// ErrIrreversible 要回滚的版本没有 Down
+	ErrIrreversible = errors.New("migration is irreversible")
+	// ErrInvalidMigration 迁移的定义不正确
+	ErrInvalidMigration = errors.New("invalid migration")
+)
+
+// New 创建执行器，版本号重复或没有 Up 时返回错误
+func New(migrations ...Migration) (*Runner, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	for i, m := range sorted {
+		if m.Version <= 0 || m.Up == nil {
+			return nil, fmt.Errorf("%w: version %d must be positive and have Up", ErrInvalidMigration, m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("%w: duplicate version %d", ErrInvalidMigration, m.Version)
+		}
+	}
+
+	return &Runner{migrations: sorted}, nil
+}
+
+// Status 获取各版本在数据库中的执行状态
+func (r *Runner) Status(ctx context.Context, db *mongo.Database) ([]Status, error) {
+	applied, err := appliedRecords(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Status
+	for _, m := range r.migrations {
+		st := Status{Version: m.Version, Name: m.Name}
+		if rec, ok := applied[m.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = rec.AppliedAt
+		}
+		result = append(result, st)
+	}
+	return result, nil
+}
+
+// Up 执行数据库中未执行的版本，直到 opts.Target
+func (r *Runner) Up(ctx context.Context, db *mongo.Database, opts Options) (*Report, error) {
+	return r.run(ctx, db, opts, true)
+}
+
+// Down 按版本从大到小回滚大于 opts.Target 的版本
+func (r *Runner) Down(ctx context.Context, db *mongo.Database, opts Options) (*Report, error) {
+	return r.run(ctx, db, opts, false)
+}
+
+// UpAll 对所有租户数据库执行 Up，一个数据库失败时继续执行其他数据库，
+// 结果中记录各数据库的错误，有失败时同时返回错误
+func (r *Runner) UpAll(ctx context.Context, opts Options) ([]*Report, error) {
+	return r.runAll(ctx, opts, true)
+}
+
+// DownAll 对所有租户数据库执行 Down
+func (r *Runner) DownAll(ctx context.Context, opts Options) ([]*Report, error) {
+	return r.runAll(ctx, opts, false)
+}
+
+// StatusAll 获取所有租户数据库的执行状态，键为数据库名
+func (r *Runner) StatusAll(ctx context.Context) (map[string][]Status, error) {
+	dbs, err := tenantDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]Status)
+	for _, db := range dbs {
+		st, err := r.Status(ctx, db)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", db.Name(), err)
+		}
+		result[db.Name()] = st
+	}
+	return result, nil
+}
+
+func (r *Runner) runAll(ctx context.Context, opts Options, up bool) ([]*Report, error) {
+	dbs, err := tenantDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*Report
+	var failed []string
+	for _, db := range dbs {
+		report, err := r.run(ctx, db, opts, up)
+		if err != nil {
+			failed = append(failed, db.Name())
+		}
+		reports = append(reports, report)
+	}
+
+	if len(failed) > 0 {
+		return reports, fmt.Errorf("migration failed on %d database(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return reports, nil
+}
+
+// run 加锁后按计划执行
+func (r *Runner) run(ctx context.Context, db *mongo.Database, opts Options, up bool) (*Report, error) {
+	report := &Report{Database: db.Name(), DryRun: opts.DryRun}
+	fail := func(err error) (*Report, error) {
+		report.Err = err
+		log.Errorf("error migrate %v db: %v", err, db.Name())
+		return report, err
+	}
+
+	applied, err := appliedRecords(ctx, db)
+	if err != nil {
+		return fail(err)
+	}
+	steps, err := r.plan(applied, opts.Target, up)
+	if err != nil {
+		return fail(err)
+	}
+	if opts.DryRun || len(steps) == 0 {
+		for _, m := range steps {
+			report.Versions = append(report.Versions, m.Version)
+		}
+		return report, nil
+	}
+
+	ttl := opts.LockTTL
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	owner := lockOwner()
+	if err := acquireLock(ctx, db, owner, ttl); err != nil {
+		return fail(err)
+	}
+	defer releaseLock(db, owner)
+
+	// 加锁后重新读取，防止在加锁前其他进程已执行完
+	if applied, err = appliedRecords(ctx, db); err != nil {
+		return fail(err)
+	}
+	if steps, err = r.plan(applied, opts.Target, up); err != nil {
+		return fail(err)
+	}
+
+	lockCtx, stop := keepLock(ctx, db, owner, ttl)
+	err = apply(lockCtx, db, steps, up, report)
+	if lost := stop(); lost != nil && err != nil {
+		err = fmt.Errorf("%w (migration lock lost: %v)", err, lost)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	return report, nil
+}
+
+// apply 按顺序执行版本并记录结果
+func apply(ctx context.Context, db *mongo.Database, steps []Migration, up bool, report *Report) error {
+	col := db.Collection(Collection)
+	for _, m := range steps {
+		s := time.Now()
+		var err error
+		if up {
+			err = m.Up(ctx, db)
+		} else {
+			err = m.Down(ctx, db)
+		}
+		if err != nil {
+			return fmt.Errorf("version %d %s: %w", m.Version, m.Name, err)
+		}
+
+		if up {
+			_, err = col.InsertOne(ctx, Record{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+				Took:      int64(time.Since(s) / time.Millisecond),
+			})
+		} else {
+			_, err = col.DeleteOne(ctx, bson.M{"_id": m.Version})
+		}
+		if err != nil {
+			return err
+		}
+		report.Versions = append(report.Versions, m.Version)
+		log.Infof("migrate took: %v db: %v version: %v %v up(%v)", time.Since(s), db.Name(), m.Version, m.Name, up)
+	}
+	return nil
+}
+
+// plan 计算需要执行的版本，Up 时从小到大，Down 时从大到小
+func (r *Runner) plan(applied map[int64]Record, target int64, up bool) ([]Migration, error) {
+	var steps []Migration
+	if up {
+		for _, m := range r.migrations {
+			if target > 0 && m.Version > target {
+				break
+			}
+			if _, ok := applied[m.Version]; !ok {
+				steps = append(steps, m)
+			}
+		}
+		return steps, nil
+	}
+
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		m := r.migrations[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return nil, fmt.Errorf("%w: version %d %s", ErrIrreversible, m.Version, m.Name)
+		}
+		steps = append(steps, m)
+	}
+	return steps, nil
+}
+
+// appliedRecords 读取已执行的版本
+// 从 primary 以 majority 读取，避免从延迟的 secondary 读不到其他进程刚写入的记录而重复执行
+func appliedRecords(ctx context.Context, db *mongo.Database) (map[int64]Record, error) {
+	cur, err := primaryCollection(db).Find(ctx, bson.M{"_id": bson.M{"$ne": lockID}})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]Record, len(records))
+	for _, rec := range records {
+		applied[rec.Version] = rec
+	}
+	return applied, nil
+}
+
+// primaryCollection 从 primary 以 majority 读取的 _migrations 集合
+func primaryCollection(db *mongo.Database) *mongo.Collection {
+	return db.Collection(Collection, options.Collection().
+		SetReadPreference(readpref.Primary()).
+		SetReadConcern(readconcern.Majority()))
+}
+
+// tenantDatabases 所有租户的数据库
+func tenantDatabases(ctx context.Context) ([]*mongo.Database, error) {
+	tenants, err := rxmongo.ListTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client := rxmongo.New()
+	var dbs []*mongo.Database
+	for _, tenantID := range tenants {
+		dbs = append(dbs, client.Database(rxmongo.GetDBName(tenantID)))
+	}
+	return dbs, nil
+}
+
+// acquireLock 获取锁，锁已被其他进程持有且未过期时返回 ErrLocked
+func acquireLock(ctx context.Context, db *mongo.Database, owner string, ttl time.Duration) error {
+	now := time.Now()
+	_, err := db.Collection(Collection).UpdateOne(ctx,
+		bson.M{"_id": lockID, "expire_at": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": owner, "expire_at": now.Add(ttl)}},
+		options.Update().SetUpsert(true),
+	)
+	if isDuplicateKey(err) {
+		// 锁存在且未过期时条件不匹配，upsert 插入同一 _id 失败
+		return ErrLocked
+	}
+	return err
+}
+
+// extendLock 延长锁的有效期
+func extendLock(ctx context.Context, db *mongo.Database, owner string, ttl time.Duration) error {
+	res, err := db.Collection(Collection).UpdateOne(ctx,
+		bson.M{"_id": lockID, "owner": owner},
+		bson.M{"$set": bson.M{"expire_at": time.Now().Add(ttl)}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLocked
+	}
+	return nil
+}
+
+// keepLock 在后台每 ttl/3 延长一次锁，直到调用返回的 stop
+// 延长失败时取消返回的 context，stop 返回延长失败的错误
+func keepLock(ctx context.Context, db *mongo.Database, owner string, ttl time.Duration) (context.Context, func() error) {
+	lockCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var lost error
+
+	go func() {
+		defer close(done)
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-lockCtx.Done():
+				return
+			case <-t.C:
+			}
+			if err := extendLock(lockCtx, db, owner, ttl); err != nil {
+				if lockCtx.Err() != nil {
+					return
+				}
+				log.Errorf("error extend migration lock %v db: %v", err, db.Name())
+				lost = err
+				cancel()
+				return
+			}
+		}
+	}()
+
+	return lockCtx, func() error {
+		cancel()
+		<-done
+		return lost
+	}
+}
+
+// releaseLock 释放锁，执行中的 context 可能已取消，这里使用新的 context
+func releaseLock(db *mongo.Database, owner string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := db.Collection(Collection).DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner}); err != nil {
+		log.Errorf("error release migration lock %v db: %v", err, db.Name())
+	}
+}
+
+// lockOwner 锁的持有者，主机名和进程ID
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+// isDuplicateKey 判断是否是主键重复的错误
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	var ce mongo.CommandError
+	return errors.As(err, &ce) && ce.Code == 11000
+}
@@ -0,0 +1,121 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func noop(context.Context, *mongo.Database) error { return nil }
+
+func TestNew(t *testing.T) {
+	r, err := New(
+		Migration{Version: 3, Up: noop},
+		Migration{Version: 1, Up: noop},
+		Migration{Version: 2, Up: noop},
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for i, m := range r.migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("migrations[%d] = %d, want sorted", i, m.Version)
+		}
+	}
+
+	invalid := [][]Migration{
+		{{Version: 1, Up: noop}, {Version: 1, Up: noop}},
+		{{Version: 0, Up: noop}},
+		{{Version: 1}},
+	}
+	for _, ms := range invalid {
+		if _, err := New(ms...); !errors.Is(err, ErrInvalidMigration) {
+			t.Errorf("New(%v) error = %v, want ErrInvalidMigration", ms, err)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	r, _ := New(
+		Migration{Version: 1, Up: noop, Down: noop},
+		Migration{Version: 2, Up: noop},
+		Migration{Version: 3, Up: noop, Down: noop},
+		Migration{Version: 4, Up: noop, Down: noop},
+	)
+	applied := map[int64]Record{1: {Version: 1}, 3: {Version: 3}}
+	all := map[int64]Record{1: {Version: 1}, 2: {Version: 2}, 3: {Version: 3}}
+
+	versions := func(ms []Migration) string {
+		var vs []int64
+		for _, m := range ms {
+			vs = append(vs, m.Version)
+		}
+		return fmt.Sprint(vs)
+	}
+
+	tests := []struct {
+		applied map[int64]Record
+		target  int64
+		up      bool
+		want    string
+		err     error
+	}{
+		{applied, 0, true, "[2 4]", nil},
+		{applied, 2, true, "[2]", nil},
+		{applied, 1, false, "[3]", nil},
+		{applied, 0, false, "[3 1]", nil},
+		{all, 0, false, "", ErrIrreversible},
+	}
+	for _, tt := range tests {
+		got, err := r.plan(tt.applied, tt.target, tt.up)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("plan(%d, %v) error = %v, want %v", tt.target, tt.up, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || versions(got) != tt.want {
+			t.Errorf("plan(%d, %v) = %v, %v, want %v", tt.target, tt.up, versions(got), err, tt.want)
+		}
+	}
+}
+
+func TestIsDuplicateKey(t *testing.T) {
+	dup := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}
+	if !isDuplicateKey(fmt.Errorf("lock: %w", dup)) {
+		t.Errorf("isDuplicateKey() = false for code 11000")
+	}
+	if isDuplicateKey(mongo.CommandError{Code: 48}) || isDuplicateKey(nil) {
+		t.Errorf("isDuplicateKey() = true for other errors")
+	}
+}
+
+func TestKeepLock(t *testing.T) {
+	// 未连接的客户端上延长锁总是失败
+	cli, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	db := cli.Database("Db_t001")
+
+	ctx, stop := keepLock(context.Background(), db, "owner", time.Hour)
+	if err := stop(); err != nil || ctx.Err() == nil {
+		t.Errorf("stop() before extending = %v, ctx.Err() = %v", err, ctx.Err())
+	}
+
+	// 延长失败时取消执行中的 context
+	ctx, stop = keepLock(context.Background(), db, "owner", 30*time.Millisecond)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepLock() should cancel the context when the lock is lost")
+	}
+	if err := stop(); err == nil {
+		t.Errorf("stop() after losing the lock error = nil")
+	}
+}
@@ -0,0 +1,253 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/address"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/description"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+type (
+	// mockCommand 替身收到的命令
+	mockCommand struct {
+		Name string // 命令名，例如 find、update
+		Doc  bson.M // 命令的内容，document sequence 合并到对应的字段
+	}
+
+	// mockMongo 不需要 mongod 的驱动替身，每个命令交给 handle 生成响应，记录收到的命令
+	// handle 为 nil 或返回 nil 时响应 {ok: 1}
+	mockMongo struct {
+		mu       sync.Mutex
+		handle   func(cmd mockCommand) bson.D
+		commands []mockCommand
+		pending  [][]byte
+		updates  chan description.Topology
+	}
+)
+
+const mockAddress = address.Address("mock:27017")
+
+// startMock 把替身设为包的默认连接，返回恢复原连接的函数
+// 替身是副本集的 primary，连接与 defaultOptions 一样默认 secondaryPreferred，命令中带有实际的 $readPreference
+func startMock(t *testing.T, handle func(cmd mockCommand) bson.D) (*mockMongo, func()) {
+	t.Helper()
+	m := &mockMongo{handle: handle}
+
+	opts := options.Client().SetReadPreference(readpref.SecondaryPreferred())
+	opts.Deployment = m
+	cli, err := mongo.NewClient(opts)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if err := cli.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	client, Db = cli, "Db"
+	return m, func() {
+		cli.Disconnect(context.Background())
+		client, Db = nil, ""
+	}
+}
+
+// Commands 取得收到的指定命令，name 为空时返回全部
+func (m *mockMongo) Commands(name string) []mockCommand {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []mockCommand
+	for _, c := range m.commands {
+		if name == "" || c.Name == name {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// cursorResponse 游标命令的响应，id 为 0 时表示没有更多数据
+func cursorResponse(id int64, ns, batch string, docs ...interface{}) bson.D {
+	arr := bson.A{}
+	for _, d := range docs {
+		arr = append(arr, d)
+	}
+	return bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "cursor", Value: bson.D{{Key: "id", Value: id}, {Key: "ns", Value: ns}, {Key: batch, Value: arr}}},
+	}
+}
+
+func (m *mockMongo) SelectServer(context.Context, description.ServerSelector) (driver.Server, error) {
+	return m, nil
+}
+
+func (m *mockMongo) Kind() description.TopologyKind {
+	return description.ReplicaSetWithPrimary
+}
+
+func (m *mockMongo) Connection(context.Context) (driver.Connection, error) {
+	return m, nil
+}
+
+func (m *mockMongo) Connect() error {
+	return nil
+}
+
+func (m *mockMongo) Disconnect(context.Context) error {
+	return nil
+}
+
+func (m *mockMongo) Subscribe() (*driver.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.updates == nil {
+		m.updates = make(chan description.Topology, 1)
+		m.updates <- description.Topology{Kind: description.ReplicaSetWithPrimary, SessionTimeoutMinutes: 30}
+	}
+	return &driver.Subscription{Updates: m.updates}, nil
+}
+
+func (m *mockMongo) Unsubscribe(*driver.Subscription) error {
+	return nil
+}
+
+// WriteWireMessage 解析 OP_MSG 的命令并生成响应
+func (m *mockMongo) WriteWireMessage(_ context.Context, wm []byte) error {
+	cmd, err := parseOpMsg(wm)
+	if err != nil {
+		return err
+	}
+
+	var res bson.D
+	if m.handle != nil {
+		res = m.handle(cmd)
+	}
+	if res == nil {
+		res = bson.D{{Key: "ok", Value: 1}}
+	}
+	body, err := bson.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands = append(m.commands, cmd)
+	m.pending = append(m.pending, body)
+	return nil
+}
+
+// ReadWireMessage 返回上一个命令的响应
+func (m *mockMongo) ReadWireMessage(_ context.Context, dst []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.pending) == 0 {
+		return dst, errors.New("mock: no pending response")
+	}
+	body := m.pending[0]
+	m.pending = m.pending[1:]
+
+	var idx int32
+	idx, dst = wiremessage.AppendHeaderStart(dst, wiremessage.NextRequestID(), 0, wiremessage.OpMsg)
+	dst = wiremessage.AppendMsgFlags(dst, 0)
+	dst = wiremessage.AppendMsgSectionType(dst, wiremessage.SingleDocument)
+	dst = append(dst, body...)
+	return bsoncore.UpdateLength(dst, idx, int32(len(dst[idx:]))), nil
+}
+
+func (m *mockMongo) Description() description.Server {
+	return description.Server{
+		Addr:                  mockAddress,
+		CanonicalAddr:         mockAddress,
+		Kind:                  description.RSPrimary,
+		MaxDocumentSize:       16 << 20,
+		MaxMessageSize:        48000000,
+		MaxBatchCount:         100000,
+		SessionTimeoutMinutes: 30,
+		WireVersion:           &description.VersionRange{Max: 8},
+	}
+}
+
+func (m *mockMongo) Close() error {
+	return nil
+}
+
+func (m *mockMongo) ID() string {
+	return "mock"
+}
+
+func (m *mockMongo) Address() address.Address {
+	return mockAddress
+}
+
+func (m *mockMongo) Stale() bool {
+	return false
+}
+
+// parseOpMsg 取出 OP_MSG 中的命令
+func parseOpMsg(wm []byte) (mockCommand, error) {
+	var cmd mockCommand
+	_, _, _, opcode, rem, ok := wiremessage.ReadHeader(wm)
+	if !ok || opcode != wiremessage.OpMsg {
+		return cmd, errors.New("mock: only OP_MSG is supported")
+	}
+	if _, rem, ok = wiremessage.ReadMsgFlags(rem); !ok {
+		return cmd, errors.New("mock: malformed OP_MSG flags")
+	}
+
+	sequences := make(map[string][]bsoncore.Document)
+	for len(rem) > 0 {
+		var stype wiremessage.SectionType
+		if stype, rem, ok = wiremessage.ReadMsgSectionType(rem); !ok {
+			break
+		}
+		switch stype {
+		case wiremessage.SingleDocument:
+			var doc bsoncore.Document
+			if doc, rem, ok = wiremessage.ReadMsgSectionSingleDocument(rem); !ok {
+				return cmd, errors.New("mock: malformed OP_MSG document")
+			}
+			if err := bson.Unmarshal(doc, &cmd.Doc); err != nil {
+				return cmd, err
+			}
+			elems, err := doc.Elements()
+			if err != nil || len(elems) == 0 {
+				return cmd, errors.New("mock: empty command")
+			}
+			cmd.Name = elems[0].Key()
+		case wiremessage.DocumentSequence:
+			var id string
+			var docs []bsoncore.Document
+			if id, docs, rem, ok = wiremessage.ReadMsgSectionDocumentSequence(rem); !ok {
+				return cmd, errors.New("mock: malformed OP_MSG sequence")
+			}
+			sequences[id] = docs
+		default:
+			return cmd, errors.New("mock: unknown OP_MSG section")
+		}
+	}
+
+	for id, docs := range sequences {
+		arr := bson.A{}
+		for _, d := range docs {
+			var m bson.M
+			if err := bson.Unmarshal(d, &m); err != nil {
+				return cmd, err
+			}
+			arr = append(arr, m)
+		}
+		cmd.Doc[id] = arr
+	}
+	return cmd, nil
+}
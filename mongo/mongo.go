@@ -4,13 +4,9 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
-	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 
 	"rxcsoft.cn/utils/config"
 	"rxcsoft.cn/utils/logger"
@@ -29,49 +25,78 @@ const (
 	MaxPoolSize uint64 = 1000
 )
 
-// StartMongodb 启动mongodb的连接
+// StartMongodb 启动mongodb的连接，失败时 panic
+// 需要处理错误或定制连接配置时请使用 Start
 func StartMongodb(env config.DB) {
-	cfe, host := getMongodbHost(env)
-	hosts := buildHost(host, cfe.Port)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if err := Start(env); err != nil {
+		log.Panic(err)
+	}
+}
 
-	option := options.Client()
-	option.SetHosts(hosts)                   // 设置连接host
-	option.SetReplicaSet(env.ReplicaSetName) // 设置replica name
-	option.SetMaxPoolSize(MaxPoolSize)       // 设置最大连接池的数量
-	option.SetMinPoolSize(100)               // 设定最小连接池大小
-	option.SetRetryReads(true)               // 增加读的重试
-	option.SetRetryWrites(true)              // 增加写的重试
-	// 设置读的偏好
-	r := readpref.SecondaryPreferred()
-	option.SetReadPreference(r)
-	// 设置读隔离
-	rn := readconcern.Local()
-	option.SetReadConcern(rn)
-	// 设置写隔离
-	w := writeconcern.WriteConcern{}
-	w.WithOptions(writeconcern.WMajority())
-	option.SetWriteConcern(&w)
-	if len(env.Username) > 0 && len(env.Password) > 0 {
-		option.SetAuth(
-			options.Credential{ // 设置认证信息
-				AuthSource: env.Source,
-				Username:   env.Username,
-				Password:   env.Password,
-			})
+// Start 按配置连接 mongodb 并设为包的默认连接，连接或 ping 失败时返回错误
+// 配置的优先级：opts > env.Options > 默认值
+// 已经连接时返回 ErrAlreadyStarted，其他包可能还在使用原来的连接，需要重新连接时先调用 Stop
+func Start(env config.DB, opts ...Option) error {
+	if env.Database == "" {
+		return ErrNoDatabase
+	}
+	if client != nil {
+		return ErrAlreadyStarted
 	}
-	cli, err := mongo.Connect(ctx, option)
+
+	cli, err := Connect(env, opts...)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	client = cli
+	setDatabaseName(env)
+	log.Infof("connected to mongodb... %v(db:%s)", env.Host, Db)
+	return nil
+}
+
+// Stop 断开包的默认连接，之后可以再次调用 Start
+func Stop() error {
+	if client == nil {
+		return nil
+	}
 
-	setDatabaseName(cfe)
-	log.Infof("connected to mongodb... %v(db:%s)", host, Db)
-	pingServers(client)
+	err := client.Disconnect(context.Background())
+	client = nil
+	if err != nil {
+		log.Errorf("disconnect mongodb error: %v", err)
+		return err
+	}
+	log.Infof("disconnected from mongodb")
+	return nil
+}
+
+// Connect 创建一个新的连接并 ping 确认可用，不影响包的默认连接
+// 连接多个集群时使用，不再使用时需要调用 Disconnect
+func Connect(env config.DB, opts ...Option) (*mongo.Client, error) {
+	o := defaultOptions()
+	if err := parseOptions(env, &o); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.ConnectTimeout)
+	defer cancel()
+
+	cli, err := mongo.Connect(ctx, clientOptions(env, o))
+	if err != nil {
+		log.Errorf("connect to mongodb error: %v", err)
+		return nil, err
+	}
+
+	if err := pingServers(ctx, cli, o.ReadPreference); err != nil {
+		cli.Disconnect(context.Background())
+		return nil, err
+	}
+
+	return cli, nil
 }
 
 // 构建db连接信息
@@ -95,10 +120,6 @@ func splitMongodbInstances(instances string) []string {
 	return hosts
 }
 
-func getMongodbHost(env config.DB) (config.DB, string) {
-	return env, fmt.Sprintf("%v", env.Host)
-}
-
 // New 返回一个连接
 func New() *mongo.Client {
 	return client
@@ -113,9 +134,13 @@ func GetDBName(suffix string) string {
 	return name.String()
 }
 
-// 打印live情报
-func pingServers(client *mongo.Client) {
-	client.Ping(context.TODO(), &readpref.ReadPref{})
+// pingServers 确认能按读的偏好连接到服务器
+func pingServers(ctx context.Context, client *mongo.Client, rp *readpref.ReadPref) error {
+	if err := client.Ping(ctx, rp); err != nil {
+		log.Errorf("ping mongodb error: %v", err)
+		return err
+	}
+	return nil
 }
 
 // setDatabaseName 设置DB名称
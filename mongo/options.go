@@ -0,0 +1,356 @@
+package mongo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	"rxcsoft.cn/utils/config"
+)
+
+type (
+	// Option 连接配置项
+	Option func(*Options)
+
+	// Options 连接配置，未设置的项使用 defaultOptions 的值
+	Options struct {
+		// MaxPoolSize 最大连接池的数量
+		MaxPoolSize uint64
+		// MinPoolSize 最小连接池的数量
+		MinPoolSize uint64
+		// ReadPreference 读的偏好
+		ReadPreference *readpref.ReadPref
+		// ReadConcern 读隔离
+		ReadConcern *readconcern.ReadConcern
+		// WriteConcern 写隔离
+		WriteConcern *writeconcern.WriteConcern
+		// TLSConfig 为 nil 时不使用 TLS
+		TLSConfig *tls.Config
+		// ConnectTimeout 建立连接的超时时间，同时也是启动时 ping 的超时时间
+		ConnectTimeout time.Duration
+		// ServerSelectionTimeout 选择服务器的超时时间
+		ServerSelectionTimeout time.Duration
+		// SocketTimeout 读写的超时时间，0 表示不超时
+		SocketTimeout time.Duration
+		// AppName 显示在服务端日志和 currentOp 中的应用名
+		AppName string
+		// Compressors 网络传输的压缩方式，可选 snappy、zlib、zstd
+		Compressors []string
+		// Monitor 命令的监视，用于调试时输出执行的命令
+		Monitor *event.CommandMonitor
+	}
+)
+
+var (
+	// ErrNotStarted 未调用 Start 或 StartMongodb 时返回的错误
+	ErrNotStarted = errors.New("mongodb is not started, call Start first")
+	// ErrNoDatabase 没有设置数据库名
+	ErrNoDatabase = errors.New("mongodb database name is not set")
+	// ErrInvalidOption config.DB.Options 中的参数不正确
+	ErrInvalidOption = errors.New("invalid mongodb option")
+	// ErrAlreadyStarted 已经调用过 Start，需要先调用 Stop
+	ErrAlreadyStarted = errors.New("mongodb is already started, call Stop first")
+)
+
+// defaultOptions 默认配置，与原来 StartMongodb 写死的配置相同
+func defaultOptions() Options {
+	return Options{
+		MaxPoolSize:            MaxPoolSize,
+		MinPoolSize:            100,
+		ReadPreference:         readpref.SecondaryPreferred(),
+		ReadConcern:            readconcern.Local(),
+		WriteConcern:           writeconcern.New(writeconcern.WMajority()),
+		ConnectTimeout:         10 * time.Second,
+		ServerSelectionTimeout: 10 * time.Second,
+	}
+}
+
+// WithPoolSize 设置连接池的大小
+func WithPoolSize(min, max uint64) Option {
+	return func(o *Options) {
+		o.MinPoolSize = min
+		o.MaxPoolSize = max
+	}
+}
+
+// WithReadPreference 设置读的偏好
+func WithReadPreference(rp *readpref.ReadPref) Option {
+	return func(o *Options) {
+		o.ReadPreference = rp
+	}
+}
+
+// WithReadConcern 设置读隔离
+func WithReadConcern(rc *readconcern.ReadConcern) Option {
+	return func(o *Options) {
+		o.ReadConcern = rc
+	}
+}
+
+// WithWriteConcern 设置写隔离
+func WithWriteConcern(wc *writeconcern.WriteConcern) Option {
+	return func(o *Options) {
+		o.WriteConcern = wc
+	}
+}
+
+// WithTLS 使用 TLS 连接
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *Options) {
+		o.TLSConfig = cfg
+	}
+}
+
+// WithTimeouts 设置连接、选择服务器和读写的超时时间，传入 0 的项保持不变
+func WithTimeouts(connect, serverSelection, socket time.Duration) Option {
+	return func(o *Options) {
+		if connect > 0 {
+			o.ConnectTimeout = connect
+		}
+		if serverSelection > 0 {
+			o.ServerSelectionTimeout = serverSelection
+		}
+		if socket > 0 {
+			o.SocketTimeout = socket
+		}
+	}
+}
+
+// WithAppName 设置应用名
+func WithAppName(name string) Option {
+	return func(o *Options) {
+		o.AppName = name
+	}
+}
+
+// WithCompressors 设置网络传输的压缩方式
+func WithCompressors(compressors ...string) Option {
+	return func(o *Options) {
+		o.Compressors = compressors
+	}
+}
+
+// WithMonitor 设置命令的监视
+func WithMonitor(m *event.CommandMonitor) Option {
+	return func(o *Options) {
+		o.Monitor = m
+	}
+}
+
+// DebugMonitor 用日志输出每个执行的命令
+func DebugMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			log.Infof("mongodb command %v db: %v %v", evt.CommandName, evt.DatabaseName, evt.Command)
+		},
+	}
+}
+
+// parseOptions 把 config.DB.Options 中的参数写入配置，参数名与 MongoDB 连接字符串相同
+func parseOptions(env config.DB, o *Options) error {
+	invalid := func(key, value string) error {
+		return fmt.Errorf("%w: %s=%s", ErrInvalidOption, key, value)
+	}
+	duration := func(key, value string) (time.Duration, error) {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ms < 0 {
+			return 0, invalid(key, value)
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	// TLS 相关的参数在循环后统一处理，结果不受 map 遍历顺序的影响
+	var (
+		w           *int
+		majority    bool
+		wtimeout    time.Duration
+		tlsOn       *bool
+		tlsInsecure *bool
+		tlsCAFile   string
+	)
+
+	for key, value := range env.Options {
+		var err error
+		switch key {
+		case "maxPoolSize", "minPoolSize":
+			n, e := strconv.ParseUint(value, 10, 64)
+			if e != nil {
+				return invalid(key, value)
+			}
+			if key == "maxPoolSize" {
+				o.MaxPoolSize = n
+			} else {
+				o.MinPoolSize = n
+			}
+		case "readPreference":
+			mode, e := readpref.ModeFromString(value)
+			if e != nil {
+				return invalid(key, value)
+			}
+			if o.ReadPreference, err = readpref.New(mode); err != nil {
+				return invalid(key, value)
+			}
+		case "readConcernLevel":
+			o.ReadConcern = readconcern.New(readconcern.Level(value))
+		case "w":
+			if value == "majority" {
+				majority = true
+				continue
+			}
+			n, e := strconv.Atoi(value)
+			if e != nil {
+				return invalid(key, value)
+			}
+			w = &n
+		case "wtimeoutMS":
+			wtimeout, err = duration(key, value)
+		case "tls", "ssl":
+			on, e := strconv.ParseBool(value)
+			if e != nil {
+				return invalid(key, value)
+			}
+			if tlsOn != nil && *tlsOn != on {
+				return fmt.Errorf("%w: tls and ssl conflict", ErrInvalidOption)
+			}
+			tlsOn = &on
+		case "tlsCAFile":
+			tlsCAFile = value
+		case "tlsInsecure":
+			on, e := strconv.ParseBool(value)
+			if e != nil {
+				return invalid(key, value)
+			}
+			tlsInsecure = &on
+		case "connectTimeoutMS":
+			o.ConnectTimeout, err = duration(key, value)
+		case "serverSelectionTimeoutMS":
+			o.ServerSelectionTimeout, err = duration(key, value)
+		case "socketTimeoutMS":
+			o.SocketTimeout, err = duration(key, value)
+		case "appName":
+			o.AppName = value
+		case "compressors":
+			o.Compressors = strings.Split(value, ",")
+		default:
+			return fmt.Errorf("%w: unknown option %s", ErrInvalidOption, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if w != nil || majority || wtimeout > 0 {
+		var wopts []writeconcern.Option
+		switch {
+		case majority:
+			wopts = append(wopts, writeconcern.WMajority())
+		case w != nil:
+			wopts = append(wopts, writeconcern.W(*w))
+		default:
+			wopts = append(wopts, writeconcern.WMajority())
+		}
+		if wtimeout > 0 {
+			wopts = append(wopts, writeconcern.WTimeout(wtimeout))
+		}
+		o.WriteConcern = writeconcern.New(wopts...)
+	}
+
+	return parseTLS(o, tlsOn, tlsInsecure, tlsCAFile)
+}
+
+// parseTLS 处理 tls/ssl、tlsInsecure 和 tlsCAFile
+// tlsInsecure 或 tlsCAFile 隐含开启 TLS，与 tls=false 同时指定时返回错误
+func parseTLS(o *Options, on, insecure *bool, caFile string) error {
+	if on != nil && !*on {
+		if insecure != nil || caFile != "" {
+			return fmt.Errorf("%w: tlsInsecure and tlsCAFile require tls=true", ErrInvalidOption)
+		}
+		o.TLSConfig = nil
+		return nil
+	}
+	if on == nil && insecure == nil && caFile == "" {
+		return nil
+	}
+
+	if o.TLSConfig == nil {
+		o.TLSConfig = &tls.Config{}
+	}
+	if insecure != nil {
+		o.TLSConfig.InsecureSkipVerify = *insecure
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("%w: tlsCAFile: %v", ErrInvalidOption, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: tlsCAFile: no certificate found in %s", ErrInvalidOption, caFile)
+		}
+		o.TLSConfig.RootCAs = pool
+	}
+
+	return nil
+}
+
+// clientOptions 生成驱动的连接配置
+func clientOptions(env config.DB, o Options) *options.ClientOptions {
+	option := options.Client()
+	option.SetHosts(buildHost(env.Host, env.Port)) // 设置连接host
+	if env.ReplicaSetName != "" {
+		option.SetReplicaSet(env.ReplicaSetName) // 设置replica name
+	}
+	option.SetMaxPoolSize(o.MaxPoolSize) // 设置最大连接池的数量
+	option.SetMinPoolSize(o.MinPoolSize) // 设定最小连接池大小
+	option.SetRetryReads(true)           // 增加读的重试
+	option.SetRetryWrites(true)          // 增加写的重试
+	option.SetConnectTimeout(o.ConnectTimeout)
+	option.SetServerSelectionTimeout(o.ServerSelectionTimeout)
+	if o.SocketTimeout > 0 {
+		option.SetSocketTimeout(o.SocketTimeout)
+	}
+	if o.ReadPreference != nil {
+		option.SetReadPreference(o.ReadPreference)
+	}
+	if o.ReadConcern != nil {
+		option.SetReadConcern(o.ReadConcern)
+	}
+	if o.WriteConcern != nil {
+		option.SetWriteConcern(o.WriteConcern)
+	}
+	if o.TLSConfig != nil {
+		option.SetTLSConfig(o.TLSConfig)
+	}
+	if o.AppName != "" {
+		option.SetAppName(o.AppName)
+	}
+	if len(o.Compressors) > 0 {
+		option.SetCompressors(o.Compressors)
+	}
+	if o.Monitor != nil {
+		option.SetMonitor(o.Monitor)
+	}
+	if len(env.Username) > 0 && len(env.Password) > 0 {
+		option.SetAuth(
+			options.Credential{ // 设置认证信息
+				AuthSource: env.Source,
+				Username:   env.Username,
+				Password:   env.Password,
+			})
+	}
+
+	return option
+}
@@ -0,0 +1,149 @@
+package mongo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"rxcsoft.cn/utils/config"
+)
+
+func TestParseOptions(t *testing.T) {
+	o := defaultOptions()
+	err := parseOptions(config.DB{Options: map[string]string{
+		"maxPoolSize":      "50",
+		"minPoolSize":      "5",
+		"readPreference":   "primary",
+		"readConcernLevel": "majority",
+		"w":                "1",
+		"wtimeoutMS":       "3000",
+		"tlsInsecure":      "true",
+		"connectTimeoutMS": "2500",
+		"appName":          "lease",
+		"compressors":      "zstd,snappy",
+	}}, &o)
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+
+	if o.MaxPoolSize != 50 || o.MinPoolSize != 5 {
+		t.Errorf("pool size = %d/%d, want 5/50", o.MinPoolSize, o.MaxPoolSize)
+	}
+	if o.ReadPreference.Mode() != readpref.PrimaryMode {
+		t.Errorf("ReadPreference = %v, want primary", o.ReadPreference.Mode())
+	}
+	if o.ReadConcern.GetLevel() != "majority" {
+		t.Errorf("ReadConcern = %v, want majority", o.ReadConcern.GetLevel())
+	}
+	if o.WriteConcern.GetW() != 1 || o.WriteConcern.GetWTimeout() != 3*time.Second {
+		t.Errorf("WriteConcern = %v/%v, want 1/3s", o.WriteConcern.GetW(), o.WriteConcern.GetWTimeout())
+	}
+	if o.TLSConfig == nil || !o.TLSConfig.InsecureSkipVerify {
+		t.Errorf("TLSConfig = %+v, want insecure tls", o.TLSConfig)
+	}
+	if o.ConnectTimeout != 2500*time.Millisecond || o.AppName != "lease" || len(o.Compressors) != 2 {
+		t.Errorf("options = %+v", o)
+	}
+
+	for _, opts := range []map[string]string{
+		{"maxPoolSize": "-1"},
+		{"readPreference": "fastest"},
+		{"w": "all"},
+		{"tls": "yes"},
+		{"tlsCAFile": "/not/exists.pem"},
+		{"poolSize": "10"},
+	} {
+		o := defaultOptions()
+		if err := parseOptions(config.DB{Options: opts}, &o); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("parseOptions(%v) error = %v, want ErrInvalidOption", opts, err)
+		}
+	}
+}
+
+func TestParseTLSOptions(t *testing.T) {
+	tests := []struct {
+		opts     map[string]string
+		wantTLS  bool
+		insecure bool
+		wantErr  bool
+	}{
+		{opts: map[string]string{"tls": "false", "appName": "lease", "maxPoolSize": "10"}},
+		{opts: map[string]string{"tls": "true", "tlsInsecure": "true", "appName": "lease"}, wantTLS: true, insecure: true},
+		{opts: map[string]string{"ssl": "true", "tlsInsecure": "false", "appName": "lease"}, wantTLS: true},
+		{opts: map[string]string{"tlsInsecure": "true", "appName": "lease"}, wantTLS: true, insecure: true},
+		{opts: map[string]string{"tls": "false", "tlsInsecure": "true", "appName": "lease"}, wantErr: true},
+		{opts: map[string]string{"tls": "false", "tlsCAFile": "/not/exists.pem", "appName": "lease"}, wantErr: true},
+		{opts: map[string]string{"tls": "true", "ssl": "false"}, wantErr: true},
+	}
+
+	// map 的遍历顺序是随机的，多次执行确认结果不受顺序影响
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			o := defaultOptions()
+			err := parseOptions(config.DB{Options: tt.opts}, &o)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidOption) {
+					t.Fatalf("parseOptions(%v) error = %v, want ErrInvalidOption", tt.opts, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("parseOptions(%v) error = %v", tt.opts, err)
+			}
+			if (o.TLSConfig != nil) != tt.wantTLS {
+				t.Fatalf("parseOptions(%v) TLSConfig = %+v, want tls %v", tt.opts, o.TLSConfig, tt.wantTLS)
+			}
+			if o.TLSConfig != nil && o.TLSConfig.InsecureSkipVerify != tt.insecure {
+				t.Fatalf("parseOptions(%v) InsecureSkipVerify = %v, want %v", tt.opts, o.TLSConfig.InsecureSkipVerify, tt.insecure)
+			}
+		}
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	cli, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client = cli
+	defer func() { client = nil }()
+
+	if err := Start(config.DB{Host: "127.0.0.1", Port: "1", Database: "test"}); err != ErrAlreadyStarted {
+		t.Errorf("Start() twice error = %v, want ErrAlreadyStarted", err)
+	}
+	if New() != cli {
+		t.Errorf("Start() twice should keep the default client")
+	}
+
+	// 未连接的客户端断开时返回错误，但仍然清除默认连接
+	Stop()
+	if New() != nil {
+		t.Errorf("Stop() should clear the default client")
+	}
+	if err := Stop(); err != nil {
+		t.Errorf("Stop() without client error = %v", err)
+	}
+}
+
+func TestStartFailsFast(t *testing.T) {
+	if err := Start(config.DB{Host: "127.0.0.1", Port: "1"}); err != ErrNoDatabase {
+		t.Errorf("Start() without database error = %v, want ErrNoDatabase", err)
+	}
+
+	start := time.Now()
+	err := Start(config.DB{Host: "127.0.0.1", Port: "1", Database: "test"},
+		WithTimeouts(0, 200*time.Millisecond, 0))
+	if err == nil {
+		t.Fatalf("Start() with unreachable server should fail")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Start() took %v, should fail after server selection timeout", time.Since(start))
+	}
+	if New() != nil {
+		t.Errorf("failed Start() should not set the default client")
+	}
+}
@@ -0,0 +1,370 @@
+package mongo
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"rxcsoft.cn/utils/timex"
+)
+
+type (
+	// Pipeline 聚合管道的构建器，方法可以链式调用
+	// 构建中的错误（例如日期解析失败）保存到 Err，执行时返回
+	//
+	//	p := NewPipeline().
+	//		MatchDateRange("created_at", "2020-04-01", "2020-04-30", jst).
+	//		Group(DateFormat("$created_at", DateDay, "Asia/Tokyo"), Sum("total", "$amount"), Count("n")).
+	//		Sort("_id")
+	//	err := C("lease").Aggregate(ctx, p, &rows)
+	Pipeline struct {
+		stages mongo.Pipeline
+		err    error
+	}
+
+	// Accumulator $group 的一个输出字段
+	Accumulator struct {
+		Field string      // 输出的字段名
+		Op    string      // 操作符，例如 $sum
+		Expr  interface{} // 操作符的参数
+	}
+)
+
+const (
+	// DateDay 按日的日期格式，用于 DateFormat
+	DateDay = "%Y-%m-%d"
+	// DateMonth 按月的日期格式
+	DateMonth = "%Y-%m"
+	// DateYear 按年的日期格式
+	DateYear = "%Y"
+)
+
+// Explain 的详细程度
+const (
+	ExplainQueryPlanner     = "queryPlanner"
+	ExplainExecutionStats   = "executionStats"
+	ExplainAllPlansExecuted = "allPlansExecution"
+)
+
+// NewPipeline 创建聚合管道
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Stages 获取管道的各阶段，可以直接传给驱动
+func (p *Pipeline) Stages() mongo.Pipeline {
+	return p.stages
+}
+
+// Err 构建中发生的第一个错误
+func (p *Pipeline) Err() error {
+	return p.err
+}
+
+// String 管道的 JSON 表示，用于调试
+func (p *Pipeline) String() string {
+	b, err := bson.MarshalExtJSON(bson.M{"pipeline": p.stages}, false, false)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// Stage 添加任意阶段，用于构建器没有提供的阶段
+func (p *Pipeline) Stage(name string, value interface{}) *Pipeline {
+	p.stages = append(p.stages, bson.D{{Key: name, Value: value}})
+	return p
+}
+
+// Match 添加 $match 阶段
+func (p *Pipeline) Match(filter interface{}) *Pipeline {
+	return p.Stage("$match", filter)
+}
+
+// MatchDateRange 添加按日期范围过滤的 $match 阶段，参数见 DateRange
+func (p *Pipeline) MatchDateRange(field, from, to string, loc *time.Location) *Pipeline {
+	filter, err := DateRange(field, from, to, loc)
+	if err != nil {
+		if p.err == nil {
+			p.err = err
+		}
+		return p
+	}
+	return p.Match(filter)
+}
+
+// Group 添加 $group 阶段，id 为 nil 时合计全部数据
+func (p *Pipeline) Group(id interface{}, accs ...Accumulator) *Pipeline {
+	group := bson.D{{Key: "_id", Value: id}}
+	for _, acc := range accs {
+		group = append(group, bson.E{Key: acc.Field, Value: bson.D{{Key: acc.Op, Value: acc.Expr}}})
+	}
+	return p.Stage("$group", group)
+}
+
+// Lookup 添加按字段关联的 $lookup 阶段
+func (p *Pipeline) Lookup(from, localField, foreignField, as string) *Pipeline {
+	return p.Stage("$lookup", bson.D{
+		{Key: "from", Value: from},
+		{Key: "localField", Value: localField},
+		{Key: "foreignField", Value: foreignField},
+		{Key: "as", Value: as},
+	})
+}
+
+// LookupPipeline 添加使用子管道的 $lookup 阶段，子管道中用 $$变量名 引用 let 的变量
+func (p *Pipeline) LookupPipeline(from string, let bson.M, pipeline *Pipeline, as string) *Pipeline {
+	if pipeline.err != nil && p.err == nil {
+		p.err = pipeline.err
+	}
+	lookup := bson.D{{Key: "from", Value: from}}
+	if len(let) > 0 {
+		lookup = append(lookup, bson.E{Key: "let", Value: let})
+	}
+	lookup = append(lookup,
+		bson.E{Key: "pipeline", Value: pipeline.stagesOrEmpty()},
+		bson.E{Key: "as", Value: as},
+	)
+	return p.Stage("$lookup", lookup)
+}
+
+// Unwind 添加 $unwind 阶段，path 不需要 $ 前缀，preserveEmpty 为 true 时保留数组为空或不存在的数据
+func (p *Pipeline) Unwind(path string, preserveEmpty bool) *Pipeline {
+	if !strings.HasPrefix(path, "$") {
+		path = "$" + path
+	}
+	if !preserveEmpty {
+		return p.Stage("$unwind", path)
+	}
+	return p.Stage("$unwind", bson.D{
+		{Key: "path", Value: path},
+		{Key: "preserveNullAndEmptyArrays", Value: true},
+	})
+}
+
+// Project 添加 $project 阶段
+func (p *Pipeline) Project(fields interface{}) *Pipeline {
+	return p.Stage("$project", fields)
+}
+
+// Facet 添加 $facet 阶段，在同一批数据上执行多个子管道，例如同时取得明细和合计
+func (p *Pipeline) Facet(facets map[string]*Pipeline) *Pipeline {
+	var names []string
+	for name := range facets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	facet := bson.D{}
+	for _, name := range names {
+		sub := facets[name]
+		if sub.err != nil && p.err == nil {
+			p.err = sub.err
+		}
+		facet = append(facet, bson.E{Key: name, Value: sub.stagesOrEmpty()})
+	}
+	return p.Stage("$facet", facet)
+}
+
+// Sort 添加 $sort 阶段，与 mgo 相同 "-" 开头的字段为降序
+func (p *Pipeline) Sort(fields ...string) *Pipeline {
+	keys := bson.D{}
+	for _, f := range fields {
+		if strings.HasPrefix(f, "-") {
+			keys = append(keys, bson.E{Key: f[1:], Value: -1})
+		} else {
+			keys = append(keys, bson.E{Key: strings.TrimPrefix(f, "+"), Value: 1})
+		}
+	}
+	return p.Stage("$sort", keys)
+}
+
+// Skip 添加 $skip 阶段
+func (p *Pipeline) Skip(n int64) *Pipeline {
+	return p.Stage("$skip", n)
+}
+
+// Limit 添加 $limit 阶段
+func (p *Pipeline) Limit(n int64) *Pipeline {
+	return p.Stage("$limit", n)
+}
+
+// stagesOrEmpty 子管道为空时也需要传空数组
+func (p *Pipeline) stagesOrEmpty() mongo.Pipeline {
+	if p.stages == nil {
+		return mongo.Pipeline{}
+	}
+	return p.stages
+}
+
+// Sum $sum 合计，expr 为 "$字段名" 或表达式
+func Sum(field string, expr interface{}) Accumulator {
+	return Accumulator{Field: field, Op: "$sum", Expr: expr}
+}
+
+// Count 件数
+func Count(field string) Accumulator {
+	return Accumulator{Field: field, Op: "$sum", Expr: 1}
+}
+
+// Avg $avg 平均值
+func Avg(field string, expr interface{}) Accumulator {
+	return Accumulator{Field: field, Op: "$avg", Expr: expr}
+}
+
+// Min $min 最小值
+func Min(field string, expr interface{}) Accumulator {
+	return Accumulator{Field: field, Op: "$min", Expr: expr}
+}
+
+// Max $max 最大值
+func Max(field string, expr interface{}) Accumulator {
+	return Accumulator{Field: field, Op: "$max", Expr: expr}
+}
+
+// First $first 每组的第一个值，需要先排序
+func First(field string, expr interface{}) Accumulator {
+	return Accumulator{Field: field, Op: "$first", Expr: expr}
+}
+
+// Last $last 每组的最后一个值，需要先排序
+func Last(field string, expr interface{}) Accumulator {
+	return Accumulator{Field: field, Op: "$last", Expr: expr}
+}
+
+// Push $push 把每组的值放入数组
+func Push(field string, expr interface{}) Accumulator {
+	return Accumulator{Field: field, Op: "$push", Expr: expr}
+}
+
+// AddToSet $addToSet 把每组不重复的值放入数组
+func AddToSet(field string, expr interface{}) Accumulator {
+	return Accumulator{Field: field, Op: "$addToSet", Expr: expr}
+}
+
+// DateRange 按日期范围过滤的条件，from、to 为 timex 能解析的日期，例如 "2020-04-01"、"2020/4/1"
+// 范围包括 to 的整天，为空的一端不限制，loc 为 nil 时按 UTC 计算日期的边界
+func DateRange(field, from, to string, loc *time.Location) (bson.M, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	cond := bson.D{}
+	if from != "" {
+		t, err := parseDate(from, loc)
+		if err != nil {
+			return nil, err
+		}
+		cond = append(cond, bson.E{Key: "$gte", Value: t})
+	}
+	if to != "" {
+		t, err := parseDate(to, loc)
+		if err != nil {
+			return nil, err
+		}
+		cond = append(cond, bson.E{Key: "$lt", Value: t.AddDate(0, 0, 1)})
+	}
+	if len(cond) == 0 {
+		return bson.M{}, nil
+	}
+	return bson.M{field: cond}, nil
+}
+
+// DateFormat 把日期转换为字符串的表达式，用于按日、按月分组，timezone 为空时按 UTC
+func DateFormat(expr interface{}, format, timezone string) bson.D {
+	args := bson.D{{Key: "date", Value: expr}, {Key: "format", Value: format}}
+	if timezone != "" {
+		args = append(args, bson.E{Key: "timezone", Value: timezone})
+	}
+	return bson.D{{Key: "$dateToString", Value: args}}
+}
+
+// parseDate 用 timex 解析日期，返回 loc 时区的当天零点
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	t, err := timex.ToTimeE(s)
+	if err != nil {
+		return t, err
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc), nil
+}
+
+// AggregateEach 执行聚合，用游标逐条遍历结果，fn 中用 cur.Decode 解码当前数据，返回错误时停止遍历
+func (r *Repository) AggregateEach(ctx context.Context, pipeline interface{}, fn func(cur *mongo.Cursor) error, opts ...*options.AggregateOptions) error {
+	c, err := r.Collection()
+	if err != nil {
+		return err
+	}
+	stages, err := pipelineStages(pipeline)
+	if err != nil {
+		return err
+	}
+
+	s := time.Now()
+	cur, err := c.Aggregate(ctx, stages, opts...)
+	if err != nil {
+		log.Errorf("error AggregateEach %v collection: %v", err, r.name)
+		return err
+	}
+	defer cur.Close(ctx)
+
+	n := 0
+	for cur.Next(ctx) {
+		if err := fn(cur); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := cur.Err(); err != nil {
+		log.Errorf("error AggregateEach %v collection: %v", err, r.name)
+		return err
+	}
+
+	log.Infof("AggregateEach took: %v collection: %v items(%v)", time.Since(s), r.name, n)
+	return nil
+}
+
+// Explain 获取聚合的执行计划，verbosity 为空时使用 ExplainQueryPlanner
+func (r *Repository) Explain(ctx context.Context, pipeline interface{}, verbosity string) (bson.M, error) {
+	client := New()
+	if client == nil {
+		return nil, ErrNotStarted
+	}
+	stages, err := pipelineStages(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if verbosity == "" {
+		verbosity = ExplainQueryPlanner
+	}
+
+	cmd := bson.D{
+		{Key: "explain", Value: bson.D{
+			{Key: "aggregate", Value: r.name},
+			{Key: "pipeline", Value: stages},
+			{Key: "cursor", Value: bson.D{}},
+		}},
+		{Key: "verbosity", Value: verbosity},
+	}
+	var result bson.M
+	if err := client.Database(r.Database()).RunCommand(ctx, cmd).Decode(&result); err != nil {
+		log.Errorf("error Explain %v collection: %v", err, r.name)
+		return nil, err
+	}
+	return result, nil
+}
+
+// pipelineStages 取出 *Pipeline 的各阶段，其他类型原样交给驱动
+func pipelineStages(pipeline interface{}) (interface{}, error) {
+	if p, ok := pipeline.(*Pipeline); ok {
+		if p.err != nil {
+			return nil, p.err
+		}
+		return p.stagesOrEmpty(), nil
+	}
+	return pipeline, nil
+}
@@ -0,0 +1,91 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPipeline(t *testing.T) {
+	jst := time.FixedZone("JST", 9*3600)
+	p := NewPipeline().
+		MatchDateRange("created_at", "2020-04-01", "2020/4/30", jst).
+		Lookup("customer", "customer_id", "_id", "customer").
+		Unwind("customer", true).
+		Group(DateFormat("$created_at", DateDay, "Asia/Tokyo"), Sum("total", "$amount"), Count("n")).
+		Sort("-total", "_id").
+		Limit(10)
+
+	want := `{"pipeline":[` +
+		`{"$match":{"created_at":{"$gte":{"$date":"2020-03-31T15:00:00Z"},"$lt":{"$date":"2020-04-30T15:00:00Z"}}}},` +
+		`{"$lookup":{"from":"customer","localField":"customer_id","foreignField":"_id","as":"customer"}},` +
+		`{"$unwind":{"path":"$customer","preserveNullAndEmptyArrays":true}},` +
+		`{"$group":{"_id":{"$dateToString":{"date":"$created_at","format":"%Y-%m-%d","timezone":"Asia/Tokyo"}},"total":{"$sum":"$amount"},"n":{"$sum":1}}},` +
+		`{"$sort":{"total":-1,"_id":1}},` +
+		`{"$limit":10}]}`
+	if got := p.String(); got != want {
+		t.Errorf("Pipeline =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestPipelineFacet(t *testing.T) {
+	p := NewPipeline().
+		Match(bson.M{"status": "active"}).
+		Facet(map[string]*Pipeline{
+			"rows":  NewPipeline().Sort("no").Skip(20).Limit(10).Project(bson.M{"no": 1}),
+			"total": NewPipeline().Group(nil, Count("n")),
+		})
+
+	want := `{"pipeline":[{"$match":{"status":"active"}},{"$facet":{` +
+		`"rows":[{"$sort":{"no":1}},{"$skip":20},{"$limit":10},{"$project":{"no":1}}],` +
+		`"total":[{"$group":{"_id":null,"n":{"$sum":1}}}]}}]}`
+	if got := p.String(); got != want {
+		t.Errorf("Pipeline =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	p := NewPipeline().MatchDateRange("created_at", "2020-13-45", "", nil).Limit(1)
+	if p.Err() == nil {
+		t.Fatal("Err() = nil, want parse error")
+	}
+	if len(p.Stages()) != 1 {
+		t.Errorf("Stages() = %v, want only $limit", p.Stages())
+	}
+
+	outer := NewPipeline().Facet(map[string]*Pipeline{"x": p})
+	if outer.Err() != p.Err() {
+		t.Errorf("Facet Err() = %v, want %v", outer.Err(), p.Err())
+	}
+
+	if _, err := pipelineStages(p); err != p.Err() {
+		t.Errorf("pipelineStages() error = %v, want %v", err, p.Err())
+	}
+	var rows []bson.M
+	if err := C("lease").Aggregate(context.Background(), p, &rows); err == nil {
+		t.Error("Aggregate() error = nil")
+	}
+}
+
+func TestDateRange(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"", "", `{}`},
+		{"20200401", "", `{"d":{"$gte":{"$date":"2020-04-01T00:00:00Z"}}}`},
+		{"", "2020.4.30", `{"d":{"$lt":{"$date":"2020-05-01T00:00:00Z"}}}`},
+	}
+	for _, tt := range tests {
+		got, err := DateRange("d", tt.from, tt.to, nil)
+		if err != nil {
+			t.Fatalf("DateRange(%q, %q) error = %v", tt.from, tt.to, err)
+		}
+		b, _ := bson.MarshalExtJSON(got, false, false)
+		if string(b) != tt.want {
+			t.Errorf("DateRange(%q, %q) = %s, want %s", tt.from, tt.to, b, tt.want)
+		}
+	}
+}
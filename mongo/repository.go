@@ -0,0 +1,390 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type (
+	// Repository 一个集合的读写封装，输出与 database 包的 mgo 函数相同的耗时日志
+	// 方法的 ctx 可以直接传入 WithTransaction 的 sessCtx，在事务中执行
+	// results 参数传入切片的指针，例如 &[]Lease{}，按集合的结构体解码
+	// 用 WithAudit 设置 AuditSink 后，写入方法同时保存审计记录
+	Repository struct {
+		db      string
+		handle  *mongo.Database // 为 nil 时使用包的默认连接
+		name    string
+		audit   AuditSink
+		primary bool // 从 primary 读取，不使用连接的读偏好
+	}
+
+	// Page 分页查询的结果
+	Page struct {
+		Total int64 // 满足条件的总件数
+		Page  int64 // 当前页，从 1 开始
+		Size  int64 // 每页件数
+	}
+)
+
+// NewRepository 创建集合的仓库，db 为空时使用 Start 配置的数据库
+func NewRepository(db, collection string) *Repository {
+	return &Repository{db: db, name: collection}
+}
+
+// RepositoryOf 在已有的数据库句柄上创建集合的仓库，不使用包的默认连接
+func RepositoryOf(db *mongo.Database, collection string) *Repository {
+	return &Repository{db: db.Name(), handle: db, name: collection}
+}
+
+// C 使用默认数据库的集合
+func C(collection string) *Repository {
+	return NewRepository("", collection)
+}
+
+// Name 集合名
+func (r *Repository) Name() string {
+	return r.name
+}
+
+// Database 数据库名
+func (r *Repository) Database() string {
+	if r.db == "" {
+		return Db
+	}
+	return r.db
+}
+
+// Collection 获取驱动的集合
+func (r *Repository) Collection() (*mongo.Collection, error) {
+	var opts []*options.CollectionOptions
+	if r.primary {
+		opts = append(opts, options.Collection().SetReadPreference(readpref.Primary()))
+	}
+
+	if r.handle != nil {
+		return r.handle.Collection(r.name, opts...), nil
+	}
+	client := New()
+	if client == nil {
+		return nil, ErrNotStarted
+	}
+	return client.Database(r.Database()).Collection(r.name, opts...), nil
+}
+
+// Insert 插入数据，返回插入的 _id
+func (r *Repository) Insert(ctx context.Context, docs ...interface{}) ([]interface{}, error) {
+	if r.audit != nil {
+		return r.auditedInsert(ctx, docs)
+	}
+
+	c, err := r.Collection()
+	if err != nil {
+		return nil, err
+	}
+
+	s := time.Now()
+	var ids []interface{}
+	if len(docs) == 1 {
+		res, e := c.InsertOne(ctx, docs[0])
+		if e == nil {
+			ids = []interface{}{res.InsertedID}
+		}
+		err = e
+	} else {
+		res, e := c.InsertMany(ctx, docs)
+		if res != nil {
+			ids = res.InsertedIDs
+		}
+		err = e
+	}
+	if err != nil {
+		log.Errorf("error Insert %v collection: %v", err, r.name)
+		return ids, err
+	}
+
+	log.Infof("Insert took: %v collection: %v len(%v)", time.Since(s), r.name, len(docs))
+	return ids, nil
+}
+
+// FindOne 查找一条数据，不存在时返回 mongo.ErrNoDocuments
+func (r *Repository) FindOne(ctx context.Context, filter, result interface{}, opts ...*options.FindOneOptions) error {
+	c, err := r.Collection()
+	if err != nil {
+		return err
+	}
+
+	s := time.Now()
+	if err := c.FindOne(ctx, filter, opts...).Decode(result); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Errorf("error FindOne %v collection: %v", err, r.name)
+		}
+		return err
+	}
+
+	log.Infof("FindOne took: %v collection: %v", time.Since(s), r.name)
+	return nil
+}
+
+// Find 查找数据，解码到 results 指向的切片
+func (r *Repository) Find(ctx context.Context, filter, results interface{}, opts ...*options.FindOptions) error {
+	c, err := r.Collection()
+	if err != nil {
+		return err
+	}
+
+	s := time.Now()
+	cur, err := c.Find(ctx, filter, opts...)
+	if err != nil {
+		log.Errorf("error Find %v collection: %v", err, r.name)
+		return err
+	}
+	if err := cur.All(ctx, results); err != nil {
+		log.Errorf("error Find %v collection: %v", err, r.name)
+		return err
+	}
+
+	log.Infof("Find took: %v collection: %v", time.Since(s), r.name)
+	return nil
+}
+
+// FindPage 分页查找，page 从 1 开始，sort 为空时按 _id 排序以保证分页稳定
+func (r *Repository) FindPage(ctx context.Context, filter, sort interface{}, page, size int64, results interface{}) (*Page, error) {
+	total, err := r.Count(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.Find(ctx, filter, results, pageOptions(sort, page, size)); err != nil {
+		return nil, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	return &Page{Total: total, Page: page, Size: size}, nil
+}
+
+// Each 用游标逐条遍历数据，fn 中用 cur.Decode 解码当前数据，返回错误时停止遍历
+// 适合数据量大、不能一次读入内存的场合
+func (r *Repository) Each(ctx context.Context, filter interface{}, fn func(cur *mongo.Cursor) error, opts ...*options.FindOptions) error {
+	c, err := r.Collection()
+	if err != nil {
+		return err
+	}
+
+	s := time.Now()
+	cur, err := c.Find(ctx, filter, opts...)
+	if err != nil {
+		log.Errorf("error Each %v collection: %v", err, r.name)
+		return err
+	}
+	defer cur.Close(ctx)
+
+	n := 0
+	for cur.Next(ctx) {
+		if err := fn(cur); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := cur.Err(); err != nil {
+		log.Errorf("error Each %v collection: %v", err, r.name)
+		return err
+	}
+
+	log.Infof("Each took: %v collection: %v items(%v)", time.Since(s), r.name, n)
+	return nil
+}
+
+// Update 更新一条数据，返回匹配的件数（MatchedCount），与 UpdateMany 相同，内容没有变化的文档也计入
+func (r *Repository) Update(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (int64, error) {
+	if r.audit != nil {
+		return r.auditedUpdate(ctx, filter, update, opts)
+	}
+
+	res, err := r.updateOne(ctx, filter, update, opts)
+	if err != nil {
+		return 0, err
+	}
+	return res.MatchedCount, nil
+}
+
+// updateOne 更新一条数据，返回驱动的结果，upsert 时可以取得插入的 _id
+func (r *Repository) updateOne(ctx context.Context, filter, update interface{}, opts []*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	c, err := r.Collection()
+	if err != nil {
+		return nil, err
+	}
+
+	s := time.Now()
+	res, err := c.UpdateOne(ctx, filter, update, opts...)
+	if err != nil {
+		log.Errorf("error Update %v collection: %v", err, r.name)
+		return nil, err
+	}
+
+	log.Infof("Update took: %v collection: %v", time.Since(s), r.name)
+	return res, nil
+}
+
+// UpdateMany 更新所有满足条件的数据，返回匹配的件数（MatchedCount），与 Update 相同，内容没有变化的文档也计入
+func (r *Repository) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (int64, error) {
+	if r.audit != nil {
+		return r.auditedUpdateMany(ctx, filter, update, opts)
+	}
+
+	res, err := r.updateMany(ctx, filter, update, opts)
+	if err != nil {
+		return 0, err
+	}
+	return res.MatchedCount, nil
+}
+
+// updateMany 更新所有满足条件的数据，返回驱动的结果，upsert 时可以取得插入的 _id
+func (r *Repository) updateMany(ctx context.Context, filter, update interface{}, opts []*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	c, err := r.Collection()
+	if err != nil {
+		return nil, err
+	}
+
+	s := time.Now()
+	res, err := c.UpdateMany(ctx, filter, update, opts...)
+	if err != nil {
+		log.Errorf("error UpdateMany %v collection: %v", err, r.name)
+		return nil, err
+	}
+
+	log.Infof("UpdateMany took: %v collection: %v items(%v)", time.Since(s), r.name, res.MatchedCount)
+	return res, nil
+}
+
+// Upsert 更新一条数据，不存在时插入，返回插入的 _id，更新时为 nil
+func (r *Repository) Upsert(ctx context.Context, filter, update interface{}) (interface{}, error) {
+	if r.audit != nil {
+		return r.auditedUpsert(ctx, filter, update)
+	}
+
+	c, err := r.Collection()
+	if err != nil {
+		return nil, err
+	}
+
+	s := time.Now()
+	res, err := c.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Errorf("error Upsert %v collection: %v", err, r.name)
+		return nil, err
+	}
+
+	log.Infof("Upsert took: %v collection: %v", time.Since(s), r.name)
+	return res.UpsertedID, nil
+}
+
+// Delete 删除一条数据，返回删除的件数
+func (r *Repository) Delete(ctx context.Context, filter interface{}) (int64, error) {
+	if r.audit != nil {
+		return r.auditedDelete(ctx, filter)
+	}
+
+	c, err := r.Collection()
+	if err != nil {
+		return 0, err
+	}
+
+	s := time.Now()
+	res, err := c.DeleteOne(ctx, filter)
+	if err != nil {
+		log.Errorf("error Delete %v collection: %v", err, r.name)
+		return 0, err
+	}
+
+	log.Infof("Delete took: %v collection: %v", time.Since(s), r.name)
+	return res.DeletedCount, nil
+}
+
+// DeleteMany 删除所有满足条件的数据，返回删除的件数
+func (r *Repository) DeleteMany(ctx context.Context, filter interface{}) (int64, error) {
+	if r.audit != nil {
+		return r.auditedDeleteMany(ctx, filter)
+	}
+
+	c, err := r.Collection()
+	if err != nil {
+		return 0, err
+	}
+
+	s := time.Now()
+	res, err := c.DeleteMany(ctx, filter)
+	if err != nil {
+		log.Errorf("error DeleteMany %v collection: %v", err, r.name)
+		return 0, err
+	}
+
+	log.Infof("DeleteMany took: %v collection: %v items(%v)", time.Since(s), r.name, res.DeletedCount)
+	return res.DeletedCount, nil
+}
+
+// Count 获取满足条件的件数
+func (r *Repository) Count(ctx context.Context, filter interface{}) (int64, error) {
+	c, err := r.Collection()
+	if err != nil {
+		return 0, err
+	}
+
+	s := time.Now()
+	count, err := c.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Errorf("error Count %v collection: %v", err, r.name)
+		return 0, err
+	}
+
+	log.Infof("Count took: %v collection: %v items(%v)", time.Since(s), r.name, count)
+	return count, nil
+}
+
+// Aggregate 执行聚合，解码到 results 指向的切片，pipeline 可以是 *Pipeline 或驱动支持的类型
+func (r *Repository) Aggregate(ctx context.Context, pipeline, results interface{}, opts ...*options.AggregateOptions) error {
+	c, err := r.Collection()
+	if err != nil {
+		return err
+	}
+	stages, err := pipelineStages(pipeline)
+	if err != nil {
+		return err
+	}
+
+	s := time.Now()
+	cur, err := c.Aggregate(ctx, stages, opts...)
+	if err != nil {
+		log.Errorf("error Aggregate %v collection: %v", err, r.name)
+		return err
+	}
+	if err := cur.All(ctx, results); err != nil {
+		log.Errorf("error Aggregate %v collection: %v", err, r.name)
+		return err
+	}
+
+	log.Infof("Aggregate took: %v collection: %v", time.Since(s), r.name)
+	return nil
+}
+
+// pageOptions 分页查询的配置
+func pageOptions(sort interface{}, page, size int64) *options.FindOptions {
+	if page < 1 {
+		page = 1
+	}
+	if sort == nil {
+		sort = bson.D{{Key: "_id", Value: 1}}
+	}
+
+	opts := options.Find().SetSort(sort)
+	if size > 0 {
+		opts.SetSkip((page - 1) * size).SetLimit(size)
+	}
+	return opts
+}
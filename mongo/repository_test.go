@@ -0,0 +1,161 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestPageOptions(t *testing.T) {
+	tests := []struct {
+		page, size  int64
+		skip, limit int64
+	}{
+		{1, 20, 0, 20},
+		{3, 20, 40, 20},
+		{0, 10, 0, 10},
+	}
+	for _, tt := range tests {
+		o := pageOptions(nil, tt.page, tt.size)
+		if *o.Skip != tt.skip || *o.Limit != tt.limit {
+			t.Errorf("pageOptions(%d, %d) = skip %d limit %d, want %d %d", tt.page, tt.size, *o.Skip, *o.Limit, tt.skip, tt.limit)
+		}
+		if sort, ok := o.Sort.(bson.D); !ok || sort[0].Key != "_id" {
+			t.Errorf("pageOptions() default sort = %v, want _id", o.Sort)
+		}
+	}
+
+	if o := pageOptions(bson.M{"name": 1}, 2, 0); o.Skip != nil || o.Limit != nil {
+		t.Errorf("pageOptions() with size 0 should not limit")
+	}
+}
+
+func TestRepositoryNotStarted(t *testing.T) {
+	Db = "pit3"
+	defer func() { Db = "" }()
+
+	r := C("lease")
+	if r.Database() != "pit3" || NewRepository("pit3_t1", "lease").Database() != "pit3_t1" {
+		t.Errorf("Database() = %v", r.Database())
+	}
+	if _, err := r.Count(context.Background(), bson.M{}); err != ErrNotStarted {
+		t.Errorf("Count() error = %v, want ErrNotStarted", err)
+	}
+}
+
+func TestFindPage(t *testing.T) {
+	m, done := startMock(t, func(cmd mockCommand) bson.D {
+		switch cmd.Name {
+		case "aggregate":
+			return cursorResponse(0, "Db.lease", "firstBatch", bson.M{"_id": 1, "n": 45})
+		case "find":
+			return cursorResponse(0, "Db.lease", "firstBatch", bson.M{"_id": 41}, bson.M{"_id": 42})
+		}
+		return nil
+	})
+	defer done()
+
+	tests := []struct {
+		page, size int64
+		want       Page
+		skip       interface{}
+	}{
+		{3, 20, Page{Total: 45, Page: 3, Size: 20}, int64(40)},
+		{0, 20, Page{Total: 45, Page: 1, Size: 20}, int64(0)},
+	}
+	for _, tt := range tests {
+		var docs []bson.M
+		p, err := C("lease").FindPage(context.Background(), bson.M{"status": "active"}, nil, tt.page, tt.size, &docs)
+		if err != nil {
+			t.Fatalf("FindPage() error = %v", err)
+		}
+		if *p != tt.want || len(docs) != 2 {
+			t.Errorf("FindPage(%d, %d) = %+v docs(%d), want %+v", tt.page, tt.size, *p, len(docs), tt.want)
+		}
+
+		finds := m.Commands("find")
+		find := finds[len(finds)-1].Doc
+		if find["skip"] != tt.skip || find["limit"] != tt.size || fmt.Sprint(find["sort"]) != "map[_id:1]" {
+			t.Errorf("FindPage(%d, %d) find = %v", tt.page, tt.size, find)
+		}
+		if fmt.Sprint(find["filter"]) != "map[status:active]" {
+			t.Errorf("FindPage() filter = %v", find["filter"])
+		}
+	}
+}
+
+func TestEach(t *testing.T) {
+	m, done := startMock(t, func(cmd mockCommand) bson.D {
+		switch cmd.Name {
+		case "find":
+			return cursorResponse(42, "Db.lease", "firstBatch", bson.M{"_id": 1}, bson.M{"_id": 2})
+		case "getMore":
+			return cursorResponse(0, "Db.lease", "nextBatch", bson.M{"_id": 3})
+		}
+		return nil
+	})
+	defer done()
+
+	ctx := context.Background()
+	var ids []int32
+	err := C("lease").Each(ctx, bson.M{}, func(cur *mongo.Cursor) error {
+		var doc struct {
+			ID int32 `bson:"_id"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		ids = append(ids, doc.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Each() error = %v", err)
+	}
+	if fmt.Sprint(ids) != "[1 2 3]" || len(m.Commands("getMore")) != 1 {
+		t.Errorf("Each() ids = %v getMore(%d)", ids, len(m.Commands("getMore")))
+	}
+	if n := len(m.Commands("killCursors")); n != 0 {
+		t.Errorf("exhausted cursor should not be killed, killCursors(%d)", n)
+	}
+
+	// fn 返回错误时停止遍历并关闭游标
+	stop := errors.New("stop")
+	calls := 0
+	err = C("lease").Each(ctx, bson.M{}, func(cur *mongo.Cursor) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Each() error = %v calls(%d), want %v calls(1)", err, calls, stop)
+	}
+	kills := m.Commands("killCursors")
+	if len(kills) != 1 || fmt.Sprint(kills[0].Doc["cursors"]) != "[42]" {
+		t.Errorf("Each() should kill the cursor on error, killCursors = %v", kills)
+	}
+}
+
+func TestUpdateCounts(t *testing.T) {
+	// 匹配 2 件，内容都没有变化
+	_, done := startMock(t, func(cmd mockCommand) bson.D {
+		if cmd.Name == "update" {
+			return bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 2}, {Key: "nModified", Value: 0}}
+		}
+		return nil
+	})
+	defer done()
+
+	ctx := context.Background()
+	update := bson.M{"$set": bson.M{"status": "active"}}
+	n, err := C("lease").Update(ctx, bson.M{}, update)
+	if err != nil || n != 2 {
+		t.Errorf("Update() = %d, %v, want 2", n, err)
+	}
+	n, err = C("lease").UpdateMany(ctx, bson.M{}, update)
+	if err != nil || n != 2 {
+		t.Errorf("UpdateMany() = %d, %v, want 2", n, err)
+	}
+}
@@ -0,0 +1,425 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type (
+	// TenantSpec 租户数据库的标准结构，开通租户时创建
+	TenantSpec struct {
+		// Collections 需要预先创建的集合
+		Collections []string
+		// Indexes 各集合的索引，集合不存在时随索引一起创建
+		Indexes map[string][]mongo.IndexModel
+	}
+
+	// TenantRouter 根据 context 中的租户ID找到租户的数据库（Db_租户ID），并缓存数据库的句柄
+	TenantRouter struct {
+		spec TenantSpec
+
+		mu  sync.RWMutex
+		dbs map[string]*mongo.Database
+	}
+
+	// tenantKey context 中保存租户ID的键
+	tenantKey struct{}
+
+	// viewSpec listCollections 返回的视图定义
+	viewSpec struct {
+		Name    string `bson:"name"`
+		Options bson.M `bson:"options"`
+	}
+
+	// viewAction 归档时对视图的处理
+	viewAction int
+)
+
+const (
+	// viewCreate 在归档数据库中重建后删除原视图
+	viewCreate viewAction = iota
+	// viewDrop 归档数据库中已有相同的视图（上次归档中途失败），只删除原视图
+	viewDrop
+	// viewKeep 保留原视图，归档不完整
+	viewKeep
+)
+
+// archiveInfix 归档数据库名中租户ID后的标记
+const archiveInfix = "_archived_"
+
+var (
+	// ErrNoTenant context 中没有租户ID
+	ErrNoTenant = errors.New("tenant is not set in context")
+	// ErrInvalidTenant 租户ID不能用于数据库名
+	ErrInvalidTenant = errors.New("invalid tenant id")
+	// ErrArchiveIncomplete 归档后租户数据库中还有未移动的集合
+	ErrArchiveIncomplete = errors.New("tenant archive incomplete")
+)
+
+// WithTenant 在 context 中设置租户ID
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext 获取 context 中的租户ID
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// NewTenantRouter 创建租户路由
+func NewTenantRouter(spec TenantSpec) *TenantRouter {
+	return &TenantRouter{
+		spec: spec,
+		dbs:  make(map[string]*mongo.Database),
+	}
+}
+
+// TenantDBName 租户的数据库名，租户ID包含数据库名不允许的字符时返回错误
+func TenantDBName(tenantID string) (string, error) {
+	if tenantID == "" || strings.ContainsAny(tenantID, `/\. "$*<>:|?`) || strings.Contains(tenantID, archiveInfix) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTenant, tenantID)
+	}
+	return GetDBName(tenantID), nil
+}
+
+// DBName 获取 context 中租户的数据库名
+func (r *TenantRouter) DBName(ctx context.Context) (string, error) {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", ErrNoTenant
+	}
+	return TenantDBName(tenantID)
+}
+
+// Database 获取 context 中租户的数据库
+func (r *TenantRouter) Database(ctx context.Context) (*mongo.Database, error) {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+	return r.database(tenantID)
+}
+
+// Collection 获取 context 中租户的集合的仓库，使用缓存的数据库句柄
+func (r *TenantRouter) Collection(ctx context.Context, name string) (*Repository, error) {
+	db, err := r.Database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return RepositoryOf(db, name), nil
+}
+
+// database 从缓存获取租户的数据库句柄
+func (r *TenantRouter) database(tenantID string) (*mongo.Database, error) {
+	r.mu.RLock()
+	db, ok := r.dbs[tenantID]
+	r.mu.RUnlock()
+	if ok {
+		return db, nil
+	}
+
+	name, err := TenantDBName(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	client := New()
+	if client == nil {
+		return nil, ErrNotStarted
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if db, ok := r.dbs[tenantID]; ok {
+		return db, nil
+	}
+	db = client.Database(name)
+	r.dbs[tenantID] = db
+	return db, nil
+}
+
+// Forget 清除租户的缓存
+func (r *TenantRouter) Forget(tenantID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.dbs, tenantID)
+}
+
+// Provision 开通租户：按 TenantSpec 创建集合和索引，重复执行不会出错
+func (r *TenantRouter) Provision(ctx context.Context, tenantID string) error {
+	db, err := r.database(tenantID)
+	if err != nil {
+		return err
+	}
+
+	s := time.Now()
+	for _, name := range r.spec.Collections {
+		if err := createCollection(ctx, db, name); err != nil {
+			log.Errorf("error Provision %v collection: %v tenant: %v", err, name, tenantID)
+			return err
+		}
+	}
+	for name, indexes := range r.spec.Indexes {
+		if len(indexes) == 0 {
+			continue
+		}
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			log.Errorf("error Provision %v collection: %v tenant: %v", err, name, tenantID)
+			return err
+		}
+	}
+
+	log.Infof("Provision took: %v tenant: %v db: %v", time.Since(s), tenantID, db.Name())
+	return nil
+}
+
+// Drop 删除租户的数据库
+func (r *TenantRouter) Drop(ctx context.Context, tenantID string) error {
+	db, err := r.database(tenantID)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Drop(ctx); err != nil {
+		log.Errorf("error Drop %v tenant: %v", err, tenantID)
+		return err
+	}
+	r.Forget(tenantID)
+
+	log.Infof("Drop tenant: %v db: %v", tenantID, db.Name())
+	return nil
+}
+
+// Archive 归档租户：把所有集合和视图移动到 Db_租户ID_archived_时间 的数据库中，返回归档的数据库名
+// 移动使用 renameCollection，不支持分片集合。
+// 中途失败时返回归档的数据库名和错误，已移动的集合保留在归档数据库中，
+// 用返回的数据库名调用 ArchiveTo 可以继续移动剩余的集合
+func (r *TenantRouter) Archive(ctx context.Context, tenantID string) (string, error) {
+	name, err := TenantDBName(tenantID)
+	if err != nil {
+		return "", err
+	}
+
+	archive := name + archiveInfix + time.Now().Format("20060102150405")
+	return archive, r.ArchiveTo(ctx, tenantID, archive)
+}
+
+// ArchiveTo 把租户的集合和视图移动到指定的归档数据库，可以重复执行
+// 归档数据库中已存在的集合不会被覆盖，依赖这些集合的视图也保留在租户数据库中，
+// 租户数据库中还有剩余的集合或视图时返回 ErrArchiveIncomplete 并保留租户数据库
+func (r *TenantRouter) ArchiveTo(ctx context.Context, tenantID, archive string) error {
+	db, err := r.database(tenantID)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(archive, db.Name()+archiveInfix) {
+		return fmt.Errorf("%w: %q is not an archive of %q", ErrInvalidTenant, archive, tenantID)
+	}
+
+	s := time.Now()
+	target := db.Client().Database(archive)
+	existing, err := target.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		log.Errorf("error Archive %v tenant: %v", err, tenantID)
+		return err
+	}
+	moved := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		moved[name] = true
+	}
+	// 归档数据库中已存在同名集合而没有移动的集合，依赖它们的视图不能移动
+	skipped := make(map[string]bool)
+
+	// 先移动集合，视图依赖的集合移动后再在归档数据库中重建视图
+	names, err := db.ListCollectionNames(ctx, bson.M{"type": "collection"})
+	if err != nil {
+		log.Errorf("error Archive %v tenant: %v", err, tenantID)
+		return err
+	}
+	admin := db.Client().Database("admin")
+	for _, name := range names {
+		if strings.HasPrefix(name, "system.") {
+			continue
+		}
+		if moved[name] {
+			log.Warnf("Archive skip collection: %v already exists in %v tenant: %v", name, archive, tenantID)
+			skipped[name] = true
+			continue
+		}
+		cmd := bson.D{
+			{Key: "renameCollection", Value: db.Name() + "." + name},
+			{Key: "to", Value: archive + "." + name},
+		}
+		if err := admin.RunCommand(ctx, cmd).Err(); err != nil {
+			log.Errorf("error Archive %v collection: %v tenant: %v", err, name, tenantID)
+			return err
+		}
+	}
+
+	if err := archiveViews(ctx, db, target, moved, skipped); err != nil {
+		log.Errorf("error Archive %v tenant: %v", err, tenantID)
+		return err
+	}
+
+	names, err = db.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var left []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, "system.") {
+			left = append(left, name)
+		}
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("%w: %v left in %v", ErrArchiveIncomplete, left, db.Name())
+	}
+
+	if err := db.Drop(ctx); err != nil {
+		return err
+	}
+	r.Forget(tenantID)
+
+	log.Infof("Archive took: %v tenant: %v db: %v", time.Since(s), tenantID, archive)
+	return nil
+}
+
+// archiveViews 在归档数据库中重建视图后删除原视图，处理方式见 planViews
+func archiveViews(ctx context.Context, db, target *mongo.Database, moved, skipped map[string]bool) error {
+	views, err := listViews(ctx, db)
+	if err != nil {
+		return err
+	}
+	archived, err := listViews(ctx, target)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]viewSpec, len(archived))
+	for _, v := range archived {
+		byName[v.Name] = v
+	}
+
+	plan := planViews(views, byName, moved, skipped)
+	for _, v := range views {
+		switch plan[v.Name] {
+		case viewKeep:
+			log.Warnf("Archive keep view: %v on %v db: %v", v.Name, v.Options["viewOn"], db.Name())
+			continue
+		case viewCreate:
+			cmd := bson.D{
+				{Key: "create", Value: v.Name},
+				{Key: "viewOn", Value: v.Options["viewOn"]},
+				{Key: "pipeline", Value: v.Options["pipeline"]},
+			}
+			if c, ok := v.Options["collation"]; ok {
+				cmd = append(cmd, bson.E{Key: "collation", Value: c})
+			}
+			if err := target.RunCommand(ctx, cmd).Err(); err != nil {
+				return err
+			}
+		}
+		if err := db.Collection(v.Name).Drop(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listViews 获取数据库中的视图定义
+func listViews(ctx context.Context, db *mongo.Database) ([]viewSpec, error) {
+	cur, err := db.ListCollections(ctx, bson.M{"type": "view"})
+	if err != nil {
+		return nil, err
+	}
+	var views []viewSpec
+	if err := cur.All(ctx, &views); err != nil {
+		return nil, err
+	}
+	return views, nil
+}
+
+// planViews 决定每个视图的处理
+// viewOn 指向跳过的集合或保留的视图时，在归档数据库中重建的视图会指向不同的数据，因此保留原视图；
+// 归档数据库中已有同名的视图时，定义相同则只删除原视图，不同则保留；已有同名的集合时保留
+func planViews(views []viewSpec, archived map[string]viewSpec, moved, skipped map[string]bool) map[string]viewAction {
+	plan := make(map[string]viewAction, len(views))
+	for _, v := range views {
+		switch a, ok := archived[v.Name]; {
+		case ok && sameView(a, v):
+			plan[v.Name] = viewDrop
+		case moved[v.Name]:
+			plan[v.Name] = viewKeep
+		default:
+			plan[v.Name] = viewCreate
+		}
+	}
+
+	// 视图可以建立在视图上，保留的视图会传递给依赖它的视图
+	for changed := true; changed; {
+		changed = false
+		for _, v := range views {
+			if plan[v.Name] == viewKeep {
+				continue
+			}
+			on, _ := v.Options["viewOn"].(string)
+			if action, isView := plan[on]; skipped[on] || (isView && action == viewKeep) {
+				plan[v.Name] = viewKeep
+				changed = true
+			}
+		}
+	}
+	return plan
+}
+
+// sameView 判断两个视图的定义是否相同
+func sameView(a, b viewSpec) bool {
+	return reflect.DeepEqual(a.Options["viewOn"], b.Options["viewOn"]) &&
+		reflect.DeepEqual(a.Options["pipeline"], b.Options["pipeline"]) &&
+		reflect.DeepEqual(a.Options["collation"], b.Options["collation"])
+}
+
+// ListTenants 获取所有租户的ID，即 Db_ 开头的数据库名的后缀，不包括归档的数据库
+func ListTenants(ctx context.Context) ([]string, error) {
+	client := New()
+	if client == nil {
+		return nil, ErrNotStarted
+	}
+
+	prefix := GetDBName("")
+	names, err := client.ListDatabaseNames(ctx, bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}})
+	if err != nil {
+		log.Errorf("error ListTenants %v", err)
+		return nil, err
+	}
+
+	var tenants []string
+	for _, name := range names {
+		tenantID := strings.TrimPrefix(name, prefix)
+		if tenantID == "" || strings.Contains(tenantID, archiveInfix) {
+			continue
+		}
+		tenants = append(tenants, tenantID)
+	}
+	return tenants, nil
+}
+
+// createCollection 创建集合，已存在时忽略
+func createCollection(ctx context.Context, db *mongo.Database, name string) error {
+	err := db.RunCommand(ctx, bson.D{{Key: "create", Value: name}}).Err()
+	var ce mongo.CommandError
+	if errors.As(err, &ce) && ce.Code == 48 { // NamespaceExists
+		return nil
+	}
+	return err
+}
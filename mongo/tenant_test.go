@@ -0,0 +1,180 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestTenantRouter(t *testing.T) {
+	// 不连接服务器，只用于取得数据库句柄
+	cli, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client, Db = cli, "Db"
+	defer func() { client, Db = nil, "" }()
+
+	r := NewTenantRouter(TenantSpec{})
+	if _, err := r.Database(context.Background()); err != ErrNoTenant {
+		t.Errorf("Database() without tenant error = %v, want ErrNoTenant", err)
+	}
+
+	ctx := WithTenant(context.Background(), "t001")
+	db, err := r.Database(ctx)
+	if err != nil {
+		t.Fatalf("Database() error = %v", err)
+	}
+	if db.Name() != "Db_t001" {
+		t.Errorf("Database() = %v, want Db_t001", db.Name())
+	}
+	if again, _ := r.Database(ctx); again != db {
+		t.Errorf("Database() should return the cached handle")
+	}
+	r.Forget("t001")
+	if again, _ := r.Database(ctx); again == db {
+		t.Errorf("Database() after Forget should return a new handle")
+	}
+
+	repo, err := r.Collection(ctx, "lease")
+	if err != nil || repo.Database() != "Db_t001" || repo.Name() != "lease" {
+		t.Errorf("Collection() = %+v, %v", repo, err)
+	}
+	// 仓库使用路由缓存的数据库句柄
+	cached, _ := r.Database(ctx)
+	if c, err := repo.Collection(); err != nil || c.Database() != cached {
+		t.Errorf("Collection() should use the cached database handle")
+	}
+
+	// 归档数据库必须属于该租户
+	for _, archive := range []string{"Db_t002_archived_1", "Db_t001", "other"} {
+		if err := r.ArchiveTo(ctx, "t001", archive); !errors.Is(err, ErrInvalidTenant) {
+			t.Errorf("ArchiveTo(%q) error = %v, want ErrInvalidTenant", archive, err)
+		}
+	}
+	if _, err := r.Archive(ctx, "a.b"); !errors.Is(err, ErrInvalidTenant) {
+		t.Errorf("Archive() error = %v, want ErrInvalidTenant", err)
+	}
+
+	for _, id := range []string{"a.b", "a/b", "a b", "x_archived_1", "$x"} {
+		if _, err := r.Database(WithTenant(context.Background(), id)); !errors.Is(err, ErrInvalidTenant) {
+			t.Errorf("Database(%q) error = %v, want ErrInvalidTenant", id, err)
+		}
+	}
+}
+
+func TestPlanViews(t *testing.T) {
+	view := func(name, on string, pipeline ...bson.M) viewSpec {
+		return viewSpec{Name: name, Options: bson.M{"viewOn": on, "pipeline": bson.A{pipeline}}}
+	}
+
+	// leases 在归档数据库中已存在而跳过，invoices 已移动
+	views := []viewSpec{
+		view("v_invoices", "invoices"),
+		view("v_leases", "leases"),
+		view("v_chain", "v_leases"),
+		view("v_done", "invoices", bson.M{"$match": bson.M{"done": true}}),
+		view("v_conflict", "invoices"),
+		view("v_name", "invoices"),
+	}
+	archived := map[string]viewSpec{
+		"v_done":     view("v_done", "invoices", bson.M{"$match": bson.M{"done": true}}),
+		"v_conflict": view("v_conflict", "invoices", bson.M{"$limit": 1}),
+	}
+	moved := map[string]bool{"leases": true, "invoices": true, "v_done": true, "v_conflict": true, "v_name": true}
+	skipped := map[string]bool{"leases": true}
+
+	got := planViews(views, archived, moved, skipped)
+	want := map[string]viewAction{
+		"v_invoices": viewCreate,
+		"v_leases":   viewKeep,
+		"v_chain":    viewKeep,
+		"v_done":     viewDrop,
+		"v_conflict": viewKeep,
+		"v_name":     viewKeep,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("planViews() = %v, want %v", got, want)
+	}
+}
+
+func TestArchiveToResume(t *testing.T) {
+	type entry struct {
+		typ     string
+		options bson.M
+	}
+	// 上次归档中途失败：leases 在归档数据库中已存在，源数据库中又有同名集合
+	dbs := map[string]map[string]entry{
+		"Db_t001": {
+			"leases":     {typ: "collection"},
+			"invoices":   {typ: "collection"},
+			"v_leases":   {typ: "view", options: bson.M{"viewOn": "leases", "pipeline": bson.A{}}},
+			"v_invoices": {typ: "view", options: bson.M{"viewOn": "invoices", "pipeline": bson.A{}}},
+		},
+		"Db_t001_archived_1": {
+			"leases": {typ: "collection"},
+		},
+	}
+
+	_, done := startMock(t, func(cmd mockCommand) bson.D {
+		db, _ := cmd.Doc["$db"].(string)
+		switch cmd.Name {
+		case "listCollections":
+			typ, _ := cmd.Doc["filter"].(bson.M)["type"].(string)
+			var names []string
+			for name, e := range dbs[db] {
+				if typ == "" || e.typ == typ {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			var docs []interface{}
+			for _, name := range names {
+				docs = append(docs, bson.M{"name": name, "type": dbs[db][name].typ, "options": dbs[db][name].options})
+			}
+			return cursorResponse(0, db+".$cmd.listCollections", "firstBatch", docs...)
+		case "renameCollection":
+			from := strings.SplitN(cmd.Doc["renameCollection"].(string), ".", 2)
+			to := strings.SplitN(cmd.Doc["to"].(string), ".", 2)
+			dbs[to[0]][to[1]] = dbs[from[0]][from[1]]
+			delete(dbs[from[0]], from[1])
+		case "create":
+			dbs[db][cmd.Doc["create"].(string)] = entry{typ: "view", options: bson.M{"viewOn": cmd.Doc["viewOn"], "pipeline": cmd.Doc["pipeline"]}}
+		case "drop":
+			delete(dbs[db], cmd.Doc["drop"].(string))
+		case "dropDatabase":
+			t.Errorf("incomplete archive should not drop the tenant database")
+		}
+		return nil
+	})
+	defer done()
+
+	r := NewTenantRouter(TenantSpec{})
+	err := r.ArchiveTo(context.Background(), "t001", "Db_t001_archived_1")
+	if !errors.Is(err, ErrArchiveIncomplete) {
+		t.Fatalf("ArchiveTo() error = %v, want ErrArchiveIncomplete", err)
+	}
+
+	// invoices 和依赖它的视图已移动，leases 及依赖它的视图保留
+	left := make([]string, 0)
+	for name := range dbs["Db_t001"] {
+		left = append(left, name)
+	}
+	sort.Strings(left)
+	if !reflect.DeepEqual(left, []string{"leases", "v_leases"}) {
+		t.Errorf("left in tenant db = %v", left)
+	}
+	if e, ok := dbs["Db_t001_archived_1"]["v_invoices"]; !ok || e.options["viewOn"] != "invoices" {
+		t.Errorf("v_invoices in archive = %+v, %v", e, ok)
+	}
+	if _, ok := dbs["Db_t001_archived_1"]["v_leases"]; ok {
+		t.Errorf("v_leases should not be created on the skipped collection")
+	}
+}
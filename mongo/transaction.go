@@ -0,0 +1,184 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+type (
+	// TxOptions 事务的配置，零值的项使用 DefaultTxOptions 的值
+	TxOptions struct {
+		// ReadConcern 事务内读隔离
+		ReadConcern *readconcern.ReadConcern
+		// WriteConcern 提交时的写隔离
+		WriteConcern *writeconcern.WriteConcern
+		// MaxRetries 事务整体或提交的最大重试次数，不重试时设为负数
+		MaxRetries int
+		// InitialBackoff 第一次重试前的等待时间，之后每次翻倍
+		InitialBackoff time.Duration
+		// MaxBackoff 重试等待时间的上限
+		MaxBackoff time.Duration
+	}
+
+	// labeledError 带错误标签的驱动错误，CommandError、WriteException、BulkWriteException 都实现了该接口
+	labeledError interface {
+		error
+		HasErrorLabel(label string) bool
+	}
+)
+
+const (
+	// transientTransactionError 事务可以整体重试
+	transientTransactionError = "TransientTransactionError"
+	// unknownTransactionCommitResult 提交结果未知，可以重试提交
+	unknownTransactionCommitResult = "UnknownTransactionCommitResult"
+)
+
+// DefaultTxOptions 默认的事务配置：snapshot 读，majority 写
+func DefaultTxOptions() TxOptions {
+	return TxOptions{
+		ReadConcern:    readconcern.Snapshot(),
+		WriteConcern:   writeconcern.New(writeconcern.WMajority()),
+		MaxRetries:     5,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+	}
+}
+
+// WithTransaction 在事务中执行 fn，opts 为 nil 时使用 DefaultTxOptions，opts 中零值的项也使用默认值
+// fn 中的读写必须使用传入的 sessCtx 作为 context，仓库等方法接收 context 的地方直接传 sessCtx 即可。
+// 带 TransientTransactionError 标签的错误会退避后重新执行整个事务，
+// 带 UnknownTransactionCommitResult 标签的错误只重试提交，因此 fn 可能被执行多次，不能有事务以外的副作用。
+func WithTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) error, opts *TxOptions) error {
+	client := New()
+	if client == nil {
+		return ErrNotStarted
+	}
+
+	o := mergeTxOptions(opts)
+
+	sess, err := client.StartSession()
+	if err != nil {
+		log.Errorf("start mongodb session error: %v", err)
+		return err
+	}
+	defer sess.EndSession(context.Background())
+
+	txOpts := options.Transaction().
+		SetReadPreference(readpref.Primary()) // 事务只能在 primary 上执行
+	if o.ReadConcern != nil {
+		txOpts.SetReadConcern(o.ReadConcern)
+	}
+	if o.WriteConcern != nil {
+		txOpts.SetWriteConcern(o.WriteConcern)
+	}
+
+	s := time.Now()
+	backoff := o.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err = mongo.WithSession(ctx, sess, func(sessCtx mongo.SessionContext) error {
+			if err := sess.StartTransaction(txOpts); err != nil {
+				return err
+			}
+			if err := fn(sessCtx); err != nil {
+				sess.AbortTransaction(context.Background())
+				return err
+			}
+			return commit(sessCtx, sess, o)
+		})
+		if err == nil {
+			log.Infof("WithTransaction took: %v attempts(%v)", time.Since(s), attempt+1)
+			return nil
+		}
+		if !hasErrorLabel(err, transientTransactionError) || attempt >= o.MaxRetries {
+			log.Errorf("error WithTransaction %v attempts(%v)", err, attempt+1)
+			return err
+		}
+
+		log.Warnf("WithTransaction retry after %v: %v", backoff, err)
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = nextBackoff(backoff, o.MaxBackoff)
+	}
+}
+
+// mergeTxOptions 用默认值补全 opts 中零值的项
+func mergeTxOptions(opts *TxOptions) TxOptions {
+	o := DefaultTxOptions()
+	if opts == nil {
+		return o
+	}
+
+	if opts.ReadConcern != nil {
+		o.ReadConcern = opts.ReadConcern
+	}
+	if opts.WriteConcern != nil {
+		o.WriteConcern = opts.WriteConcern
+	}
+	if opts.MaxRetries != 0 {
+		o.MaxRetries = opts.MaxRetries
+	}
+	if opts.InitialBackoff > 0 {
+		o.InitialBackoff = opts.InitialBackoff
+	}
+	if opts.MaxBackoff > 0 {
+		o.MaxBackoff = opts.MaxBackoff
+	}
+	return o
+}
+
+// commit 提交事务，结果未知时退避后重试提交
+func commit(ctx context.Context, sess mongo.Session, o TxOptions) error {
+	backoff := o.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := sess.CommitTransaction(ctx)
+		if err == nil {
+			return nil
+		}
+		if !hasErrorLabel(err, unknownTransactionCommitResult) || attempt >= o.MaxRetries {
+			return err
+		}
+
+		log.Warnf("CommitTransaction retry after %v: %v", backoff, err)
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = nextBackoff(backoff, o.MaxBackoff)
+	}
+}
+
+// hasErrorLabel 判断错误是否带有指定的标签
+func hasErrorLabel(err error, label string) bool {
+	var le labeledError
+	return errors.As(err, &le) && le.HasErrorLabel(label)
+}
+
+// nextBackoff 下一次的等待时间
+func nextBackoff(cur, max time.Duration) time.Duration {
+	next := cur * 2
+	if max > 0 && next > max {
+		next = max
+	}
+	return next
+}
+
+// sleep 等待指定时间，context 结束时返回错误
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
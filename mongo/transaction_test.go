@@ -0,0 +1,75 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+)
+
+func TestHasErrorLabel(t *testing.T) {
+	transient := mongo.CommandError{Code: 112, Labels: []string{transientTransactionError}}
+	unknown := mongo.WriteException{Labels: []string{unknownTransactionCommitResult}}
+
+	tests := []struct {
+		err   error
+		label string
+		want  bool
+	}{
+		{transient, transientTransactionError, true},
+		{fmt.Errorf("create lease: %w", transient), transientTransactionError, true},
+		{transient, unknownTransactionCommitResult, false},
+		{unknown, unknownTransactionCommitResult, true},
+		{context.Canceled, transientTransactionError, false},
+	}
+	for _, tt := range tests {
+		if got := hasErrorLabel(tt.err, tt.label); got != tt.want {
+			t.Errorf("hasErrorLabel(%v, %s) = %v, want %v", tt.err, tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	d := 50 * time.Millisecond
+	var got []time.Duration
+	for i := 0; i < 4; i++ {
+		d = nextBackoff(d, 300*time.Millisecond)
+		got = append(got, d)
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("nextBackoff() = %v, want %v", got, want)
+	}
+}
+
+func TestWithTransactionNotStarted(t *testing.T) {
+	err := WithTransaction(context.Background(), func(mongo.SessionContext) error { return nil }, nil)
+	if err != ErrNotStarted {
+		t.Errorf("WithTransaction() error = %v, want ErrNotStarted", err)
+	}
+}
+
+func TestMergeTxOptions(t *testing.T) {
+	def := DefaultTxOptions()
+	if got := mergeTxOptions(nil); !reflect.DeepEqual(got, def) {
+		t.Errorf("mergeTxOptions(nil) = %+v, want %+v", got, def)
+	}
+
+	// 只设置部分项时其余项使用默认值
+	got := mergeTxOptions(&TxOptions{ReadConcern: readconcern.Majority(), MaxBackoff: time.Second})
+	if got.ReadConcern.GetLevel() != "majority" || got.MaxBackoff != time.Second {
+		t.Errorf("mergeTxOptions() overridden = %+v", got)
+	}
+	if got.MaxRetries != def.MaxRetries || got.InitialBackoff != def.InitialBackoff || !reflect.DeepEqual(got.WriteConcern, def.WriteConcern) {
+		t.Errorf("mergeTxOptions() defaults = %+v, want %+v", got, def)
+	}
+
+	// 负数表示不重试
+	if got := mergeTxOptions(&TxOptions{MaxRetries: -1}); got.MaxRetries != -1 || got.InitialBackoff != def.InitialBackoff {
+		t.Errorf("mergeTxOptions(MaxRetries: -1) = %+v", got)
+	}
+}
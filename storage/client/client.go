@@ -8,6 +8,7 @@ import (
 	"rxcsoft.cn/utils/logger"
 	"rxcsoft.cn/utils/storage"
 	"rxcsoft.cn/utils/storage/gcs"
+	"rxcsoft.cn/utils/storage/gridfs"
 	"rxcsoft.cn/utils/storage/minio"
 )
 
@@ -33,6 +34,16 @@ func InitStorageClient() {
 			len(storageConfig.Bucket) > 0 &&
 			len(storageConfig.PublicPath) > 0)
 
+		// 判断服务的必要字段是否为空，空则抛出错误
+		if !isEmpty {
+			panic(errors.New("storage config has error"))
+		}
+	case "gridfs":
+		isEmpty := (len(storageConfig.Endpoint) > 0 &&
+			len(storageConfig.SecretKey) > 0 &&
+			len(storageConfig.Bucket) > 0 &&
+			len(storageConfig.PublicPath) > 0)
+
 		// 判断服务的必要字段是否为空，空则抛出错误
 		if !isEmpty {
 			panic(errors.New("storage config has error"))
@@ -98,6 +109,28 @@ func NewClient(bName string) (cli storage.Service, err error) {
 		return client, nil
 	}
 
+	if storageConfig.Platform == "gridfs" {
+		bn := storageConfig.Bucket
+		if len(bName) > 0 {
+			bn = fmt.Sprintf("%s-%s", storageConfig.Bucket, bName)
+		}
+		// 使用 mongo 包的连接，需要先启动 mongodb
+		client := &gridfs.Service{
+			Endpoint:   storageConfig.Endpoint,
+			SecretKey:  storageConfig.SecretKey,
+			Region:     storageConfig.Region,
+			BucketName: bn,
+			PublicPath: storageConfig.PublicPath,
+		}
+
+		log.Infof("InitStorageClient gridfs bucket: %v", bn)
+		if err := client.Initialize(); err != nil {
+			log.Infof("InitStorageClient has error: %v", err)
+			return nil, err
+		}
+		return client, nil
+	}
+
 	var client *minio.Service
 
 	if len(bName) > 0 {
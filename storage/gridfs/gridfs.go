@@ -0,0 +1,439 @@
+package gridfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"rxcsoft.cn/utils/logger"
+	rxmongo "rxcsoft.cn/utils/mongo"
+	"rxcsoft.cn/utils/storage"
+)
+
+type (
+	// Service 保存到 mongodb GridFS 的文件服务，用于没有对象存储的环境
+	// 对象名即 GridFS 的文件名，例如 "public/2020/report.pdf"，前缀按文件名的前缀匹配
+	// 使用 mongo 包的连接，需要先调用 mongo.Start
+	Service struct {
+		// Endpoint 分享链接的地址，即挂载 Service（http.Handler）的 URL，例如 "https://example.com/files"
+		Endpoint string
+		// Region 没有意义，保留以实现接口
+		Region string
+		// BucketName GridFS 的 bucket 名，集合为 <BucketName>.files 和 <BucketName>.chunks
+		BucketName string
+		// PublicPath 公共路径
+		PublicPath string
+		// SecretKey 分享链接签名的密钥
+		SecretKey string
+		// Database 数据库名，为空时使用 mongo 包的数据库
+		Database string
+		// URLExpiry 分享链接的有效期，为 0 时为一天
+		URLExpiry time.Duration
+
+		bucket *gridfs.Bucket
+		files  *mongo.Collection
+	}
+
+	// fileMeta 文件的 metadata
+	fileMeta struct {
+		ContentType string `bson:"contentType"`
+	}
+)
+
+var log = logger.New()
+
+// GetBucketName 获取bucket名
+func (svc *Service) GetBucketName() string {
+	return svc.BucketName
+}
+
+// GetPublicPath 获取公共路径
+func (svc *Service) GetPublicPath() string {
+	return svc.PublicPath
+}
+
+// GetRegion 获取区域
+func (svc *Service) GetRegion() string {
+	return svc.Region
+}
+
+// GetEndpoint 获取端点
+func (svc *Service) GetEndpoint() string {
+	return svc.Endpoint
+}
+
+// Initialize 初始化客户端
+func (svc *Service) Initialize() error {
+	// 盘点是否存在客户端，存在则直接返回
+	if svc.bucket != nil {
+		return nil
+	}
+	// 判断服务的必要字段是否为空，空则抛出错误
+	if ok := (svc.BucketName != "" &&
+		svc.SecretKey != "" &&
+		svc.PublicPath != ""); !ok {
+		return fmt.Errorf("Invalid service struct: %v", svc)
+	}
+
+	client := rxmongo.New()
+	if client == nil {
+		return fmt.Errorf("Unable to create storage service: %v", rxmongo.ErrNotStarted)
+	}
+	dbName := svc.Database
+	if dbName == "" {
+		dbName = rxmongo.Db
+	}
+	db := client.Database(dbName)
+
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(svc.BucketName))
+	if err != nil {
+		return fmt.Errorf("Unable to create storage service: %v", err)
+	}
+
+	svc.bucket = bucket
+	svc.files = bucket.GetFilesCollection()
+	return nil
+}
+
+// NewObject 基础的创建一个文件对象，同名的文件会被覆盖
+func (svc *Service) NewObject(objectName string, file io.Reader, contentType string) (*storage.ObjectInfo, error) {
+	objectName = cleanName(objectName)
+	opts := options.GridFSUpload().SetMetadata(fileMeta{ContentType: contentType})
+	id, err := svc.bucket.UploadFromStream(objectName, file, opts)
+	if err != nil {
+		log.Errorf("gridfs.UploadFromStream failed: %v", err)
+		return nil, err
+	}
+
+	// GridFS 同名的文件作为新版本保存，删除旧版本以与对象存储相同
+	if err := svc.deleteFiles(bson.M{"filename": objectName, "_id": bson.M{"$ne": id}}); err != nil {
+		log.Warnf("error NewObject delete old revisions: %v[%v/%v]", err, svc.BucketName, objectName)
+	}
+
+	return svc.GetObjectInfo(objectName)
+}
+
+// SaveObject 保存为随机名称的文件对象
+func (svc *Service) SaveObject(file io.Reader, path, contentType string) (*storage.ObjectInfo, error) {
+	objectName := generateObjectName(path)
+	return svc.NewObject(objectName, file, contentType)
+}
+
+// 生成带路径的文件名
+func generateObjectName(filePath string) string {
+	paths, fileName := filepath.Split(filePath)
+	name := time.Now().Format("20060102030405") + "_" + fileName
+	return path.Join(paths, name)
+}
+
+// createPublicObject 创建公共路径下的文件对象
+func (svc *Service) createPublicObject(objectName string, file io.Reader, contentType string) (*storage.ObjectInfo, error) {
+	objectName = fmt.Sprintf("%s/%s", svc.PublicPath, objectName)
+	return svc.NewObject(objectName, file, contentType)
+}
+
+// SavePublicObject 保存文件对象到公共路径下
+func (svc *Service) SavePublicObject(file io.Reader, path, contentType string) (*storage.ObjectInfo, error) {
+	fileName := generateObjectName(path)
+	return svc.createPublicObject(
+		fileName,
+		file,
+		contentType,
+	)
+}
+
+// CopyObject 复制文件对象
+func (svc *Service) CopyObject(srcObjectName, dstObjectName string) (*storage.ObjectInfo, error) {
+	src, err := svc.bucket.OpenDownloadStreamByName(cleanName(srcObjectName))
+	if err != nil {
+		log.Errorf("gridfs.OpenDownloadStreamByName in gridfs.CopyObject failed: %v", err)
+		return nil, err
+	}
+	defer src.Close()
+
+	return svc.NewObject(dstObjectName, src, contentTypeOf(src.GetFile()))
+}
+
+// GetObject 获取文件对象
+func (svc *Service) GetObject(objectName string) (io.ReadCloser, error) {
+	object, err := svc.bucket.OpenDownloadStreamByName(cleanName(objectName))
+	if err != nil {
+		log.Errorf("Error GetObject '%s/%s': %v", svc.BucketName, objectName, err)
+		return nil, err
+	}
+	return object, nil
+}
+
+// DeleteObject 基础的删除文件对象
+func (svc *Service) DeleteObject(objectName string) error {
+	n, err := svc.countFiles(bson.M{"filename": cleanName(objectName)})
+	if err == nil && n == 0 {
+		err = gridfs.ErrFileNotFound
+	}
+	if err == nil {
+		err = svc.deleteFiles(bson.M{"filename": cleanName(objectName)})
+	}
+	if err != nil {
+		log.Warnf("error DeleteObject: %v[%v/%v]", err, svc.BucketName, objectName)
+		return err
+	}
+	return nil
+}
+
+// DeleteBucket 删除桶中的所有文件
+func (svc *Service) DeleteBucket() error {
+	if err := svc.bucket.Drop(); err != nil {
+		log.Warnf("error DeleteBucket: %v[%v]", err, svc.BucketName)
+		return err
+	}
+	return nil
+}
+
+// DeletePath 删除当前路径下的的所有文件，包括公共路径下的同名路径，返回删除的大小
+func (svc *Service) DeletePath(ph string) (int64, error) {
+	var total int64
+	for _, prefix := range []string{path.Join(svc.PublicPath, ph), path.Join(ph)} {
+		files, err := svc.listFiles(prefix, true)
+		if err != nil {
+			return total, err
+		}
+		for _, f := range files {
+			if err := svc.bucket.Delete(f.ID); err != nil && err != gridfs.ErrFileNotFound {
+				log.Warnf("error DeletePath: %v[%v/%v]", err, svc.BucketName, f.Name)
+				return total, err
+			}
+			total += f.Length
+		}
+	}
+	return total, nil
+}
+
+// GetObjectInfo 获取文件对象的详细情报
+func (svc *Service) GetObjectInfo(objectName string) (*storage.ObjectInfo, error) {
+	objectName = cleanName(objectName)
+	opts := options.Find().SetSort(bson.D{{Key: "uploadDate", Value: -1}}).SetLimit(1)
+	cur, err := svc.files.Find(context.Background(), bson.M{"filename": objectName}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var files []gridfs.File
+	if err := cur.All(context.Background(), &files); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, gridfs.ErrFileNotFound
+	}
+	return svc.objectInfo(&files[0]), nil
+}
+
+// GetSharedURL 获取文件的临时分享链接，链接由 Service 的 ServeHTTP 验证签名后返回文件
+func (svc *Service) GetSharedURL(objectName string) (string, error) {
+	expiry := svc.URLExpiry
+	if expiry <= 0 {
+		expiry = 24 * time.Hour
+	}
+	return svc.signURL(cleanName(objectName), time.Now().Add(expiry)), nil
+}
+
+// GetListObjects 获取所有文件，recursive 为 false 时只返回前缀下一层的文件和以 "/" 结尾的文件夹
+func (svc *Service) GetListObjects(prefix string, recursive bool) ([]string, error) {
+	files, err := svc.listFiles(prefix, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if !seen[f.Name] {
+			seen[f.Name] = true
+			objects = append(objects, f.Name)
+		}
+	}
+	if !recursive {
+		objects = append(objects, svc.listFolders(prefix)...)
+		sort.Strings(objects)
+	}
+	return objects, nil
+}
+
+// GetFolderSize 获取文件夹大小
+func (svc *Service) GetFolderSize(prefix string, recursive bool) (int64, error) {
+	files, err := svc.listFiles(prefix, recursive)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, f := range files {
+		size += f.Length
+	}
+	return size, nil
+}
+
+// CopyPath 复制一个文件夹，返回复制的大小
+func (svc *Service) CopyPath(src, dst string, recursive bool) (int64, error) {
+	files, err := svc.listFiles(path.Join(src), recursive)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, f := range files {
+		name := strings.Replace(f.Name, path.Join(src), path.Join(dst), 1)
+		if _, err := svc.copyFile(&f, name); err != nil {
+			log.Errorf("gridfs.CopyPath failed: %v[%v/%v]", err, svc.BucketName, f.Name)
+			return total, err
+		}
+		total += f.Length
+	}
+	return total, nil
+}
+
+// RenameFolder 将文件夹名改为另一个，GridFS 只需要修改文件名，不复制数据
+func (svc *Service) RenameFolder(src, dst string) error {
+	files, err := svc.listFiles(path.Join(src), true)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		name := strings.Replace(f.Name, path.Join(src), path.Join(dst), 1)
+		if err := svc.bucket.Rename(f.ID, name); err != nil {
+			log.Warnf("error RenameFolder: %v[%v/%v]", err, svc.BucketName, f.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile 复制一个文件
+func (svc *Service) copyFile(f *gridfs.File, name string) (*storage.ObjectInfo, error) {
+	src, err := svc.bucket.OpenDownloadStream(f.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	return svc.NewObject(name, src, contentTypeOf(f))
+}
+
+// listFiles 获取前缀下的文件，recursive 为 false 时只包括下一层的文件
+func (svc *Service) listFiles(prefix string, recursive bool) ([]gridfs.File, error) {
+	prefix = cleanPrefix(prefix)
+	pattern := "^" + regexp.QuoteMeta(prefix)
+	if !recursive {
+		pattern += "[^/]*$"
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "filename", Value: 1}, {Key: "uploadDate", Value: -1}})
+	cur, err := svc.files.Find(context.Background(), bson.M{"filename": bson.M{"$regex": pattern}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var files []gridfs.File
+	if err := cur.All(context.Background(), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// listFolders 获取前缀下一层的文件夹，以 "/" 结尾
+func (svc *Service) listFolders(prefix string) []string {
+	prefix = cleanPrefix(prefix)
+	pattern := "^" + regexp.QuoteMeta(prefix) + "[^/]*/"
+	names, err := svc.files.Distinct(context.Background(), "filename", bson.M{"filename": bson.M{"$regex": pattern}})
+	if err != nil {
+		log.Warnf("error GetListObjects: %v[%v/%v]", err, svc.BucketName, prefix)
+		return nil
+	}
+
+	var folders []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		name, _ := n.(string)
+		rest := strings.TrimPrefix(name, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			folder := prefix + rest[:i+1]
+			if !seen[folder] {
+				seen[folder] = true
+				folders = append(folders, folder)
+			}
+		}
+	}
+	return folders
+}
+
+// countFiles 获取满足条件的文件数
+func (svc *Service) countFiles(filter interface{}) (int64, error) {
+	return svc.files.CountDocuments(context.Background(), filter)
+}
+
+// deleteFiles 删除满足条件的文件和数据块
+func (svc *Service) deleteFiles(filter interface{}) error {
+	cur, err := svc.files.Find(context.Background(), filter)
+	if err != nil {
+		return err
+	}
+	var files []gridfs.File
+	if err := cur.All(context.Background(), &files); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := svc.bucket.Delete(f.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectInfo 转换为文件详细情报
+func (svc *Service) objectInfo(f *gridfs.File) *storage.ObjectInfo {
+	return &storage.ObjectInfo{
+		Name:         f.Name,
+		MediaLink:    fmt.Sprintf("/storage/%s/%s", svc.BucketName, f.Name),
+		SelfLink:     fmt.Sprintf("%s/%s", svc.BucketName, f.Name),
+		ContentType:  contentTypeOf(f),
+		Size:         f.Length,
+		ETag:         fmt.Sprint(f.ID),
+		LastModified: f.UploadDate,
+	}
+}
+
+// contentTypeOf 获取文件 metadata 中的文件类型
+func contentTypeOf(f *gridfs.File) string {
+	var meta fileMeta
+	if len(f.Metadata) > 0 {
+		bson.Unmarshal(f.Metadata, &meta)
+	}
+	return meta.ContentType
+}
+
+// cleanName 规范化对象名，去掉开头的 "/" 和多余的路径
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// cleanPrefix 规范化前缀，保留结尾的 "/"，与对象存储相同按字符串前缀匹配
+func cleanPrefix(prefix string) string {
+	if prefix == "" || prefix == "/" {
+		return ""
+	}
+	cleaned := cleanName(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		cleaned += "/"
+	}
+	return cleaned
+}
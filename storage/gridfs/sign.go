@@ -0,0 +1,102 @@
+package gridfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+var (
+	// ErrInvalidSignature 分享链接的签名不正确
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrURLExpired 分享链接已过期
+	ErrURLExpired = errors.New("url expired")
+)
+
+// signURL 生成带有效期和签名的分享链接
+func (svc *Service) signURL(objectName string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", svc.sign(objectName, exp))
+
+	u := url.URL{Path: "/" + objectName}
+	return strings.TrimRight(svc.Endpoint, "/") + u.EscapedPath() + "?" + q.Encode()
+}
+
+// sign 计算对象名和有效期的签名
+func (svc *Service) sign(objectName, expires string) string {
+	mac := hmac.New(sha256.New, []byte(svc.SecretKey))
+	io.WriteString(mac, svc.BucketName+"\n"+objectName+"\n"+expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 验证分享链接的签名和有效期
+func (svc *Service) Verify(objectName, expires, signature string, now time.Time) error {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(svc.sign(cleanName(objectName), expires)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	if now.Unix() > exp {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+// ServeHTTP 返回分享链接的文件，请求的路径为对象名，挂载时用 http.StripPrefix 去掉 Endpoint 的路径
+//
+//	http.Handle("/files/", http.StripPrefix("/files", svc))
+func (svc *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	objectName := cleanName(r.URL.Path)
+	q := r.URL.Query()
+	if err := svc.Verify(objectName, q.Get("expires"), q.Get("signature"), time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	object, err := svc.bucket.OpenDownloadStreamByName(objectName)
+	if err != nil {
+		if err == gridfs.ErrFileNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		log.Errorf("Error ServeHTTP '%s/%s': %v", svc.BucketName, objectName, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+
+	file := object.GetFile()
+	if ct := contentTypeOf(file); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Length, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(path.Base(objectName))))
+	w.Header().Set("Last-Modified", file.UploadDate.UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := io.Copy(w, object); err != nil {
+		log.Warnf("error ServeHTTP: %v[%v/%v]", err, svc.BucketName, objectName)
+	}
+}
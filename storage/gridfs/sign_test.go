@@ -0,0 +1,79 @@
+package gridfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignURL(t *testing.T) {
+	svc := &Service{Endpoint: "https://example.com/files/", BucketName: "docs", SecretKey: "secret"}
+	expires := time.Unix(1600000000, 0)
+
+	raw := svc.signURL("public/報告 1.pdf", expires)
+	if !strings.HasPrefix(raw, "https://example.com/files/public/%E5%A0%B1%E5%91%8A%201.pdf?") {
+		t.Fatalf("signURL() = %v", raw)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimPrefix(u.Path, "/files")
+	q := u.Query()
+
+	if err := svc.Verify(name, q.Get("expires"), q.Get("signature"), expires); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := svc.Verify(name, q.Get("expires"), q.Get("signature"), expires.Add(time.Second)); err != ErrURLExpired {
+		t.Errorf("Verify() after expiry error = %v, want %v", err, ErrURLExpired)
+	}
+	if err := svc.Verify("public/other.pdf", q.Get("expires"), q.Get("signature"), expires); err != ErrInvalidSignature {
+		t.Errorf("Verify() other object error = %v, want %v", err, ErrInvalidSignature)
+	}
+	if err := svc.Verify(name, "1700000000", q.Get("signature"), expires); err != ErrInvalidSignature {
+		t.Errorf("Verify() changed expires error = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	other := &Service{BucketName: "docs-2", SecretKey: "secret"}
+	if err := other.Verify(name, q.Get("expires"), q.Get("signature"), expires); err != ErrInvalidSignature {
+		t.Errorf("Verify() other bucket error = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestServeHTTPForbidden(t *testing.T) {
+	svc := &Service{BucketName: "docs", SecretKey: "secret"}
+
+	rec := httptest.NewRecorder()
+	svc.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/public/a.pdf?expires=1&signature=x", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("ServeHTTP() code = %v, want %v", rec.Code, http.StatusForbidden)
+	}
+
+	rec = httptest.NewRecorder()
+	svc.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/public/a.pdf", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("ServeHTTP() code = %v, want %v", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestCleanPrefix(t *testing.T) {
+	tests := map[string]string{
+		"":            "",
+		"/":           "",
+		"/public/a/":  "public/a/",
+		"public//a":   "public/a",
+		"./public/a/": "public/a/",
+	}
+	for in, want := range tests {
+		if got := cleanPrefix(in); got != want {
+			t.Errorf("cleanPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := cleanName("/public/../a.pdf"); got != "a.pdf" {
+		t.Errorf("cleanName() = %q", got)
+	}
+}